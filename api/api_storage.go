@@ -360,6 +360,10 @@ type SectorInfo struct {
 	ToUpgrade            bool
 	ReplicaUpdateMessage *cid.Cid
 
+	// epochs between the chain head and the precommit cutoff of the sector when it
+	// was queued for precommit, 0 if unknown
+	PreCommitCutoffMargin abi.ChainEpoch
+
 	LastErr string
 
 	Log []SectorLog
@@ -35,6 +35,7 @@ import (
 	"github.com/filecoin-project/lotus/lib/strle"
 	"github.com/filecoin-project/lotus/lib/tablewriter"
 	sealing "github.com/filecoin-project/lotus/storage/pipeline"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
 )
 
 var sectorsCmd = &cli.Command{
@@ -2055,6 +2056,10 @@ var sectorsBatchingPendingPreCommit = &cli.Command{
 					fmt.Printf("\t\t%d\tOK\n", sector)
 				}
 			}
+
+			sum := sealiface.SummarizeFlush(res)
+			fmt.Printf("Sent %d sectors in %d messages, %d failed; deposit %s, fees up to %s\n",
+				sum.SectorsSent, sum.Messages, sum.SectorsFailed, types.FIL(sum.Deposit), types.FIL(sum.Fees))
 			return nil
 		}
 
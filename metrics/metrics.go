@@ -47,6 +47,8 @@ var (
 	WorkerHostname, _ = tag.NewKey("worker_hostname")
 	StorageID, _      = tag.NewKey("storage_id")
 	SectorState, _    = tag.NewKey("sector_state")
+	LockHolder, _     = tag.NewKey("lock_holder")
+	SendType, _       = tag.NewKey("send_type")
 
 	// rcmgr
 	ServiceID, _  = tag.NewKey("svc")
@@ -125,6 +127,17 @@ var (
 
 	SectorStates = stats.Int64("sealing/states", "Number of sectors in each state", stats.UnitDimensionless)
 
+	PreCommitBatchDepositWarn  = stats.Int64("sealing/precommit_batch_deposit_warn", "Counter of precommit batches with deposit above the warning threshold", stats.UnitDimensionless)
+	PreCommitBatchAggregateFee = stats.Float64("sealing/precommit_batch_aggregate_fee", "Aggregate network fee of sent precommit batches in FIL", stats.UnitDimensionless)
+	PreCommitDepositShortfall  = stats.Float64("sealing/precommit_deposit_shortfall", "Deposit of queued precommits not covered by available funds in FIL", stats.UnitDimensionless)
+	PreCommitEmergencySends    = stats.Int64("sealing/precommit_emergency_sends", "Counter of precommit sends made because a sector was close to its cutoff", stats.UnitDimensionless)
+	PreCommitCostPerSector     = stats.Float64("sealing/precommit_cost_per_sector", "Fees of sent precommit messages per sector, excluding deposits, in FIL", stats.UnitDimensionless)
+	PreCommitBatcherLockHeld   = stats.Float64("sealing/precommit_batcher_lock_held_ms", "Time the precommit batcher lock was held", stats.UnitMilliseconds)
+	PreCommitQueued            = stats.Int64("sealing/precommit_queued", "Number of precommits queued in the batcher", stats.UnitDimensionless)
+	PreCommitBatchSize         = stats.Int64("sealing/precommit_batch_size", "Number of sectors in sent precommit batch messages", stats.UnitDimensionless)
+	PreCommitQueueTime         = stats.Float64("sealing/precommit_queue_time_ms", "Time sectors spent queued before their precommit was sent", stats.UnitMilliseconds)
+	PreCommitSends             = stats.Int64("sealing/precommit_sends", "Counter of sent precommit messages", stats.UnitDimensionless)
+
 	StorageFSAvailable      = stats.Float64("storage/path_fs_available_frac", "Fraction of filesystem available storage", stats.UnitDimensionless)
 	StorageAvailable        = stats.Float64("storage/path_available_frac", "Fraction of available storage", stats.UnitDimensionless)
 	StorageReserved         = stats.Float64("storage/path_reserved_frac", "Fraction of reserved storage", stats.UnitDimensionless)
@@ -377,6 +390,61 @@ var (
 		Aggregation: view.LastValue(),
 		TagKeys:     []tag.Key{SectorState},
 	}
+	PreCommitBatchDepositWarnView = &view.View{
+		Measure:     PreCommitBatchDepositWarn,
+		Aggregation: view.Count(),
+	}
+	PreCommitBatchAggregateFeeView = &view.View{
+		Measure:     PreCommitBatchAggregateFee,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{MinerID},
+	}
+	PreCommitBatchAggregateFeeTotalView = &view.View{
+		Name:        "sealing/precommit_batch_aggregate_fee_total",
+		Description: "Aggregate network fee of precommit batches sent by all miners in FIL",
+		Measure:     PreCommitBatchAggregateFee,
+		Aggregation: view.Sum(),
+	}
+	PreCommitDepositShortfallView = &view.View{
+		Measure:     PreCommitDepositShortfall,
+		Aggregation: view.LastValue(),
+	}
+	PreCommitEmergencySendsView = &view.View{
+		Measure:     PreCommitEmergencySends,
+		Aggregation: view.Count(),
+	}
+	PreCommitCostPerSectorView = &view.View{
+		Measure:     PreCommitCostPerSector,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{MinerID},
+	}
+	PreCommitBatcherLockHeldView = &view.View{
+		Measure:     PreCommitBatcherLockHeld,
+		Aggregation: defaultMillisecondsDistribution,
+		TagKeys:     []tag.Key{LockHolder},
+	}
+	PreCommitQueuedView = &view.View{
+		Measure:     PreCommitQueued,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{MinerID},
+	}
+	PreCommitBatchSizeView = &view.View{
+		Measure:     PreCommitBatchSize,
+		Aggregation: view.Distribution(1, 2, 4, 8, 16, 32, 64, 96, 128, 160, 192, 224, 256),
+		TagKeys:     []tag.Key{MinerID},
+	}
+	PreCommitQueueTimeView = &view.View{
+		Measure: PreCommitQueueTime,
+		// 1 minute to 2 days
+		Aggregation: view.Distribution(60_000, 5*60_000, 15*60_000, 30*60_000, 60*60_000, 2*60*60_000, 4*60*60_000, 8*60*60_000,
+			12*60*60_000, 16*60*60_000, 20*60*60_000, 24*60*60_000, 28*60*60_000, 32*60*60_000, 48*60*60_000),
+		TagKeys: []tag.Key{MinerID},
+	}
+	PreCommitSendsView = &view.View{
+		Measure:     PreCommitSends,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{MinerID, SendType},
+	}
 	StorageFSAvailableView = &view.View{
 		Measure:     StorageFSAvailable,
 		Aggregation: view.LastValue(),
@@ -698,6 +766,17 @@ var MinerNodeViews = append([]*view.View{
 	WorkerUntrackedCallsReturnedView,
 	WorkerCallsReturnedDurationView,
 	SectorStatesView,
+	PreCommitBatchDepositWarnView,
+	PreCommitBatchAggregateFeeView,
+	PreCommitBatchAggregateFeeTotalView,
+	PreCommitDepositShortfallView,
+	PreCommitEmergencySendsView,
+	PreCommitCostPerSectorView,
+	PreCommitBatcherLockHeldView,
+	PreCommitQueuedView,
+	PreCommitBatchSizeView,
+	PreCommitQueueTimeView,
+	PreCommitSendsView,
 	StorageFSAvailableView,
 	StorageAvailableView,
 	StorageReservedView,
@@ -125,6 +125,19 @@ func DefaultStorageMiner() *StorageMiner {
 			// XXX snap deals wait deals slack if first
 			PreCommitBatchSlack: Duration(3 * time.Hour), // time buffer for forceful batch submission before sectors/deals in batch would start expiring, higher value will lower the chances for message fail due to expiration
 
+			PreCommitBatchDepositWarnThreshold: types.FIL(big.Zero()),
+			PreCommitBatchMaxSectorWait:        Duration(0),
+			PreCommitBatchMinDepositPer32GiB:   types.FIL(big.Zero()),
+			PreCommitConfirmStrategy:           "search",
+			PreCommitBatchFundsCheckInterval:   Duration(0),
+			PreCommitBatchAggFeeMode:           "threshold",
+			PreCommitBatchMinSavings:           types.FIL(big.Zero()),
+			PreCommitBatchMaxWaitersPerSector:  16,
+			PreCommitBatchFlushDuringSend:      "wait",
+			PreCommitBatchMaxBatchesPerFlush:   1,
+			PreCommitBatchSendRetries:          3,
+			PreCommitBatchSendRetryBackoff:     Duration(time.Second),
+
 			CommittedCapacitySectorLifetime: Duration(builtin.EpochDurationSeconds * uint64(policy.GetMaxSectorExpirationExtension()) * uint64(time.Second)),
 
 			AggregateCommits: true,
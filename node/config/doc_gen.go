@@ -1006,6 +1006,403 @@ This is useful for forcing all deals to be assigned as snap deals to sectors mar
 
 			Comment: `time buffer for forceful batch submission before sectors/deal in batch would start expiring`,
 		},
+		{
+			Name: "PreCommitBatchSendUrgentIndividually",
+			Type: "bool",
+
+			Comment: `when batching precommits, send sectors which are within PreCommitBatchSlack of their cutoff
+as individual messages in the same cycle, batching only the remaining sectors`,
+		},
+		{
+			Name: "PreCommitBatchManualSendMode",
+			Type: "bool",
+
+			Comment: `disable the internal precommit batch timer; batches will only be sent on explicit flush, when
+MaxPreCommitBatch is reached, or when sectors in the batch are about to reach their cutoff`,
+		},
+		{
+			Name: "PreCommitBatchManualSendIgnoreCutoff",
+			Type: "bool",
+
+			Comment: `in PreCommitBatchManualSendMode, don't send batches when sectors are about to reach their cutoff
+WARNING: sectors / deals may expire if the batch isn't flushed in time`,
+		},
+		{
+			Name: "PreCommitBatchDepositWarnThreshold",
+			Type: "types.FIL",
+
+			Comment: `log a warning before sending a precommit batch which locks up more than this amount in deposits
+0 = disabled`,
+		},
+		{
+			Name: "PreCommitBatchMaxChainLag",
+			Type: "uint64",
+
+			Comment: `defer sending precommit batches while the chain head is more than this many epochs behind the
+expected wall-clock height; sectors close to their cutoff are still sent
+0 = disabled`,
+		},
+		{
+			Name: "PreCommitBatchSkipExpirationCheck",
+			Type: "bool",
+
+			Comment: `don't reject precommits with an expiration below the network minimum sector lifetime before
+adding them to a batch; note that such sectors will cause the whole batch message to fail`,
+		},
+		{
+			Name: "PreCommitBatchKeepWaitersOnStop",
+			Type: "bool",
+
+			Comment: `when the precommit batcher is stopped, keep sectors waiting for their batch instead of failing
+them immediately with an error, which lets the sealing state machine retry them`,
+		},
+		{
+			Name: "PreCommitFeeTrendMode",
+			Type: "bool",
+
+			Comment: `track the recent network base fee trend; defer precommit sends while the base fee is falling, and
+send queued precommits early while it is rising. Sends are never deferred past sector cutoffs`,
+		},
+		{
+			Name: "PreCommitBatchGasFeedback",
+			Type: "bool",
+
+			Comment: `track gas used by landed precommit batch messages, and use it to set a tighter gas limit
+on future batches instead of estimating gas for each message`,
+		},
+		{
+			Name: "PreCommitBatchMaxSectorWait",
+			Type: "Duration",
+
+			Comment: `sectors which have been waiting in the precommit batcher for longer than this are put at the front of
+the next batch, after sectors close to their cutoff, so that they can't be starved when batches are full
+0 = disabled`,
+		},
+		{
+			Name: "PreCommitBatchBlockFillCheck",
+			Type: "bool",
+
+			Comment: `defer precommit batch sends while the blocks at the chain head don't have enough free gas for the batch;
+batch gas is estimated from landed batches, so this requires PreCommitBatchGasFeedback. Sectors close to
+their cutoff are still sent`,
+		},
+		{
+			Name: "PreCommitBatchDecisionLogPath",
+			Type: "string",
+
+			Comment: `when set, a record of each precommit send attempt, including the chosen send path, sent and deferred
+sectors, is appended to this file as newline-delimited JSON`,
+		},
+		{
+			Name: "PreCommitBatchSkipRandomnessCheck",
+			Type: "bool",
+
+			Comment: `don't drop sectors whose seal randomness epoch is too old to be accepted on chain before sending
+precommits; note that a single such sector will cause the whole batch message to fail`,
+		},
+		{
+			Name: "PreCommitBatchCheckMpool",
+			Type: "bool",
+
+			Comment: `before sending precommits, look for sectors which already have a precommit message pending in the
+message pool, and wait for that message instead of sending them again`,
+		},
+		{
+			Name: "PreCommitAddressPreferFeeCoverage",
+			Type: "bool",
+
+			Comment: `when selecting the address to send precommit messages from, require the address to cover the message
+value and fees, instead of the full precommit deposit. This lets addresses which can't hold the whole
+deposit send precommits when collateral is taken from the miner balance (CollateralFromMinerBalance),
+but if the miner balance runs out before the message lands, it will fail`,
+		},
+		{
+			Name: "PreCommitBatchMinDepositPer32GiB",
+			Type: "types.FIL",
+
+			Comment: `warn about precommits with a deposit below this amount per 32GiB of sector size, which likely
+means that the deposit was computed incorrectly
+0 = disabled`,
+		},
+		{
+			Name: "PreCommitBatchRejectLowDeposit",
+			Type: "bool",
+
+			Comment: `reject precommits with a deposit below PreCommitBatchMinDepositPer32GiB instead of only warning`,
+		},
+		{
+			Name: "PreCommitBatchConfirmTimeoutEpochs",
+			Type: "uint64",
+
+			Comment: `number of epochs after which a sent precommit message which hasn't landed on chain is considered
+lost; sectors waiting for its confirmation get an error and can be queued again
+0 = disabled`,
+		},
+		{
+			Name: "DiagnoseBatchReverts",
+			Type: "bool",
+
+			Comment: `when set, queued precommits are sent as individual PreCommitSector messages, even
+when batching would be cheaper, so that a sector which makes batches revert can be
+found from its own failed message. Only meant to be enabled while debugging reverts`,
+		},
+		{
+			Name: "PreCommitBatchMaxQueue",
+			Type: "int",
+
+			Comment: `maximum number of precommits waiting in the batcher queue, bounding its memory use
+0 = no limit`,
+		},
+		{
+			Name: "PreCommitBatchEvictOnFullQueue",
+			Type: "bool",
+
+			Comment: `when the queue is at PreCommitBatchMaxQueue, evict the queued sector with the latest
+cutoff to make room for a sector with an earlier cutoff, instead of refusing the new sector.
+The evicted sector fails, and goes through the precommit failure handling`,
+		},
+		{
+			Name: "PreCommitBatchFeeBufferByVersion",
+			Type: "map[string]uint64",
+
+			Comment: `overrides of the buffer provisioned on top of the aggregate precommit network fee, keyed
+by network version, in percent of the fee estimate. For example {"17" = 130} provisions
+30% above the estimate for nv17. Versions without an override use a 10% buffer`,
+		},
+		{
+			Name: "PreCommitBatchGroupByDeadlineHint",
+			Type: "bool",
+
+			Comment: `when set, sectors queued with a proving deadline hint are batched together with sectors
+with the same hint, which simplifies scheduling of later messages for those sectors.
+Sectors close to their cutoff are batched regardless of their hint`,
+		},
+		{
+			Name: "PreCommitConfirmStrategy",
+			Type: "string",
+
+			Comment: `how WaitConfirmed checks that a sent precommit landed on chain:
+"search" returns as soon as the message is found in the chain,
+"wait" also waits for the message to get enough confirmations to be safe from reorgs`,
+		},
+		{
+			Name: "PreCommitBatchFundsCheckInterval",
+			Type: "Duration",
+
+			Comment: `how often to check that the deposits of queued precommits are covered by the available
+miner balance and sending address funds, warning when they aren't so that funds can be
+added before sends start failing
+0 = disabled`,
+		},
+		{
+			Name: "PreCommitBatchSendWindowPeriod",
+			Type: "uint64",
+
+			Comment: `restricts automatic precommit sends to a window of epochs repeating every
+PreCommitBatchSendWindowPeriod epochs, e.g. to send at times when fees are usually lower.
+Sends of sectors close to their cutoff, and explicit flushes, happen outside of the window
+0 = no window`,
+		},
+		{
+			Name: "PreCommitBatchSendWindowStart",
+			Type: "uint64",
+
+			Comment: `first epoch of the send window, as an offset into PreCommitBatchSendWindowPeriod`,
+		},
+		{
+			Name: "PreCommitBatchSendWindowLength",
+			Type: "uint64",
+
+			Comment: `length of the send window in epochs`,
+		},
+		{
+			Name: "PreCommitBatchAggFeeMode",
+			Type: "string",
+
+			Comment: `how the aggregate network fee affects the choice between batched and individual precommits:
+"threshold" sends individually when the base fee is below BatchPreCommitAboveBaseFee,
+"savings" sends individually when the estimated cost of the batch, including the
+aggregate fee, isn't lower than the cost of individual messages`,
+		},
+		{
+			Name: "PreCommitBatchCheckAllocation",
+			Type: "bool",
+
+			Comment: `before sending precommits, check that the sector numbers aren't already allocated on chain, and drop
+sectors which are, instead of letting them fail the whole batch message`,
+		},
+		{
+			Name: "PreCommitBatchUpgradeGuardEpochs",
+			Type: "uint64",
+
+			Comment: `when a network upgrade is this many epochs away or less, defer sends of precommits which aren't
+close to their cutoff until after the upgrade, so that messages don't execute under different
+rules than they were built for. 0 disables the check`,
+		},
+		{
+			Name: "PreCommitBatchEmergencySendAlert",
+			Type: "int",
+
+			Comment: `number of automatic sends made because a sector was within PreCommitBatchSlack of its cutoff,
+within an hour, above which a critical alert is logged. Frequent emergency sends mean that
+PreCommitBatchWait or PreCommitBatchSlack don't leave sectors enough time. 0 disables the alert`,
+		},
+		{
+			Name: "PreCommitBatchAutoTuneWait",
+			Type: "bool",
+
+			Comment: `when the emergency send alert fires, halve the PreCommitBatchWait used by the batcher, at most
+once an hour, to give sectors more time before their cutoff. The adjustment is lost on restart`,
+		},
+		{
+			Name: "PreCommitBatchVerifySenderBalance",
+			Type: "bool",
+
+			Comment: `before sending a precommit batch, check that the selected address holds the message value and max fee,
+failing with an error which names the address and the shortfall instead of waiting for the message
+pool to reject the message`,
+		},
+		{
+			Name: "EconomicBatchDecision",
+			Type: "bool",
+
+			Comment: `send precommits individually unless batching them is estimated to save more than PreCommitBatchMinSavings,
+comparing the batch gas and aggregate fee with the gas of individual messages at the current base fee.
+Overrides PreCommitBatchAggFeeMode`,
+		},
+		{
+			Name: "PreCommitBatchMinSavings",
+			Type: "types.FIL",
+
+			Comment: `least estimated savings of a batch over individual messages for precommits to be batched, with
+EconomicBatchDecision or PreCommitBatchAggFeeMode set to "savings"`,
+		},
+		{
+			Name: "AdaptiveBatchWait",
+			Type: "bool",
+
+			Comment: `estimate the precommit arrival rate over the last hour, and wait only as long as it takes to fill
+a batch at that rate, up to PreCommitBatchWait. When less than one sector is expected to arrive within
+PreCommitBatchWait, waiting doesn't make batches fuller, and precommits are sent after a minute`,
+		},
+		{
+			Name: "PreCommitBatchMaxWaitersPerSector",
+			Type: "int",
+
+			Comment: `maximum number of callers waiting on the precommit of a single sector, adding a precommit for a
+sector which already has this many waiters fails. Guards against callers adding the same sector in a loop
+0 = no limit`,
+		},
+		{
+			Name: "PreCommitBatchSendUrgentDealAlone",
+			Type: "bool",
+
+			Comment: `when exactly one queued sector with deals is close to its cutoff, and all other queued sectors are
+committed capacity, send the deal sector in its own message and keep the other sectors queued for a
+fuller batch, instead of sending the whole queue early`,
+		},
+		{
+			Name: "PreCommitCollateralSources",
+			Type: "[]string",
+
+			Comment: `ordered list of sources precommit deposits are paid from, "miner" for the miner available balance,
+or an address. Each message is sent from the first listed address which, together with the miner balance
+when it's listed, covers the deposit; the miner balance is used first when it's listed before the address.
+When no address is listed, the address is picked like for other precommits. Overrides
+CollateralFromMinerBalance and DisableCollateralFallback for precommits. Empty = not used`,
+		},
+		{
+			Name: "PreCommitBatchStartupGrace",
+			Type: "Duration",
+
+			Comment: `time after the miner starts during which precommits are only sent in full batches, or when a sector is
+close to its cutoff, so that sectors restored from the queue and ones which finish sealing shortly after a
+restart go out together. By default an explicit flush sends right away, see PreCommitBatchFlushRespectsGrace
+0 = disabled`,
+		},
+		{
+			Name: "PreCommitBatchFlushRespectsGrace",
+			Type: "bool",
+
+			Comment: `make explicit flushes respect PreCommitBatchStartupGrace, queued sectors then stay queued when flushed
+during the grace period, unless one is close to its cutoff`,
+		},
+		{
+			Name: "PreCommitBatchFlushDuringSend",
+			Type: "string",
+
+			Comment: `what a flush does when it arrives while the batcher is in a send attempt which hasn't sent yet:
+"wait" waits for the attempt to finish, then sends the sectors it left queued; the flush only returns
+results of its own send, so sectors sent by the attempt aren't reported to the flush caller.
+"merge" joins the attempt, which then sends like a flush would, skipping deferrals it didn't pass yet;
+the flush returns results of the attempt, and of a send of any sectors it left queued`,
+		},
+		{
+			Name: "PreCommitBatchMaxBatchesPerFlush",
+			Type: "int",
+
+			Comment: `maximum number of batch messages sent by a single flush when more sectors are queued than fit in one batch,
+sectors which don't fit are left queued for later sends. The most urgent sectors go in the first batches
+0 = 1`,
+		},
+		{
+			Name: "PreCommitBatchMaxGasFraction",
+			Type: "float64",
+
+			Comment: `maximum fraction of the block gas limit a precommit batch message may use, as estimated before sending.
+larger batches are split, the sectors which don't fit are sent in a later batch
+0 = no limit`,
+		},
+		{
+			Name: "MinPreCommitBatch",
+			Type: "int",
+
+			Comment: `minimum number of sectors in an automatically sent precommit batch, smaller batches wait for more sectors.
+sectors within PreCommitBatchSlack of their cutoff are still sent right away, explicit flushes ignore the minimum
+0 = no minimum`,
+		},
+		{
+			Name: "PreCommitBatchBaseFeeCaps",
+			Type: "[]string",
+
+			Comment: `caps on the number of sectors in a precommit batch by base fee, as "<base fee>:<sectors>" entries, e.g.
+["500000000 attoFIL:128", "2000000000 attoFIL:32"]. The cap of the highest listed base fee at or below the current one
+applies, clamped between MinPreCommitBatch and MaxPreCommitBatch, so that the aggregate network fee doesn't make
+large batches costly when the base fee is high. Empty = MaxPreCommitBatch at any base fee`,
+		},
+		{
+			Name: "PreCommitBatchSendRetries",
+			Type: "int",
+
+			Comment: `times a precommit message which failed to send with a transient error, such as a full mpool or a lost connection
+to the node, is sent again before giving up. Its sectors then stay queued for the next send attempt. Other
+errors fail the sectors right away`,
+		},
+		{
+			Name: "PreCommitBatchSendRetryBackoff",
+			Type: "Duration",
+
+			Comment: `time to wait before the first retry of a failed precommit send, doubling with each retry. When a sector of
+the message leaves the queue while waiting, the message isn't sent again and its sectors stay queued`,
+		},
+		{
+			Name: "PreCommitBatchBlockOnFullQueue",
+			Type: "bool",
+
+			Comment: `when the queue is at PreCommitBatchMaxQueue, make adding a sector wait until a queued one is sent, instead
+of refusing or evicting. Sealing then slows down to the rate precommits are sent at. A waiting sector is
+queued anyway once it gets within PreCommitBatchSlack of its cutoff.
+This is the MaxPreCommitQueue backpressure: the limit is PreCommitBatchMaxQueue, 0 meaning unbounded, and
+this option makes AddPreCommit wait for room at that limit`,
+		},
+		{
+			Name: "PreCommitRoundRobinAddresses",
+			Type: "bool",
+
+			Comment: `when a send attempt sends several precommit batches, send each one after the first from a different control
+address with enough balance, going round-robin over them, so that the messages don't queue up behind the
+nonce of a single address. The first batch uses the address selected as usual`,
+		},
 		{
 			Name: "AggregateCommits",
 			Type: "bool",
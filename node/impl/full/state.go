@@ -1623,6 +1623,7 @@ func (a *StateAPI) StateGetNetworkParams(ctx context.Context) (*api.NetworkParam
 			UpgradeHyperdriveHeight:  build.UpgradeHyperdriveHeight,
 			UpgradeChocolateHeight:   build.UpgradeChocolateHeight,
 			UpgradeOhSnapHeight:      build.UpgradeOhSnapHeight,
+			UpgradeSkyrHeight:        build.UpgradeSkyrHeight,
 		},
 	}, nil
 }
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -42,6 +43,7 @@ import (
 	"github.com/filecoin-project/go-paramfetch"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/network"
 	"github.com/filecoin-project/go-statestore"
 	provider "github.com/filecoin-project/index-provider"
 
@@ -286,7 +288,10 @@ func SealingPipeline(fc config.MinerFeeConfig) func(params SealingPipelineParams
 		provingBuffer := md.WPoStProvingPeriod * 2
 		pcp := sealing.NewBasicPreCommitPolicy(api, gsd, provingBuffer)
 
-		pipeline := sealing.New(ctx, api, fc, evts, maddr, ds, sealer, verif, prover, &pcp, gsd, j, as)
+		pipeline, err := sealing.New(ctx, api, fc, evts, maddr, ds, sealer, verif, prover, &pcp, gsd, j, as)
+		if err != nil {
+			return nil, xerrors.Errorf("creating sealing pipeline: %w", err)
+		}
 
 		lc.Append(fx.Hook{
 			OnStart: func(context.Context) error {
@@ -999,10 +1004,61 @@ func NewSetSealConfigFunc(r repo.LockedRepo) (dtypes.SetSealingConfigFunc, error
 				AvailableBalanceBuffer:     types.FIL(cfg.AvailableBalanceBuffer),
 				DisableCollateralFallback:  cfg.DisableCollateralFallback,
 
-				BatchPreCommits:     cfg.BatchPreCommits,
-				MaxPreCommitBatch:   cfg.MaxPreCommitBatch,
-				PreCommitBatchWait:  config.Duration(cfg.PreCommitBatchWait),
-				PreCommitBatchSlack: config.Duration(cfg.PreCommitBatchSlack),
+				BatchPreCommits:                      cfg.BatchPreCommits,
+				MaxPreCommitBatch:                    cfg.MaxPreCommitBatch,
+				PreCommitBatchWait:                   config.Duration(cfg.PreCommitBatchWait),
+				PreCommitBatchSlack:                  config.Duration(cfg.PreCommitBatchSlack),
+				PreCommitBatchSendUrgentIndividually: cfg.PreCommitBatchSendUrgentIndividually,
+				PreCommitBatchManualSendMode:         cfg.PreCommitBatchManualSendMode,
+				PreCommitBatchManualSendIgnoreCutoff: cfg.PreCommitBatchManualSendIgnoreCutoff,
+				PreCommitBatchDepositWarnThreshold:   types.FIL(cfg.PreCommitBatchDepositWarnThreshold),
+				PreCommitBatchMaxChainLag:            cfg.PreCommitBatchMaxChainLag,
+				PreCommitBatchSkipExpirationCheck:    cfg.PreCommitBatchSkipExpirationCheck,
+				PreCommitBatchKeepWaitersOnStop:      cfg.PreCommitBatchKeepWaitersOnStop,
+				PreCommitFeeTrendMode:                cfg.PreCommitFeeTrendMode,
+				PreCommitBatchGasFeedback:            cfg.PreCommitBatchGasFeedback,
+				PreCommitBatchMaxSectorWait:          config.Duration(cfg.PreCommitBatchMaxSectorWait),
+				PreCommitBatchBlockFillCheck:         cfg.PreCommitBatchBlockFillCheck,
+				PreCommitBatchDecisionLogPath:        cfg.PreCommitBatchDecisionLogPath,
+				PreCommitBatchSkipRandomnessCheck:    cfg.PreCommitBatchSkipRandomnessCheck,
+				PreCommitBatchCheckMpool:             cfg.PreCommitBatchCheckMpool,
+				PreCommitAddressPreferFeeCoverage:    cfg.PreCommitAddressPreferFeeCoverage,
+				PreCommitBatchMinDepositPer32GiB:     types.FIL(cfg.PreCommitBatchMinDepositPer32GiB),
+				PreCommitBatchRejectLowDeposit:       cfg.PreCommitBatchRejectLowDeposit,
+				PreCommitBatchConfirmTimeoutEpochs:   cfg.PreCommitBatchConfirmTimeoutEpochs,
+				DiagnoseBatchReverts:                 cfg.DiagnoseBatchReverts,
+				PreCommitBatchMaxQueue:               cfg.PreCommitBatchMaxQueue,
+				PreCommitBatchEvictOnFullQueue:       cfg.PreCommitBatchEvictOnFullQueue,
+				PreCommitBatchFeeBufferByVersion:     feeBuffersToConfig(cfg.PreCommitBatchFeeBufferByVersion),
+				PreCommitBatchGroupByDeadlineHint:    cfg.PreCommitBatchGroupByDeadlineHint,
+				PreCommitConfirmStrategy:             cfg.PreCommitConfirmStrategy,
+				PreCommitBatchFundsCheckInterval:     config.Duration(cfg.PreCommitBatchFundsCheckInterval),
+				PreCommitBatchSendWindowPeriod:       cfg.PreCommitBatchSendWindowPeriod,
+				PreCommitBatchSendWindowStart:        cfg.PreCommitBatchSendWindowStart,
+				PreCommitBatchSendWindowLength:       cfg.PreCommitBatchSendWindowLength,
+				PreCommitBatchAggFeeMode:             cfg.PreCommitBatchAggFeeMode,
+				PreCommitBatchCheckAllocation:        cfg.PreCommitBatchCheckAllocation,
+				PreCommitBatchUpgradeGuardEpochs:     cfg.PreCommitBatchUpgradeGuardEpochs,
+				PreCommitBatchEmergencySendAlert:     cfg.PreCommitBatchEmergencySendAlert,
+				PreCommitBatchAutoTuneWait:           cfg.PreCommitBatchAutoTuneWait,
+				PreCommitBatchVerifySenderBalance:    cfg.PreCommitBatchVerifySenderBalance,
+				EconomicBatchDecision:                cfg.EconomicBatchDecision,
+				PreCommitBatchMinSavings:             types.FIL(cfg.PreCommitBatchMinSavings),
+				AdaptiveBatchWait:                    cfg.AdaptiveBatchWait,
+				PreCommitBatchMaxWaitersPerSector:    cfg.PreCommitBatchMaxWaitersPerSector,
+				PreCommitBatchSendUrgentDealAlone:    cfg.PreCommitBatchSendUrgentDealAlone,
+				PreCommitCollateralSources:           cfg.PreCommitCollateralSources,
+				PreCommitBatchStartupGrace:           config.Duration(cfg.PreCommitBatchStartupGrace),
+				PreCommitBatchFlushRespectsGrace:     cfg.PreCommitBatchFlushRespectsGrace,
+				PreCommitBatchFlushDuringSend:        cfg.PreCommitBatchFlushDuringSend,
+				PreCommitBatchMaxBatchesPerFlush:     cfg.PreCommitBatchMaxBatchesPerFlush,
+				PreCommitBatchMaxGasFraction:         cfg.PreCommitBatchMaxGasFraction,
+				MinPreCommitBatch:                    cfg.MinPreCommitBatch,
+				PreCommitBatchBaseFeeCaps:            cfg.PreCommitBatchBaseFeeCaps,
+				PreCommitBatchSendRetries:            cfg.PreCommitBatchSendRetries,
+				PreCommitBatchSendRetryBackoff:       config.Duration(cfg.PreCommitBatchSendRetryBackoff),
+				PreCommitBatchBlockOnFullQueue:       cfg.PreCommitBatchBlockOnFullQueue,
+				PreCommitRoundRobinAddresses:         cfg.PreCommitRoundRobinAddresses,
 
 				AggregateCommits:           cfg.AggregateCommits,
 				MinCommitBatch:             cfg.MinCommitBatch,
@@ -1041,10 +1097,61 @@ func ToSealingConfig(dealmakingCfg config.DealmakingConfig, sealingCfg config.Se
 		AvailableBalanceBuffer:     types.BigInt(sealingCfg.AvailableBalanceBuffer),
 		DisableCollateralFallback:  sealingCfg.DisableCollateralFallback,
 
-		BatchPreCommits:     sealingCfg.BatchPreCommits,
-		MaxPreCommitBatch:   sealingCfg.MaxPreCommitBatch,
-		PreCommitBatchWait:  time.Duration(sealingCfg.PreCommitBatchWait),
-		PreCommitBatchSlack: time.Duration(sealingCfg.PreCommitBatchSlack),
+		BatchPreCommits:                      sealingCfg.BatchPreCommits,
+		MaxPreCommitBatch:                    sealingCfg.MaxPreCommitBatch,
+		PreCommitBatchWait:                   time.Duration(sealingCfg.PreCommitBatchWait),
+		PreCommitBatchSlack:                  time.Duration(sealingCfg.PreCommitBatchSlack),
+		PreCommitBatchSendUrgentIndividually: sealingCfg.PreCommitBatchSendUrgentIndividually,
+		PreCommitBatchManualSendMode:         sealingCfg.PreCommitBatchManualSendMode,
+		PreCommitBatchManualSendIgnoreCutoff: sealingCfg.PreCommitBatchManualSendIgnoreCutoff,
+		PreCommitBatchDepositWarnThreshold:   types.BigInt(sealingCfg.PreCommitBatchDepositWarnThreshold),
+		PreCommitBatchMaxChainLag:            sealingCfg.PreCommitBatchMaxChainLag,
+		PreCommitBatchSkipExpirationCheck:    sealingCfg.PreCommitBatchSkipExpirationCheck,
+		PreCommitBatchKeepWaitersOnStop:      sealingCfg.PreCommitBatchKeepWaitersOnStop,
+		PreCommitFeeTrendMode:                sealingCfg.PreCommitFeeTrendMode,
+		PreCommitBatchGasFeedback:            sealingCfg.PreCommitBatchGasFeedback,
+		PreCommitBatchMaxSectorWait:          time.Duration(sealingCfg.PreCommitBatchMaxSectorWait),
+		PreCommitBatchBlockFillCheck:         sealingCfg.PreCommitBatchBlockFillCheck,
+		PreCommitBatchDecisionLogPath:        sealingCfg.PreCommitBatchDecisionLogPath,
+		PreCommitBatchSkipRandomnessCheck:    sealingCfg.PreCommitBatchSkipRandomnessCheck,
+		PreCommitBatchCheckMpool:             sealingCfg.PreCommitBatchCheckMpool,
+		PreCommitAddressPreferFeeCoverage:    sealingCfg.PreCommitAddressPreferFeeCoverage,
+		PreCommitBatchMinDepositPer32GiB:     types.BigInt(sealingCfg.PreCommitBatchMinDepositPer32GiB),
+		PreCommitBatchRejectLowDeposit:       sealingCfg.PreCommitBatchRejectLowDeposit,
+		PreCommitBatchConfirmTimeoutEpochs:   sealingCfg.PreCommitBatchConfirmTimeoutEpochs,
+		DiagnoseBatchReverts:                 sealingCfg.DiagnoseBatchReverts,
+		PreCommitBatchMaxQueue:               sealingCfg.PreCommitBatchMaxQueue,
+		PreCommitBatchEvictOnFullQueue:       sealingCfg.PreCommitBatchEvictOnFullQueue,
+		PreCommitBatchFeeBufferByVersion:     feeBuffersFromConfig(sealingCfg.PreCommitBatchFeeBufferByVersion),
+		PreCommitBatchGroupByDeadlineHint:    sealingCfg.PreCommitBatchGroupByDeadlineHint,
+		PreCommitConfirmStrategy:             sealingCfg.PreCommitConfirmStrategy,
+		PreCommitBatchFundsCheckInterval:     time.Duration(sealingCfg.PreCommitBatchFundsCheckInterval),
+		PreCommitBatchSendWindowPeriod:       sealingCfg.PreCommitBatchSendWindowPeriod,
+		PreCommitBatchSendWindowStart:        sealingCfg.PreCommitBatchSendWindowStart,
+		PreCommitBatchSendWindowLength:       sealingCfg.PreCommitBatchSendWindowLength,
+		PreCommitBatchAggFeeMode:             sealingCfg.PreCommitBatchAggFeeMode,
+		PreCommitBatchCheckAllocation:        sealingCfg.PreCommitBatchCheckAllocation,
+		PreCommitBatchUpgradeGuardEpochs:     sealingCfg.PreCommitBatchUpgradeGuardEpochs,
+		PreCommitBatchEmergencySendAlert:     sealingCfg.PreCommitBatchEmergencySendAlert,
+		PreCommitBatchAutoTuneWait:           sealingCfg.PreCommitBatchAutoTuneWait,
+		PreCommitBatchVerifySenderBalance:    sealingCfg.PreCommitBatchVerifySenderBalance,
+		EconomicBatchDecision:                sealingCfg.EconomicBatchDecision,
+		PreCommitBatchMinSavings:             types.BigInt(sealingCfg.PreCommitBatchMinSavings),
+		AdaptiveBatchWait:                    sealingCfg.AdaptiveBatchWait,
+		PreCommitBatchMaxWaitersPerSector:    sealingCfg.PreCommitBatchMaxWaitersPerSector,
+		PreCommitBatchSendUrgentDealAlone:    sealingCfg.PreCommitBatchSendUrgentDealAlone,
+		PreCommitCollateralSources:           sealingCfg.PreCommitCollateralSources,
+		PreCommitBatchStartupGrace:           time.Duration(sealingCfg.PreCommitBatchStartupGrace),
+		PreCommitBatchFlushRespectsGrace:     sealingCfg.PreCommitBatchFlushRespectsGrace,
+		PreCommitBatchFlushDuringSend:        sealingCfg.PreCommitBatchFlushDuringSend,
+		PreCommitBatchMaxBatchesPerFlush:     sealingCfg.PreCommitBatchMaxBatchesPerFlush,
+		PreCommitBatchMaxGasFraction:         sealingCfg.PreCommitBatchMaxGasFraction,
+		MinPreCommitBatch:                    sealingCfg.MinPreCommitBatch,
+		PreCommitBatchBaseFeeCaps:            sealingCfg.PreCommitBatchBaseFeeCaps,
+		PreCommitBatchSendRetries:            sealingCfg.PreCommitBatchSendRetries,
+		PreCommitBatchSendRetryBackoff:       time.Duration(sealingCfg.PreCommitBatchSendRetryBackoff),
+		PreCommitBatchBlockOnFullQueue:       sealingCfg.PreCommitBatchBlockOnFullQueue,
+		PreCommitRoundRobinAddresses:         sealingCfg.PreCommitRoundRobinAddresses,
 
 		AggregateCommits:           sealingCfg.AggregateCommits,
 		MinCommitBatch:             sealingCfg.MinCommitBatch,
@@ -1060,6 +1167,37 @@ func ToSealingConfig(dealmakingCfg config.DealmakingConfig, sealingCfg config.Se
 	}
 }
 
+// feeBuffersFromConfig converts fee buffer overrides keyed by network version numbers
+// in config, skipping keys which aren't numbers
+func feeBuffersFromConfig(in map[string]uint64) map[network.Version]uint64 {
+	if len(in) == 0 {
+		return nil
+	}
+
+	out := make(map[network.Version]uint64, len(in))
+	for k, v := range in {
+		nv, err := strconv.ParseUint(k, 10, 32)
+		if err != nil {
+			log.Errorw("ignoring fee buffer override with an invalid network version", "version", k, "error", err)
+			continue
+		}
+		out[network.Version(nv)] = v
+	}
+	return out
+}
+
+func feeBuffersToConfig(in map[network.Version]uint64) map[string]uint64 {
+	if len(in) == 0 {
+		return nil
+	}
+
+	out := make(map[string]uint64, len(in))
+	for nv, v := range in {
+		out[strconv.FormatUint(uint64(nv), 10)] = v
+	}
+	return out
+}
+
 func NewGetSealConfigFunc(r repo.LockedRepo) (dtypes.GetSealingConfigFunc, error) {
 	return func() (out sealiface.Config, err error) {
 		err = readSealingCfg(r, func(dc config.DealmakingConfiger, sc config.SealingConfiger) {
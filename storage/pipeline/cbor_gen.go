@@ -1757,3 +1757,391 @@ func (t *Log) UnmarshalCBOR(r io.Reader) (err error) {
 
 	return nil
 }
+
+func (t *QueuedPreCommit) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{165}); err != nil {
+		return err
+	}
+
+	// t.SectorInfo (sealing.SectorInfo) (struct)
+	if len("SectorInfo") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"SectorInfo\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("SectorInfo"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("SectorInfo")); err != nil {
+		return err
+	}
+
+	if err := t.SectorInfo.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.Deposit (big.Int) (struct)
+	if len("Deposit") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Deposit\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Deposit"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Deposit")); err != nil {
+		return err
+	}
+
+	if err := t.Deposit.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.Info (miner.SectorPreCommitInfo) (struct)
+	if len("Info") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Info\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Info"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Info")); err != nil {
+		return err
+	}
+
+	if err := t.Info.MarshalCBOR(cw); err != nil {
+		return err
+	}
+
+	// t.DeadlineHint (uint64) (uint64)
+	if len("DeadlineHint") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"DeadlineHint\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("DeadlineHint"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("DeadlineHint")); err != nil {
+		return err
+	}
+
+	if t.DeadlineHint == nil {
+		if _, err := cw.Write(cbg.CborNull); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.WriteMajorTypeHeader(cbg.MajUnsignedInt, uint64(*t.DeadlineHint)); err != nil {
+			return err
+		}
+	}
+
+	// t.Queued (typegen.CborTime) (struct)
+	if len("Queued") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Queued\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Queued"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Queued")); err != nil {
+		return err
+	}
+
+	if err := t.Queued.MarshalCBOR(cw); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *QueuedPreCommit) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = QueuedPreCommit{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("QueuedPreCommit: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.SectorInfo (sealing.SectorInfo) (struct)
+		case "SectorInfo":
+
+			{
+
+				if err := t.SectorInfo.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.SectorInfo: %w", err)
+				}
+
+			}
+			// t.Deposit (big.Int) (struct)
+		case "Deposit":
+
+			{
+
+				if err := t.Deposit.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Deposit: %w", err)
+				}
+
+			}
+			// t.Info (miner.SectorPreCommitInfo) (struct)
+		case "Info":
+
+			{
+
+				if err := t.Info.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Info: %w", err)
+				}
+
+			}
+			// t.DeadlineHint (uint64) (uint64)
+		case "DeadlineHint":
+
+			{
+
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+					maj, extra, err = cr.ReadHeader()
+					if err != nil {
+						return err
+					}
+					if maj != cbg.MajUnsignedInt {
+						return fmt.Errorf("wrong type for uint64 field")
+					}
+					typed := uint64(extra)
+					t.DeadlineHint = &typed
+				}
+
+			}
+			// t.Queued (typegen.CborTime) (struct)
+		case "Queued":
+
+			{
+
+				if err := t.Queued.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Queued: %w", err)
+				}
+
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
+func (t *InFlightPreCommit) MarshalCBOR(w io.Writer) error {
+	if t == nil {
+		_, err := w.Write(cbg.CborNull)
+		return err
+	}
+
+	cw := cbg.NewCborWriter(w)
+
+	if _, err := cw.Write([]byte{163}); err != nil {
+		return err
+	}
+
+	// t.Msg (cid.Cid) (struct)
+	if len("Msg") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Msg\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Msg"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Msg")); err != nil {
+		return err
+	}
+
+	if err := cbg.WriteCid(cw, t.Msg); err != nil {
+		return xerrors.Errorf("failed to write cid field t.Msg: %w", err)
+	}
+
+	// t.Sectors ([]abi.SectorNumber) (slice)
+	if len("Sectors") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Sectors\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Sectors"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Sectors")); err != nil {
+		return err
+	}
+
+	if len(t.Sectors) > cbg.MaxLength {
+		return xerrors.Errorf("Slice value in field t.Sectors was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajArray, uint64(len(t.Sectors))); err != nil {
+		return err
+	}
+	for _, v := range t.Sectors {
+		if err := cw.CborWriteHeader(cbg.MajUnsignedInt, uint64(v)); err != nil {
+			return err
+		}
+	}
+
+	// t.Sent (typegen.CborTime) (struct)
+	if len("Sent") > cbg.MaxLength {
+		return xerrors.Errorf("Value in field \"Sent\" was too long")
+	}
+
+	if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("Sent"))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string("Sent")); err != nil {
+		return err
+	}
+
+	if err := t.Sent.MarshalCBOR(cw); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *InFlightPreCommit) UnmarshalCBOR(r io.Reader) (err error) {
+	*t = InFlightPreCommit{}
+
+	cr := cbg.NewCborReader(r)
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if maj != cbg.MajMap {
+		return fmt.Errorf("cbor input should be of type map")
+	}
+
+	if extra > cbg.MaxLength {
+		return fmt.Errorf("InFlightPreCommit: map struct too large (%d)", extra)
+	}
+
+	var name string
+	n := extra
+
+	for i := uint64(0); i < n; i++ {
+
+		{
+			sval, err := cbg.ReadString(cr)
+			if err != nil {
+				return err
+			}
+
+			name = string(sval)
+		}
+
+		switch name {
+		// t.Msg (cid.Cid) (struct)
+		case "Msg":
+
+			{
+
+				c, err := cbg.ReadCid(cr)
+				if err != nil {
+					return xerrors.Errorf("failed to read cid field t.Msg: %w", err)
+				}
+
+				t.Msg = c
+
+			}
+			// t.Sectors ([]abi.SectorNumber) (slice)
+		case "Sectors":
+
+			maj, extra, err = cr.ReadHeader()
+			if err != nil {
+				return err
+			}
+
+			if extra > cbg.MaxLength {
+				return fmt.Errorf("t.Sectors: array too large (%d)", extra)
+			}
+
+			if maj != cbg.MajArray {
+				return fmt.Errorf("expected cbor array")
+			}
+
+			if extra > 0 {
+				t.Sectors = make([]abi.SectorNumber, extra)
+			}
+
+			for i := 0; i < int(extra); i++ {
+
+				maj, val, err := cr.ReadHeader()
+				if err != nil {
+					return xerrors.Errorf("failed to read uint64 for t.Sectors slice: %w", err)
+				}
+
+				if maj != cbg.MajUnsignedInt {
+					return xerrors.Errorf("value read for array t.Sectors was not a uint, instead got %d", maj)
+				}
+
+				t.Sectors[i] = abi.SectorNumber(val)
+			}
+
+			// t.Sent (typegen.CborTime) (struct)
+		case "Sent":
+
+			{
+
+				if err := t.Sent.UnmarshalCBOR(cr); err != nil {
+					return xerrors.Errorf("unmarshaling t.Sent: %w", err)
+				}
+
+			}
+
+		default:
+			// Field doesn't exist on this type, so ignore it
+			cbg.ScanForLinks(r, func(cid.Cid) {})
+		}
+	}
+
+	return nil
+}
@@ -71,6 +71,9 @@ type CommitBatcher struct {
 	todo    map[abi.SectorNumber]AggregateInput
 	waiting map[abi.SectorNumber][]chan sealiface.CommitBatchRes
 
+	// shared with other batchers sending from the same addresses, may be nil
+	funds FundsCoordinator
+
 	notify, stop, stopped chan struct{}
 	force                 chan chan []sealiface.CommitBatchRes
 	lk                    sync.Mutex
@@ -363,7 +366,7 @@ func (b *CommitBatcher) processBatch(cfg sealiface.Config) ([]sealiface.CommitBa
 	aggFee := big.Div(big.Mul(aggFeeRaw, aggFeeNum), aggFeeDen)
 
 	needFunds := big.Add(collateral, aggFee)
-	needFunds, err = collateralSendAmount(b.mctx, b.api, b.maddr, cfg, needFunds)
+	needFunds, err = collateralSendAmount(b.mctx, b.api, b.maddr, types.EmptyTSK, cfg, needFunds)
 	if err != nil {
 		return []sealiface.CommitBatchRes{res}, err
 	}
@@ -375,7 +378,13 @@ func (b *CommitBatcher) processBatch(cfg sealiface.Config) ([]sealiface.CommitBa
 		return []sealiface.CommitBatchRes{res}, xerrors.Errorf("no good address found: %w", err)
 	}
 
+	release, err := reserveFunds(b.mctx, b.funds, from, goodFunds)
+	if err != nil {
+		return []sealiface.CommitBatchRes{res}, xerrors.Errorf("reserving funds: %w", err)
+	}
+
 	mcid, err := sendMsg(b.mctx, b.api, from, b.maddr, builtin.MethodsMiner.ProveCommitAggregate, needFunds, maxFee, enc.Bytes())
+	release()
 	if err != nil {
 		return []sealiface.CommitBatchRes{res}, xerrors.Errorf("sending message failed: %w", err)
 	}
@@ -470,6 +479,12 @@ func (b *CommitBatcher) processSingle(cfg sealiface.Config, mi api.MinerInfo, av
 		return cid.Undef, xerrors.Errorf("no good address to send commit message from: %w", err)
 	}
 
+	release, err := reserveFunds(b.mctx, b.funds, from, goodFunds)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("reserving funds: %w", err)
+	}
+	defer release()
+
 	mcid, err := sendMsg(b.mctx, b.api, from, b.maddr, builtin.MethodsMiner.ProveCommitSector, collateral, big.Int(b.feeCfg.MaxCommitGasFee), enc.Bytes())
 	if err != nil {
 		return cid.Undef, xerrors.Errorf("pushing message to mpool: %w", err)
@@ -523,6 +538,15 @@ func (b *CommitBatcher) Flush(ctx context.Context) ([]sealiface.CommitBatchRes,
 	}
 }
 
+// SetFundsCoordinator makes the batcher reserve funds with the given coordinator
+// before sending messages
+func (b *CommitBatcher) SetFundsCoordinator(fc FundsCoordinator) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.funds = fc
+}
+
 func (b *CommitBatcher) Pending(ctx context.Context) ([]abi.SectorID, error) {
 	b.lk.Lock()
 	defer b.lk.Unlock()
@@ -0,0 +1,105 @@
+package sealing
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ErrFundsReserved is returned by FundsCoordinator.Reserve when the part of the
+// address balance which isn't already reserved doesn't cover the requested amount
+var ErrFundsReserved = xerrors.New("not enough unreserved funds")
+
+// FundsCoordinator keeps batchers which send from the same addresses from counting
+// on the same balance. Funds are reserved after picking the address to send from,
+// and released once the message is in the mpool, which accounts for pending
+// messages itself, or when sending it failed.
+type FundsCoordinator interface {
+	Reserve(ctx context.Context, addr address.Address, amt abi.TokenAmount) error
+	Release(addr address.Address, amt abi.TokenAmount)
+}
+
+type walletBalanceAPI interface {
+	WalletBalance(context.Context, address.Address) (types.BigInt, error)
+}
+
+type fundsCoordinator struct {
+	api walletBalanceAPI
+
+	lk       sync.Mutex
+	reserved map[address.Address]abi.TokenAmount
+}
+
+func NewFundsCoordinator(api walletBalanceAPI) FundsCoordinator {
+	return &fundsCoordinator{
+		api:      api,
+		reserved: map[address.Address]abi.TokenAmount{},
+	}
+}
+
+func (c *fundsCoordinator) Reserve(ctx context.Context, addr address.Address, amt abi.TokenAmount) error {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	reserved, ok := c.reserved[addr]
+	if !ok {
+		// with no competing reservations the mpool is left to check the balance, as
+		// amounts include max fees which are usually well above the actual fee
+		c.reserved[addr] = amt
+		return nil
+	}
+
+	bal, err := c.api.WalletBalance(ctx, addr)
+	if err != nil {
+		return xerrors.Errorf("getting balance of %s: %w", addr, err)
+	}
+
+	if avail := big.Sub(bal, reserved); avail.LessThan(amt) {
+		return xerrors.Errorf("%s: balance %s, reserved %s, need %s: %w", addr, types.FIL(bal), types.FIL(reserved), types.FIL(amt), ErrFundsReserved)
+	}
+
+	c.reserved[addr] = big.Add(reserved, amt)
+	return nil
+}
+
+func (c *fundsCoordinator) Release(addr address.Address, amt abi.TokenAmount) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	reserved, ok := c.reserved[addr]
+	if !ok {
+		log.Warnw("releasing funds which weren't reserved", "addr", addr, "amount", types.FIL(amt))
+		return
+	}
+
+	reserved = big.Sub(reserved, amt)
+	if reserved.LessThanEqual(big.Zero()) {
+		delete(c.reserved, addr)
+		return
+	}
+
+	c.reserved[addr] = reserved
+}
+
+// reserveFunds reserves funds with the coordinator, when one is set, returning
+// a func which releases them
+func reserveFunds(ctx context.Context, fc FundsCoordinator, addr address.Address, amt abi.TokenAmount) (func(), error) {
+	if fc == nil {
+		return func() {}, nil
+	}
+
+	if err := fc.Reserve(ctx, addr, amt); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		fc.Release(addr, amt)
+	}, nil
+}
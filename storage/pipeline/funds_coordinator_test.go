@@ -0,0 +1,65 @@
+package sealing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type fakeBalances map[address.Address]abi.TokenAmount
+
+func (f fakeBalances) WalletBalance(ctx context.Context, addr address.Address) (types.BigInt, error) {
+	return f[addr], nil
+}
+
+func TestFundsCoordinator(t *testing.T) {
+	ctx := context.Background()
+
+	shared, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+	other, err := address.NewIDAddress(101)
+	require.NoError(t, err)
+
+	fc := NewFundsCoordinator(fakeBalances{
+		shared: big.NewInt(100),
+		other:  big.NewInt(100),
+	})
+
+	// the precommit batcher reserves most of the shared balance
+	releasePrecommit, err := reserveFunds(ctx, fc, shared, big.NewInt(70))
+	require.NoError(t, err)
+
+	// the commit batcher can't count on the same funds
+	_, err = reserveFunds(ctx, fc, shared, big.NewInt(70))
+	require.ErrorIs(t, err, ErrFundsReserved)
+
+	// what's left, and other addresses, are still available
+	releaseCommit, err := reserveFunds(ctx, fc, shared, big.NewInt(30))
+	require.NoError(t, err)
+	// a single reservation is left for the mpool to check
+	releaseOther, err := reserveFunds(ctx, fc, other, big.NewInt(170))
+	require.NoError(t, err)
+
+	releasePrecommit()
+
+	releaseCommit2, err := reserveFunds(ctx, fc, shared, big.NewInt(70))
+	require.NoError(t, err)
+
+	releaseCommit()
+	releaseCommit2()
+	releaseOther()
+
+	require.Empty(t, fc.(*fundsCoordinator).reserved)
+
+	// without a coordinator nothing is reserved
+	release, err := reserveFunds(ctx, nil, shared, big.NewInt(1000))
+	require.NoError(t, err)
+	release()
+}
@@ -14,6 +14,8 @@ func main() {
 		sealing.Piece{},
 		sealing.SectorInfo{},
 		sealing.Log{},
+		sealing.QueuedPreCommit{},
+		sealing.InFlightPreCommit{},
 	)
 	if err != nil {
 		fmt.Println(err)
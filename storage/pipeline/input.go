@@ -784,6 +784,8 @@ func (m *Sealing) SectorsStatus(ctx context.Context, sid abi.SectorNumber, showO
 		ToUpgrade:            false,
 		ReplicaUpdateMessage: info.ReplicaUpdateMessage,
 
+		PreCommitCutoffMargin: m.precommiter.CutoffMargin(sid),
+
 		LastErr: info.LastErr,
 		Log:     log,
 		// on chain info
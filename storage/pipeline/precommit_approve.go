@@ -0,0 +1,55 @@
+package sealing
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// ErrSendNotApproved is returned by send attempts which the ApproveSendFunc
+// denied. The sectors stay queued.
+var ErrSendNotApproved = xerrors.New("precommit send approval denied")
+
+// errApproveFailed is returned by send attempts skipped because the
+// ApproveSendFunc failed. The sectors stay queued.
+var errApproveFailed = xerrors.New("precommit send approval failed")
+
+// ApproveSendFunc is called just before each precommit message is sent, with the
+// sectors it carries, their total deposit, and the max fee of the message. When
+// it returns false the send is deferred, with the sectors staying queued; an
+// error skips the send attempt the same way.
+//
+// It's called with the batcher lock held, so it should return quickly and must
+// not call back into the batcher.
+type ApproveSendFunc func(ctx context.Context, sectors []abi.SectorNumber, deposit, maxFee abi.TokenAmount) (bool, error)
+
+// SetApproveSend makes the batcher ask approve before each send. A nil function,
+// the default, approves all sends.
+func (b *PreCommitBatcher) SetApproveSend(approve ApproveSendFunc) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.approve = approve
+}
+
+// approveSend returns ErrSendNotApproved or errApproveFailed unless the send of
+// the given sectors is approved. Must be called with b.lk held.
+func (b *PreCommitBatcher) approveSend(sectors []abi.SectorNumber, deposit, maxFee abi.TokenAmount) error {
+	if b.approve == nil {
+		return nil
+	}
+
+	ok, err := b.approve(b.mctx, sectors, deposit, maxFee)
+	if err != nil {
+		log.Warnw("precommit send approval failed, skipping the send", "sectors", sectors, "error", err)
+		return xerrors.Errorf("sectors %v: %s: %w", sectors, err, errApproveFailed)
+	}
+	if !ok {
+		log.Warnw("precommit send approval denied, keeping sectors queued", "sectors", sectors, "deposit", deposit, "maxFee", maxFee)
+		return xerrors.Errorf("sectors %v: %w", sectors, ErrSendNotApproved)
+	}
+
+	return nil
+}
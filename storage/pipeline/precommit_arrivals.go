@@ -0,0 +1,97 @@
+package sealing
+
+import (
+	"time"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// window over which precommit arrivals are counted to estimate the arrival rate
+const arrivalWindow = time.Hour
+
+// least time over which arrivals must be observed before the rate is trusted
+const minArrivalSpan = 10 * time.Minute
+
+// shortest batch wait picked by AdaptiveBatchWait
+const minAdaptiveBatchWait = time.Minute
+
+// arrivals keeps the times at which sectors were added to the queue
+type arrivals struct {
+	// when arrivals started being recorded
+	start time.Time
+	times []time.Time
+}
+
+func (a *arrivals) add(now time.Time) {
+	a.times = append(a.times, now)
+}
+
+// rate drops arrivals which fell out of the window, and returns the arrival rate
+// in sectors per second. Returns false if arrivals weren't observed for long
+// enough to estimate the rate.
+func (a *arrivals) rate(now time.Time) (float64, bool) {
+	span := now.Sub(a.start)
+	if span > arrivalWindow {
+		span = arrivalWindow
+	}
+	if span < minArrivalSpan {
+		return 0, false
+	}
+
+	start := now.Add(-arrivalWindow)
+
+	i := 0
+	for i < len(a.times) && !a.times[i].After(start) {
+		i++
+	}
+	a.times = a.times[i:]
+
+	return float64(len(a.times)) / span.Seconds(), true
+}
+
+// adaptiveWait returns how long to wait for a batch of max sectors to fill, with
+// queued sectors already waiting and new ones arriving at rate sectors per second.
+// The wait is at most maxWait. When not even one sector is expected to arrive
+// within maxWait, waiting won't make the batch fuller, so the wait is as short as
+// allowed.
+func adaptiveWait(rate float64, queued, max int, maxWait time.Duration) time.Duration {
+	minWait := minAdaptiveBatchWait
+	if minWait > maxWait {
+		minWait = maxWait
+	}
+
+	if rate*maxWait.Seconds() < 1 {
+		return minWait
+	}
+
+	room := max - queued
+	if room <= 0 {
+		return minWait
+	}
+
+	wait := time.Duration(float64(room) / rate * float64(time.Second))
+	switch {
+	case wait > maxWait:
+		return maxWait
+	case wait < minWait:
+		return minWait
+	}
+
+	return wait
+}
+
+// maxBatchWaitLocked returns the longest time a batch should wait, which is
+// PreCommitBatchWait, adjusted to the arrival rate with AdaptiveBatchWait. Must be
+// called with b.lk held.
+func (b *PreCommitBatcher) maxBatchWaitLocked(cfg sealiface.Config, now time.Time) time.Duration {
+	if !cfg.AdaptiveBatchWait {
+		return cfg.PreCommitBatchWait
+	}
+
+	rate, ok := b.arrivals.rate(now)
+	if !ok {
+		return cfg.PreCommitBatchWait
+	}
+
+	return adaptiveWait(rate, len(b.todo), cfg.MaxPreCommitBatch, cfg.PreCommitBatchWait)
+}
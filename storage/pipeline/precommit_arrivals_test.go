@@ -0,0 +1,53 @@
+package sealing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArrivalRate(t *testing.T) {
+	start := time.Now()
+	a := arrivals{start: start}
+
+	// not observed for long enough
+	a.add(start.Add(time.Minute))
+	_, ok := a.rate(start.Add(5 * time.Minute))
+	require.False(t, ok)
+
+	// one sector every 30 seconds for two hours, only the last hour counts
+	a = arrivals{start: start}
+	for i := 1; i <= 240; i++ {
+		a.add(start.Add(time.Duration(i) * 30 * time.Second))
+	}
+
+	rate, ok := a.rate(start.Add(2 * time.Hour))
+	require.True(t, ok)
+	require.InDelta(t, 1.0/30, rate, 1e-9)
+	require.Len(t, a.times, 120)
+}
+
+func TestAdaptiveWait(t *testing.T) {
+	const maxWait = 24 * time.Hour
+
+	// a sector every 30 seconds fills a batch of 256 in 128 minutes
+	require.Equal(t, 128*time.Minute, adaptiveWait(1.0/30, 0, 256, maxWait))
+
+	// sectors already queued leave less room to fill
+	require.Equal(t, 64*time.Minute, adaptiveWait(1.0/30, 128, 256, maxWait))
+
+	// filling takes longer than the wait allows
+	require.Equal(t, maxWait, adaptiveWait(1.0/3600, 0, 256, maxWait))
+
+	// no sectors expected within the wait, don't hold the queued ones
+	require.Equal(t, minAdaptiveBatchWait, adaptiveWait(0, 3, 256, maxWait))
+	require.Equal(t, minAdaptiveBatchWait, adaptiveWait(1.0/(48*3600), 3, 256, maxWait))
+
+	// fast arrivals, and a full queue
+	require.Equal(t, minAdaptiveBatchWait, adaptiveWait(10, 0, 256, maxWait))
+	require.Equal(t, minAdaptiveBatchWait, adaptiveWait(1.0/30, 256, 256, maxWait))
+
+	// the wait is never longer than maxWait
+	require.Equal(t, 30*time.Second, adaptiveWait(0, 3, 256, 30*time.Second))
+}
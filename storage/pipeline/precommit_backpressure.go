@@ -0,0 +1,56 @@
+package sealing
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// queueFullLocked returns whether queueing sector sn would take the queue above
+// PreCommitBatchMaxQueue. Must be called with b.lk held.
+func (b *PreCommitBatcher) queueFullLocked(cfg sealiface.Config, sn abi.SectorNumber) bool {
+	if _, queued := b.todo[sn]; queued {
+		return false
+	}
+
+	return cfg.PreCommitBatchMaxQueue > 0 && len(b.todo) >= cfg.PreCommitBatchMaxQueue
+}
+
+// queueSpaceLocked returns a channel which is closed once a sector leaves the
+// queue. Must be called with b.lk held.
+func (b *PreCommitBatcher) queueSpaceLocked() <-chan struct{} {
+	if b.queueSpace == nil {
+		b.queueSpace = make(chan struct{})
+	}
+	return b.queueSpace
+}
+
+// signalQueueSpaceLocked wakes up the AddPreCommit callers waiting for room in
+// the queue. Must be called with b.lk held.
+func (b *PreCommitBatcher) signalQueueSpaceLocked() {
+	if b.queueSpace != nil {
+		close(b.queueSpace)
+		b.queueSpace = nil
+	}
+}
+
+// waitQueueSpace waits until space is signalled, or until the given time, at
+// which the waiting sector is queued regardless of the queue size
+func (b *PreCommitBatcher) waitQueueSpace(ctx context.Context, space <-chan struct{}, until time.Time) error {
+	timer := b.clock.Timer(until.Sub(b.clock.Now()))
+	defer timer.Stop()
+
+	select {
+	case <-space:
+		return nil
+	case <-timer.C:
+		return nil
+	case <-b.stopped:
+		return ErrBatcherStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
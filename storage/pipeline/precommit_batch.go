@@ -3,11 +3,19 @@ package sealing
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ipfs/go-cid"
+	"github.com/raulk/clock"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
@@ -15,25 +23,110 @@ import (
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-state-types/builtin"
 	"github.com/filecoin-project/go-state-types/builtin/v8/miner"
+	"github.com/filecoin-project/go-state-types/exitcode"
 	"github.com/filecoin-project/go-state-types/network"
+	miner5 "github.com/filecoin-project/specs-actors/v5/actors/builtin/miner"
 
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors"
 	"github.com/filecoin-project/lotus/chain/actors/policy"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/metrics"
 	"github.com/filecoin-project/lotus/node/config"
 	"github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
 )
 
+// ErrBatcherStopped is returned to sectors waiting for a batch when the batcher is stopped
+var ErrBatcherStopped = xerrors.New("precommit batcher stopped")
+
+var errChainBehind = xerrors.New("chain head is behind the expected height")
+
+var errBaseFeeFalling = xerrors.New("base fee is falling")
+
+// errBelowMinBatch is returned by automatic send attempts with fewer than
+// MinPreCommitBatch sectors queued
+var errBelowMinBatch = xerrors.New("below the minimum precommit batch size")
+
+// ErrConfirmTimeout is returned by WaitConfirmed when the precommit message didn't
+// land on chain within PreCommitBatchConfirmTimeoutEpochs
+var ErrConfirmTimeout = xerrors.New("precommit message didn't land on chain in time")
+
+// ErrPreCommitReverted is returned by WaitConfirmed when the precommit message
+// landed on chain but failed
+var ErrPreCommitReverted = xerrors.New("precommit message reverted")
+
+// ErrQueueFull is returned, or set on results of evicted sectors, when the queue
+// is at PreCommitBatchMaxQueue
+var ErrQueueFull = xerrors.New("precommit queue is full")
+
+// ErrPreCommitRemoved is set on results of sectors removed from the queue with
+// RemovePreCommit
+var ErrPreCommitRemoved = xerrors.New("precommit removed from the queue")
+
+// ErrTooManyWaiters is returned when adding a precommit for a sector which already
+// has PreCommitBatchMaxWaitersPerSector callers waiting on it
+var ErrTooManyWaiters = xerrors.New("too many waiters for sector precommit")
+
+var errSendsPaused = xerrors.New("precommit sends are paused")
+
+var errOutsideSendWindow = xerrors.New("outside of the send window")
+
+var errUpgradeImminent = xerrors.New("network upgrade is imminent")
+
+var errStartupGrace = xerrors.New("in the startup grace period")
+
+var errBlocksFull = xerrors.New("not enough free gas in recent blocks")
+
+// how long to wait before retrying a send deferred because the chain head is behind
+const chainBehindRetryWait = time.Minute
+
+// number of landed batches kept in the gas model, and how long to look for a
+// sent batch message before giving up on it
+const (
+	batchGasSamples    = 16
+	batchGasLandingTTL = 24 * time.Hour
+)
+
+// how often the base fee is sampled in PreCommitFeeTrendMode, and the number of
+// samples used to determine the trend
+const (
+	feeTrendSampleInterval = 5 * time.Minute
+	feeTrendSamples        = 8
+)
+
+// how often WaitConfirmed looks for a sent precommit message on chain, and how
+// long sent messages are remembered for WaitConfirmed callers
+const (
+	confirmPollInterval = 30 * time.Second
+	confirmTTL          = 24 * time.Hour
+)
+
+// number of attempts made to read the sealing config when starting the batcher,
+// and the delay between them
+const (
+	startupConfigAttempts  = 5
+	startupConfigRetryWait = time.Second
+)
+
 //go:generate go run github.com/golang/mock/mockgen -destination=mocks/mock_precommit_batcher.go -package=mocks . PreCommitBatcherApi
 
 type PreCommitBatcherApi interface {
 	MpoolPushMessage(context.Context, *types.Message, *api.MessageSendSpec) (*types.SignedMessage, error)
 	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
 	StateMinerAvailableBalance(context.Context, address.Address, types.TipSetKey) (big.Int, error)
+	StateMinerSectorAllocated(context.Context, address.Address, abi.SectorNumber, types.TipSetKey) (bool, error)
+	StateSectorPreCommitInfo(ctx context.Context, maddr address.Address, sectorNumber abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorPreCommitOnChainInfo, error)
 	ChainHead(ctx context.Context) (*types.TipSet, error)
 	StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error)
+	StateGetNetworkParams(ctx context.Context) (*api.NetworkParams, error)
+	StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error)
+	StateWaitMsg(ctx context.Context, cid cid.Cid, confidence uint64, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error)
+	ChainGetBlockMessages(ctx context.Context, blockCid cid.Cid) (*api.BlockMessages, error)
+	GasEstimateMessageGas(context.Context, *types.Message, *api.MessageSendSpec, types.TipSetKey) (*types.Message, error)
+	MpoolGetNonce(context.Context, address.Address) (uint64, error)
+	MpoolPending(context.Context, types.TipSetKey) ([]*types.SignedMessage, error)
 
 	// Address selector
 	WalletBalance(context.Context, address.Address) (types.BigInt, error)
@@ -45,59 +138,246 @@ type PreCommitBatcherApi interface {
 type preCommitEntry struct {
 	deposit abi.TokenAmount
 	pci     *miner.SectorPreCommitInfo
+
+	// kept to recompute the cutoff
+	si SectorInfo
+
+	// preferred proving deadline, may be nil
+	deadlineHint *uint64
+
+	cutoffEpoch abi.ChainEpoch
+	queued      time.Time
+
+	// epochs left until the cutoff when the sector was queued
+	margin abi.ChainEpoch
+
+	// storage payments of deals in the sector
+	dealValue abi.TokenAmount
+}
+
+// batch message sent with PreCommitBatchGasFeedback enabled, waiting to land on chain
+type landingBatch struct {
+	msg     cid.Cid
+	sectors int
+	sent    time.Time
+}
+
+// message which carried a sector's precommit, kept for WaitConfirmed
+type sentPreCommit struct {
+	msg    cid.Cid
+	nonce  uint64
+	sent   time.Time
+	height abi.ChainEpoch
+	margin abi.ChainEpoch
 }
 
 type PreCommitBatcher struct {
-	api       PreCommitBatcherApi
-	maddr     address.Address
-	mctx      context.Context
-	addrSel   AddressSelector
-	feeCfg    config.MinerFeeConfig
-	getConfig dtypes.GetSealingConfigFunc
+	// unix nanos at which the run loop entered maybeStartBatch, 0 outside of it;
+	// accessed atomically, first for 64-bit alignment
+	attemptStart int64
+
+	api            PreCommitBatcherApi
+	maddr          address.Address
+	mctx           context.Context
+	addrSel        AddressSelector
+	feeCfg         config.MinerFeeConfig
+	getConfig      dtypes.GetSealingConfigFunc
+	cutoffStrategy CutoffStrategy
+	methodStrategy BatchMethodStrategy
+	send           SendFunc
+	validators     []BatchValidator
+	clock          clock.Clock
+
+	// asked before each send, may be nil
+	approve ApproveSendFunc
+
+	// persists the queue, may be nil
+	store BatcherStore
+
+	// called with each sent message, may be nil
+	onSubmit SubmittedFunc
 
 	cutoffs map[abi.SectorNumber]time.Time
 	todo    map[abi.SectorNumber]*preCommitEntry
 	waiting map[abi.SectorNumber][]chan sealiface.PreCommitBatchRes
 
+	feeTrend *baseFeeTrend
+
+	gasModel *batchGasModel
+	landing  []landingBatch
+
+	sent map[abi.SectorNumber]sentPreCommit
+
+	// sectors whose message was pushed before a restart, and was still pending or
+	// landed on startup; they aren't queued again when added
+	inFlight map[abi.SectorNumber]cid.Cid
+
+	// sectors queued from the store on startup, and not added again since; when
+	// added again, their queued entry is replaced and keeps its age
+	restored map[abi.SectorNumber]struct{}
+
+	// goroutines waiting for in-flight messages to land, the run loop waits for
+	// them before it reports the batcher stopped
+	landWatchers sync.WaitGroup
+
+	// closed when a sector leaves the queue, with PreCommitBatchBlockOnFullQueue
+	// AddPreCommit callers wait on it for room; nil when nobody waits
+	queueSpace chan struct{}
+
+	// miner info of the current send attempt, nil outside of one
+	miCache *minerInfoCache
+
+	// addresses batches were sent from in the current send attempt, nil outside
+	// of one; used by PreCommitRoundRobinAddresses
+	senders map[address.Address]*attemptSender
+
+	// set by PauseWithDeadlineRisk, no automatic sends happen before this time
+	pausedUntil time.Time
+
+	// when the batcher was created, PreCommitBatchStartupGrace counts from here
+	started time.Time
+
+	decisions *decisionLog
+	telemetry *telemetryExporter
+
+	// shared with other batchers sending from the same addresses, may be nil
+	funds FundsCoordinator
+
+	stats sealiface.PreCommitBatchStats
+
+	// sectors left queued in the current send attempt because the batch was full
+	deferredFull []abi.SectorNumber
+
+	emergency emergencySends
+	arrivals  arrivals
+
+	join attemptJoin
+
 	notify, stop, stopped chan struct{}
-	force                 chan chan []sealiface.PreCommitBatchRes
+	stopOnce              sync.Once
+	force                 chan forceRequest
+	reconfigure           chan chan error
 	lk                    sync.Mutex
+
+	// who took b.lk through lockTimed, and when; accessed with b.lk held
+	lockHolder string
+	lockTaken  time.Time
 }
 
-func NewPreCommitBatcher(mctx context.Context, maddr address.Address, api PreCommitBatcherApi, addrSel AddressSelector, feeCfg config.MinerFeeConfig, getConfig dtypes.GetSealingConfigFunc) *PreCommitBatcher {
+func NewPreCommitBatcher(mctx context.Context, maddr address.Address, api PreCommitBatcherApi, addrSel AddressSelector, feeCfg config.MinerFeeConfig, getConfig dtypes.GetSealingConfigFunc, cutoffStrategy CutoffStrategy, store BatcherStore, onSubmit SubmittedFunc) (*PreCommitBatcher, error) {
+	if cutoffStrategy == nil {
+		cutoffStrategy = DefaultCutoffStrategy{}
+	}
+
+	clk := build.Clock
+
+	cfg, err := getStartupConfig(mctx, clk, getConfig)
+	if err != nil {
+		return nil, xerrors.Errorf("starting precommit batcher: %w", err)
+	}
+
 	b := &PreCommitBatcher{
-		api:       api,
-		maddr:     maddr,
-		mctx:      mctx,
-		addrSel:   addrSel,
-		feeCfg:    feeCfg,
-		getConfig: getConfig,
+		api:            api,
+		maddr:          maddr,
+		mctx:           mctx,
+		addrSel:        addrSel,
+		feeCfg:         feeCfg,
+		getConfig:      getConfig,
+		cutoffStrategy: cutoffStrategy,
+		methodStrategy: DefaultBatchMethodStrategy{},
+		validators:     DefaultBatchValidators(),
+		clock:          clk,
+		store:          store,
+		onSubmit:       onSubmit,
 
 		cutoffs: map[abi.SectorNumber]time.Time{},
 		todo:    map[abi.SectorNumber]*preCommitEntry{},
 		waiting: map[abi.SectorNumber][]chan sealiface.PreCommitBatchRes{},
+		sent:    map[abi.SectorNumber]sentPreCommit{},
+
+		inFlight: map[abi.SectorNumber]cid.Cid{},
+		restored: map[abi.SectorNumber]struct{}{},
+
+		started:  clk.Now(),
+		arrivals: arrivals{start: clk.Now()},
+		feeTrend: newBaseFeeTrend(feeTrendSamples),
+		gasModel: newBatchGasModel(batchGasSamples),
+
+		stats: sealiface.PreCommitBatchStats{
+			Total:      sealiface.PreCommitBatchCounters{MaxFees: big.Zero()},
+			SinceReset: sealiface.PreCommitBatchCounters{MaxFees: big.Zero()},
+			LastReset:  clk.Now(),
+		},
 
 		notify:  make(chan struct{}, 1),
-		force:   make(chan chan []sealiface.PreCommitBatchRes),
+		force:   make(chan forceRequest),
 		stop:    make(chan struct{}),
 		stopped: make(chan struct{}),
+
+		reconfigure: make(chan chan error),
+	}
+	b.send = b.mpoolSend
+
+	if cfg.PreCommitBatchDecisionLogPath != "" {
+		f, err := os.OpenFile(cfg.PreCommitBatchDecisionLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, xerrors.Errorf("opening precommit decision log: %w", err)
+		}
+
+		b.setDecisionLog(f, f)
+	}
+
+	if store != nil {
+		b.restoreQueue()
 	}
 
-	go b.run()
+	go b.run(cfg)
+
+	return b, nil
+}
+
+// getStartupConfig reads the sealing config, retrying a few times so that a
+// transient failure doesn't prevent the batcher from starting. Gives up when ctx
+// is cancelled while waiting to retry.
+func getStartupConfig(ctx context.Context, clk clock.Clock, getConfig dtypes.GetSealingConfigFunc) (sealiface.Config, error) {
+	var err error
+	for i := 0; i < startupConfigAttempts; i++ {
+		if i > 0 {
+			select {
+			case <-clk.After(startupConfigRetryWait):
+			case <-ctx.Done():
+				return sealiface.Config{}, xerrors.Errorf("getting config: %w (last error: %s)", ctx.Err(), err)
+			}
+		}
+
+		var cfg sealiface.Config
+		cfg, err = getConfig()
+		if err == nil {
+			return cfg, nil
+		}
+
+		log.Warnw("failed to read sealing config", "attempt", i+1, "error", err)
+	}
 
-	return b
+	return sealiface.Config{}, xerrors.Errorf("getting config after %d attempts: %w", startupConfigAttempts, err)
 }
 
-func (b *PreCommitBatcher) run() {
+func (b *PreCommitBatcher) run(cfg sealiface.Config) {
 	var forceRes chan []sealiface.PreCommitBatchRes
 	var lastRes []sealiface.PreCommitBatchRes
 
-	cfg, err := b.getConfig()
-	if err != nil {
-		panic(err)
+	if cfg.PreCommitBatchManualSendMode && cfg.PreCommitBatchManualSendIgnoreCutoff {
+		log.Warnw("PreCommitBatcher running in manual send mode with cutoff safety disabled, sectors will expire if not flushed in time")
+	}
+
+	if cfg.PreCommitBatchFundsCheckInterval > 0 {
+		go b.watchFunds(cfg.PreCommitBatchFundsCheckInterval)
 	}
 
-	timer := time.NewTimer(b.batchWait(cfg.PreCommitBatchWait, cfg.PreCommitBatchSlack))
+	wait := b.batchWait(cfg)
+	sendAt := b.clk().Now().Add(wait)
+
+	timer := b.clk().Timer(b.timerWait(cfg, wait))
 	for {
 		if forceRes != nil {
 			forceRes <- lastRes
@@ -105,42 +385,164 @@ func (b *PreCommitBatcher) run() {
 		}
 		lastRes = nil
 
-		var sendAboveMax bool
+		var sendAboveMax, skip, sampled, fired, individual bool
+		var reconfigured chan error
 		select {
 		case <-b.stop:
+			b.lk.Lock()
+			if b.decisions != nil {
+				b.decisions.close()
+				b.decisions = nil
+			}
+			if b.telemetry != nil {
+				b.telemetry.close()
+				b.telemetry = nil
+			}
+			b.lk.Unlock()
+
+			b.landWatchers.Wait()
 			close(b.stopped)
 			return
 		case <-b.notify:
 			sendAboveMax = true
 		case <-timer.C:
-			// do nothing
+			fired = true
 		case fr := <-b.force: // user triggered
-			forceRes = fr
+			forceRes = fr.res
+			individual = fr.individual
+		case reconfigured = <-b.reconfigure:
+			// only resets the timer
+			skip = true
 		}
 
-		var err error
-		lastRes, err = b.maybeStartBatch(sendAboveMax)
-		if err != nil {
-			log.Warnw("PreCommitBatcher processBatch error", "error", err)
+		// re-read on each wakeup, so that config changes apply without a restart
+		cfgErr := b.reloadConfig(&cfg)
+
+		if fired {
+			// in manual send mode the timer only fires sends to protect sectors from reaching their cutoff
+			if cfg.PreCommitBatchManualSendMode {
+				skip = cfg.PreCommitBatchManualSendIgnoreCutoff || !b.hasUrgent(cfg.PreCommitBatchSlack)
+			}
+
+			// in fee trend mode the timer also fires early to sample the base fee, only
+			// send before the deadline if the base fee is rising
+			if !skip && cfg.PreCommitFeeTrendMode && b.clk().Now().Before(sendAt) {
+				sampled = true
+				skip = !b.sampleFeeRising()
+			}
 		}
 
-		if !timer.Stop() {
-			select {
-			case <-timer.C:
-			default:
+		var retryWait, pauseWait time.Duration
+		if !skip {
+			var err error
+			b.markAttempt(true)
+			b.openJoin()
+			if forceRes != nil {
+				lastRes, err = b.maybeStartBatch(cfg, false, true, individual)
+			} else {
+				lastRes, err = b.maybeStartBatch(cfg, sendAboveMax, false, false)
+			}
+			if joined := b.closeJoin(); len(joined) > 0 {
+				lastRes = b.serveJoined(cfg, joined, lastRes)
+			}
+			b.markAttempt(false)
+			if err != nil {
+				switch {
+				case xerrors.Is(err, errChainBehind):
+					retryWait = chainBehindRetryWait
+				case xerrors.Is(err, errBlocksFull), xerrors.Is(err, errOutsideSendWindow), xerrors.Is(err, errUpgradeImminent), xerrors.Is(err, errSendRetriesExhausted):
+					retryWait = time.Duration(build.BlockDelaySecs) * time.Second
+				case xerrors.Is(err, errBaseFeeFalling):
+					retryWait = feeTrendSampleInterval
+				case xerrors.Is(err, errSendsPaused):
+					pauseWait = b.pauseRemaining()
+				case xerrors.Is(err, errStartupGrace):
+					retryWait = b.startupGraceRemaining(cfg, b.clk().Now())
+				}
+				log.Warnw("PreCommitBatcher processBatch error", "error", err)
+			}
+		}
+
+		// a new timer is made from the current clock, which SetClock may have replaced
+		timer.Stop()
+		clk := b.clk()
+
+		switch {
+		case pauseWait > 0:
+			// sectors may be past their cutoff, don't wake up before the pause ends
+			wait = pauseWait
+			sendAt = clk.Now().Add(wait)
+		case sampled && skip:
+			// only sampled the base fee, keep the send deadline
+			wait = clk.Until(sendAt)
+		default:
+			wait = b.batchWait(cfg)
+			if retryWait > 0 && wait > retryWait {
+				wait = retryWait
 			}
+			sendAt = clk.Now().Add(wait)
+		}
+
+		timer = clk.Timer(b.timerWait(cfg, wait))
+
+		if reconfigured != nil {
+			reconfigured <- cfgErr // buffered
 		}
+	}
+}
 
-		timer.Reset(b.batchWait(cfg.PreCommitBatchWait, cfg.PreCommitBatchSlack))
+// timerWait returns how long the run loop should sleep given the time left until
+// the next send
+func (b *PreCommitBatcher) timerWait(cfg sealiface.Config, wait time.Duration) time.Duration {
+	if cfg.PreCommitFeeTrendMode && wait > feeTrendSampleInterval {
+		return feeTrendSampleInterval
+	}
+	if wait <= 0 {
+		return time.Nanosecond // can't return 0
 	}
+
+	return wait
 }
 
-func (b *PreCommitBatcher) batchWait(maxWait, slack time.Duration) time.Duration {
-	now := time.Now()
+// sampleFeeRising records the current base fee, and returns true if there are
+// sectors to send and the base fee is trending upwards
+func (b *PreCommitBatcher) sampleFeeRising() bool {
+	ts, err := b.api.ChainHead(b.mctx)
+	if err != nil {
+		log.Warnw("PreCommitBatcher sampling base fee", "error", err)
+		return false
+	}
 
 	b.lk.Lock()
 	defer b.lk.Unlock()
 
+	b.feeTrend.add(ts.Height(), ts.MinTicketBlock().ParentBaseFee)
+
+	if len(b.todo) > 0 && b.feeTrend.direction() > 0 {
+		log.Infow("base fee is rising, sending precommits early", "basefee", ts.MinTicketBlock().ParentBaseFee, "sectors", len(b.todo))
+		return true
+	}
+
+	return false
+}
+
+// batchWait returns the time until the next send attempt
+func (b *PreCommitBatcher) batchWait(cfg sealiface.Config) time.Duration {
+	defer b.lockTimed("batchWait")()
+
+	return b.batchWaitLocked(cfg, b.clock.Now())
+}
+
+// batchWaitLocked returns the time from now until the next send attempt, which is
+// the batch wait, or less when a queued sector is close to its cutoff. Must be
+// called with b.lk held.
+func (b *PreCommitBatcher) batchWaitLocked(cfg sealiface.Config, now time.Time) time.Duration {
+	maxWait, slack := b.maxBatchWaitLocked(cfg, now), cfg.PreCommitBatchSlack
+
+	if t := b.emergency.tunedWait; t > 0 && t < maxWait {
+		maxWait = t
+	}
+
 	if len(b.todo) == 0 {
 		return maxWait
 	}
@@ -176,122 +578,495 @@ func (b *PreCommitBatcher) batchWait(maxWait, slack time.Duration) time.Duration
 	return wait
 }
 
-func (b *PreCommitBatcher) maybeStartBatch(notif bool) ([]sealiface.PreCommitBatchRes, error) {
-	b.lk.Lock()
-	defer b.lk.Unlock()
+// maybeStartBatch is a send attempt. Notifications send full batches, forced
+// attempts send whatever is queued, and forceIndividual sends all of it in
+// individual messages. cfg is the config the run loop read when it woke up.
+func (b *PreCommitBatcher) maybeStartBatch(cfg sealiface.Config, notif, forced, forceIndividual bool) (res []sealiface.PreCommitBatchRes, err error) {
+	// deferred first, so that the callback runs once the lock is released
+	var submitted []sealiface.PreCommitBatchRes
+	defer func() {
+		b.notifySubmitted(submitted)
+	}()
+
+	defer b.lockTimed("maybeStartBatch")()
+
+	// miner info is read once for all batches of the attempt
+	b.miCache = &minerInfoCache{}
+	b.senders = map[address.Address]*attemptSender{}
+	defer func() {
+		b.miCache = nil
+		b.senders = nil
+	}()
 
 	total := len(b.todo)
 	if total == 0 {
 		return nil, nil // nothing to do
 	}
 
-	cfg, err := b.getConfig()
-	if err != nil {
-		return nil, xerrors.Errorf("getting config: %w", err)
+	// missing a cutoff wastes the seal, sectors past theirs are sent whatever the
+	// batch size, unless cutoff safety is disabled in manual send mode
+	var overdue []abi.SectorNumber
+	if !cfg.PreCommitBatchManualSendMode || !cfg.PreCommitBatchManualSendIgnoreCutoff {
+		overdue = b.overdueLocked(b.clock.Now())
+	}
+	if len(overdue) > 0 {
+		log.Errorw("precommits past their cutoff are queued, sending them now", "sectors", overdue, "queued", total)
+
+		// sent as a cutoff-driven attempt
+		notif = false
 	}
 
 	if notif && total < cfg.MaxPreCommitBatch {
 		return nil, nil
 	}
 
+	d := PreCommitDecision{
+		Time:   b.clock.Now(),
+		Queued: total,
+		Path:   "deferred",
+	}
+	b.deferredFull = nil
+	defer func() {
+		b.countResults(d.Path, res)
+		b.recordDecision(&d, res, err)
+	}()
+
+	// explicit flushes are still sent while paused
+	if now := b.clock.Now(); !forced && now.Before(b.pausedUntil) {
+		for sn := range b.todo {
+			if b.isUrgent(sn, cfg.PreCommitBatchSlack, now) {
+				log.Errorw("NOT sending precommit close to its cutoff, sends are paused", "sector", sn, "cutoff", b.cutoffs[sn], "pausedUntil", b.pausedUntil)
+			}
+		}
+
+		return nil, xerrors.Errorf("until %s: %w", b.pausedUntil, errSendsPaused)
+	}
+
+	// explicit flushes override the grace period unless configured otherwise
+	if grace := b.startupGraceRemaining(cfg, b.clock.Now()); grace > 0 && (!forced || cfg.PreCommitBatchFlushRespectsGrace) {
+		if total < cfg.MaxPreCommitBatch && !b.hasUrgentLocked(cfg.PreCommitBatchSlack) {
+			log.Infow("deferring precommit send, in the startup grace period", "remaining", grace, "sectors", total, "forced", forced)
+			return nil, xerrors.Errorf("%s left: %w", grace, errStartupGrace)
+		}
+	}
+
+	// small automatic sends wait for more sectors, unless a cutoff is close
+	if minBatch := cfg.MinPreCommitBatch; !forced && minBatch > 0 && total < minBatch && total < cfg.MaxPreCommitBatch {
+		if !b.hasUrgentLocked(cfg.PreCommitBatchSlack) {
+			log.Debugw("deferring precommit send, below the minimum batch size", "sectors", total, "min", minBatch)
+			return nil, xerrors.Errorf("%d sectors queued, %d required: %w", total, minBatch, errBelowMinBatch)
+		}
+	}
+
 	ts, err := b.api.ChainHead(b.mctx)
 	if err != nil {
 		return nil, err
 	}
 
+	d.Height, d.TipSet, d.BaseFee = ts.Height(), ts.Key(), ts.MinTicketBlock().ParentBaseFee
+
+	// the aggregate fee makes large batches costly when the base fee is high
+	if max, err := maxBatchAt(cfg, ts.MinTicketBlock().ParentBaseFee); err != nil {
+		log.Warnw("bad PreCommitBatchBaseFeeCaps, not capping the batch size", "error", err)
+	} else if max != cfg.MaxPreCommitBatch {
+		log.Infow("capping precommit batch size at the current base fee", "max", max, "configuredMax", cfg.MaxPreCommitBatch, "basefee", ts.MinTicketBlock().ParentBaseFee)
+		cfg.MaxPreCommitBatch = max
+	}
+
+	// a flush may have joined while reading the head
+	forced = forced || b.flushJoined()
+
+	// explicit flushes are never deferred
+	if cfg.PreCommitBatchMaxChainLag > 0 && !forced {
+		if lag := chainLag(ts, b.clock.Now()); lag > abi.ChainEpoch(cfg.PreCommitBatchMaxChainLag) {
+			if !b.hasUrgentLocked(cfg.PreCommitBatchSlack) {
+				log.Warnw("deferring precommit send, chain head is behind", "height", ts.Height(), "lag", lag, "maxLag", cfg.PreCommitBatchMaxChainLag)
+				return nil, xerrors.Errorf("head at %d, %d epochs behind: %w", ts.Height(), lag, errChainBehind)
+			}
+
+			log.Warnw("chain head is behind, sending precommits anyway due to imminent cutoff", "height", ts.Height(), "lag", lag)
+		}
+	}
+
+	if !forced && !inSendWindow(cfg, ts.Height()) && !b.hasUrgentLocked(cfg.PreCommitBatchSlack) {
+		log.Debugw("deferring precommit send, outside of the send window", "height", ts.Height(), "sectors", len(b.todo))
+		return nil, xerrors.Errorf("height %d: %w", ts.Height(), errOutsideSendWindow)
+	}
+
+	if cfg.PreCommitBatchUpgradeGuardEpochs > 0 && !forced && !b.hasUrgentLocked(cfg.PreCommitBatchSlack) {
+		if upgrade, ok := b.upgradeImminent(cfg, ts.Height()); ok {
+			log.Infow("deferring precommit send, network upgrade is imminent", "height", ts.Height(), "upgrade", upgrade, "sectors", len(b.todo))
+			return nil, xerrors.Errorf("upgrade at %d: %w", upgrade, errUpgradeImminent)
+		}
+	}
+
+	b.feeTrend.add(ts.Height(), ts.MinTicketBlock().ParentBaseFee)
+
+	if cfg.PreCommitBatchGasFeedback {
+		b.checkLanded()
+	}
+
+	// never defer explicit flushes, full batches, or sectors close to their cutoff
+	if cfg.PreCommitFeeTrendMode && !notif && !forced && b.feeTrend.direction() < 0 && !b.hasUrgentLocked(cfg.PreCommitBatchSlack) {
+		log.Infow("deferring precommit send, base fee is falling", "basefee", ts.MinTicketBlock().ParentBaseFee, "sectors", len(b.todo))
+		return nil, errBaseFeeFalling
+	}
+
 	// TODO: Drop this once nv14 has come and gone
 	nv, err := b.api.StateNetworkVersion(b.mctx, ts.Key())
 	if err != nil {
 		return nil, xerrors.Errorf("couldn't get network version: %w", err)
 	}
 
-	individual := false
-	if !cfg.BatchPreCommitAboveBaseFee.Equals(big.Zero()) && ts.MinTicketBlock().ParentBaseFee.LessThan(cfg.BatchPreCommitAboveBaseFee) && nv >= network.Version14 {
-		individual = true
+	d.NetworkVersion = nv
+	forced = forced || b.flushJoined()
+
+	b.logUrgentSectors(cfg, ts.Height())
+
+	individualReason, err := b.individualReasonLocked(cfg, ts.MinTicketBlock().ParentBaseFee, nv, forceIndividual)
+	if err != nil {
+		return nil, err
+	}
+	individual := individualReason != ""
+
+	switch individualReason {
+	case sealiface.IndividualNoSavings:
+		log.Infow("batching precommits doesn't save enough, sending individually", "sectors", len(b.todo), "minSavings", cfg.PreCommitBatchMinSavings, "basefee", ts.MinTicketBlock().ParentBaseFee)
+	case sealiface.IndividualDiagnoseReverts:
+		log.Warnw("batch revert diagnosis is enabled, sending precommits individually", "sectors", len(b.todo))
 	}
 
-	// todo support multiple batches
-	var res []sealiface.PreCommitBatchRes
-	if !individual {
-		res, err = b.processBatch(cfg, ts.Key(), ts.MinTicketBlock().ParentBaseFee, nv)
-	} else {
-		res, err = b.processIndividually(cfg)
+	if cfg.PreCommitBatchBlockFillCheck && !individual && !forced && !b.hasUrgentLocked(cfg.PreCommitBatchSlack) {
+		if err := b.checkBlockFill(cfg, ts); err != nil {
+			return nil, err
+		}
+	}
+
+	// sectors which can't or don't need to be sent
+	dropped, err := b.validateLocked(cfg, ts, nv)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PreCommitBatchCheckMpool {
+		dropped = append(dropped, b.dropPendingInMpool(ts.Key(), nv)...)
+	}
+	if cfg.PreCommitBatchCheckAllocation {
+		dropped = append(dropped, b.dropAllocated(ts.Key(), nv)...)
+	}
+
+	// automatic sends of less than a full batch only happen because of a cutoff
+	driver, driverFound := abi.SectorNumber(0), false
+	if !notif && !forced && len(b.todo) < cfg.MaxPreCommitBatch {
+		driver, driverFound = b.cutoffDriverLocked(cfg.PreCommitBatchSlack)
+	}
+	emergency := !notif && !forced && b.hasUrgentLocked(cfg.PreCommitBatchSlack)
+
+	// a lone urgent deal sector doesn't need to take the committed capacity sectors with it
+	lone, loneFound := abi.SectorNumber(0), false
+	if cfg.PreCommitBatchSendUrgentDealAlone && !individual && !notif && !forced && len(b.todo) < cfg.MaxPreCommitBatch {
+		lone, loneFound = b.loneUrgentDealLocked(cfg.PreCommitBatchSlack)
+	}
+
+	// flushes send up to PreCommitBatchMaxBatchesPerFlush batches, automatic sends
+	// as many as needed to empty the queue
+	maxBatches := 0
+	if forced {
+		maxBatches = cfg.PreCommitBatchMaxBatchesPerFlush
+		if maxBatches < 1 {
+			maxBatches = 1
+		}
+
+		// batches split at the actor limit don't count as more batches
+		if limit := batchSizeLimit(cfg); limit < cfg.MaxPreCommitBatch {
+			maxBatches *= (cfg.MaxPreCommitBatch + limit - 1) / limit
+		}
+	}
+
+	switch {
+	case len(b.todo) == 0:
+		// all sectors were dropped
+	case individual:
+		d.Path = "individual"
+		res, err = b.processIndividually(cfg, b.todo, ts.Key(), nv, individualReason)
+	case loneFound:
+		log.Infow("sending urgent deal sector alone, keeping committed capacity sectors queued", "sector", lone, "queued", len(b.todo)-1)
+		d.Path = "individual"
+		res, err = b.processIndividually(cfg, map[abi.SectorNumber]*preCommitEntry{lone: b.todo[lone]}, ts.Key(), nv, sealiface.IndividualUrgentDeal)
+	case cfg.PreCommitBatchSendUrgentIndividually:
+		d.Path = "hybrid"
+		res, err = b.processHybrid(cfg, maxBatches, ts.Key(), ts.MinTicketBlock().ParentBaseFee, nv)
+	default:
+		d.Path = "batch"
+		res, err = b.processBatches(cfg, b.todo, maxBatches, ts.Key(), ts.MinTicketBlock().ParentBaseFee, nv)
 	}
+	if driverFound && d.Path == "batch" {
+		b.markForcedByCutoff(cfg, res, driver, ts.Height())
+	}
+	if xerrors.Is(err, ErrSendNotApproved) || xerrors.Is(err, errApproveFailed) || xerrors.Is(err, errSendRetriesExhausted) {
+		d.Path = "deferred"
+	}
+
+	// results of messages sent by this attempt come before the dropped ones
+	nsent := len(res)
+
+	res = append(res, dropped...)
 	if err != nil && len(res) == 0 {
 		return nil, err
 	}
 
-	for _, r := range res {
-		if err != nil {
-			r.Error = err.Error()
+	for sn, sp := range b.sent {
+		if b.clock.Since(sp.sent) > confirmTTL {
+			delete(b.sent, sn)
+		}
+	}
+
+	for i := range res {
+		res[i].TipSet = ts.Cids()
+
+		// set the error on res directly, so that Flush callers see the same results as
+		// the waiters; results of messages which were sent keep them
+		if err != nil && res[i].Error == "" && res[i].Msg == nil {
+			res[i].Error = err.Error()
+		}
+
+		if res[i].Msg != nil && res[i].Error == "" && len(res[i].Sectors) > 0 {
+			res[i].CostPerSector = costPerSector(res[i])
+			_ = stats.RecordWithTags(b.mctx, []tag.Mutator{tag.Upsert(metrics.MinerID, b.maddr.String())},
+				metrics.PreCommitCostPerSector.M(types.BigDivFloat(res[i].CostPerSector, types.NewInt(build.FilecoinPrecision))))
+		}
+
+		r := res[i]
+		if r.Msg != nil && r.Error == "" && len(r.Sectors) > 0 {
+			b.storeInFlightLocked(*r.Msg, r.Sectors)
+		}
+		if i < nsent && r.Msg != nil && r.Error == "" {
+			b.recordSentLocked(r, b.clock.Now())
 		}
 
 		for _, sn := range r.Sectors {
+			if r.Msg != nil && r.Error == "" {
+				sp := sentPreCommit{msg: *r.Msg, nonce: r.Nonce, sent: b.clock.Now(), height: ts.Height()}
+				if p, ok := b.todo[sn]; ok {
+					sp.margin = p.margin
+				}
+				b.sent[sn] = sp
+			}
+
 			for _, ch := range b.waiting[sn] {
 				ch <- r // buffered
 			}
 
 			delete(b.waiting, sn)
-			delete(b.todo, sn)
+			b.dequeueLocked(sn)
+			delete(b.cutoffs, sn)
+		}
+
+		if i < nsent && r.Msg != nil && r.Error == "" {
+			submitted = append(submitted, r)
+		}
+
+		// invalid sectors left out of the batch fail on their own
+		for sn, reason := range r.FailedSectors {
+			fr := sealiface.PreCommitBatchRes{
+				Sectors:        []abi.SectorNumber{sn},
+				NetworkVersion: r.NetworkVersion,
+				TipSet:         r.TipSet,
+				Error:          reason,
+			}
+			for _, ch := range b.waiting[sn] {
+				ch <- fr // buffered
+			}
+
+			delete(b.waiting, sn)
+			b.dequeueLocked(sn)
 			delete(b.cutoffs, sn)
 		}
 	}
 
+	if emergency {
+		for _, r := range res {
+			if r.Msg != nil && r.Error == "" {
+				b.recordEmergencySendLocked(cfg, b.clock.Now())
+				break
+			}
+		}
+	}
+
 	return res, nil
 }
 
-func (b *PreCommitBatcher) processIndividually(cfg sealiface.Config) ([]sealiface.PreCommitBatchRes, error) {
-	mi, err := b.api.StateMinerInfo(b.mctx, b.maddr, types.EmptyTSK)
-	if err != nil {
-		return nil, xerrors.Errorf("couldn't get miner info: %w", err)
+// individualReasonLocked returns why the queued sectors are sent in individual
+// messages rather than batches at the given base fee, or "" when they're batched.
+// Send attempts and BatchTiming both decide with it. Must be called with b.lk
+// held.
+func (b *PreCommitBatcher) individualReasonLocked(cfg sealiface.Config, bf abi.TokenAmount, nv network.Version, forceIndividual bool) (string, error) {
+	if forceIndividual {
+		// sent individually whatever the fee mode
+		return sealiface.IndividualForced, nil
+	}
+
+	feeMode := cfg.PreCommitBatchAggFeeMode
+	if cfg.EconomicBatchDecision {
+		feeMode = "savings"
 	}
 
-	avail := types.TotalFilecoinInt
+	var reason string
+	switch feeMode {
+	case "", "threshold":
+		if belowBatchBaseFee(cfg, bf, nv) {
+			reason = sealiface.IndividualLowBaseFee
+		}
+	case "savings":
+		n := len(b.todo)
+		if n > cfg.MaxPreCommitBatch {
+			n = cfg.MaxPreCommitBatch
+		}
 
-	if cfg.CollateralFromMinerBalance && !cfg.DisableCollateralFallback {
-		avail, err = b.api.StateMinerAvailableBalance(b.mctx, b.maddr, types.EmptyTSK)
+		batch, savings, err := batchPays(cfg, nv, n, bf, b.batchGasLocked(n))
 		if err != nil {
-			return nil, xerrors.Errorf("getting available miner balance: %w", err)
+			return "", err
 		}
 
-		avail = big.Sub(avail, cfg.AvailableBalanceBuffer)
-		if avail.LessThan(big.Zero()) {
-			avail = big.Zero()
+		if !batch {
+			log.Debugw("batching precommits doesn't save enough", "sectors", n, "savings", types.FIL(savings), "minSavings", cfg.PreCommitBatchMinSavings, "basefee", bf)
+			reason = sealiface.IndividualNoSavings
 		}
+	default:
+		return "", xerrors.Errorf("unknown aggregate fee mode %q", feeMode)
+	}
+
+	if cfg.DiagnoseBatchReverts && reason == "" {
+		reason = sealiface.IndividualDiagnoseReverts
+	}
+
+	return reason, nil
+}
+
+// processIndividually sends a PreCommitSector message for each entry. Miner info
+// and balance are read at tsk, so that funds are computed from a consistent state.
+func (b *PreCommitBatcher) processIndividually(cfg sealiface.Config, entries map[abi.SectorNumber]*preCommitEntry, tsk types.TipSetKey, nv network.Version, reason string) ([]sealiface.PreCommitBatchRes, error) {
+	mi, err := b.minerInfo(tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	avail, err := b.individualAvailable(cfg, tsk)
+	if err != nil {
+		return nil, err
 	}
 
 	var res []sealiface.PreCommitBatchRes
+	var approveErr, sendErr error
+
+	for _, sn := range b.sendOrder(cfg, entries) {
+		// b.lk is released while a send waits to be retried
+		info, ok := entries[sn]
+		if !ok || b.todo[sn] != info {
+			continue
+		}
+
+		if err := b.approveSend([]abi.SectorNumber{sn}, info.deposit, big.Int(b.feeCfg.MaxPreCommitGasFee)); err != nil {
+			approveErr = err
+			continue
+		}
 
-	for sn, info := range b.todo {
 		r := sealiface.PreCommitBatchRes{
-			Sectors: []abi.SectorNumber{sn},
+			Sectors:        []abi.SectorNumber{sn},
+			NetworkVersion: nv,
+			DeadlineHint:   info.deadlineHint,
+			MaxFee:         big.Int(b.feeCfg.MaxPreCommitGasFee),
+			AggregateFee:   big.Zero(),
+			Deposit:        info.deposit,
+			SealProofs:     []abi.RegisteredSealProof{info.pci.SealProof},
+
+			IndividualReason: reason,
 		}
 
-		mcid, err := b.processSingle(cfg, mi, &avail, info)
+		mcid, msg, err := b.processSingle(cfg, mi, &avail, info)
+		if xerrors.Is(err, errSendRetriesExhausted) {
+			log.Warnw("couldn't send precommit message, keeping sector queued", "sector", sn, "error", err)
+			sendErr = err
+			continue
+		}
 		if err != nil {
 			r.Error = err.Error()
 		} else {
 			r.Msg = &mcid
+			r.Nonce = msg.Nonce
+			r.From = msg.From
+			log.Infow("Sent PreCommitSector message", "cid", mcid, "nonce", msg.Nonce, "from", msg.From, "sector", sn, "nv", nv, "tipset", tsk)
 		}
 
 		res = append(res, r)
 	}
 
+	// the sectors which weren't approved or couldn't be sent stay queued
+	if len(res) == 0 && approveErr != nil {
+		return nil, approveErr
+	}
+	if len(res) == 0 && sendErr != nil {
+		return nil, sendErr
+	}
+
 	return res, nil
 }
 
-func (b *PreCommitBatcher) processSingle(cfg sealiface.Config, mi api.MinerInfo, avail *abi.TokenAmount, params *preCommitEntry) (cid.Cid, error) {
-	enc := new(bytes.Buffer)
+// individualAvailable returns the miner balance deposits of individual precommits
+// can be paid from
+func (b *PreCommitBatcher) individualAvailable(cfg sealiface.Config, tsk types.TipSetKey) (abi.TokenAmount, error) {
+	if (cfg.CollateralFromMinerBalance && !cfg.DisableCollateralFallback) || usesMinerCollateral(cfg) {
+		return b.minerAvailable(cfg, tsk)
+	}
+	return types.TotalFilecoinInt, nil
+}
 
-	if err := params.pci.MarshalCBOR(enc); err != nil {
-		return cid.Undef, xerrors.Errorf("marshaling precommit params: %w", err)
+// processSingle sends the PreCommitSector message of the entry, returning its CID
+// and the message as sent
+func (b *PreCommitBatcher) processSingle(cfg sealiface.Config, mi api.MinerInfo, avail *abi.TokenAmount, params *preCommitEntry) (cid.Cid, *types.Message, error) {
+	msg, goodFunds, err := b.singleMsg(cfg, mi, avail, params)
+	if err != nil {
+		return cid.Undef, nil, err
 	}
 
-	deposit := params.deposit
-	if cfg.CollateralFromMinerBalance {
-		c := big.Sub(deposit, *avail)
-		*avail = big.Sub(*avail, deposit)
-		deposit = c
+	release, err := reserveFunds(b.mctx, b.funds, msg.From, goodFunds)
+	if err != nil {
+		return cid.Undef, nil, xerrors.Errorf("reserving funds: %w", err)
+	}
+	defer release()
+
+	mcid, err := b.sendRetrying(cfg, msg, big.Int(b.feeCfg.MaxPreCommitGasFee), map[abi.SectorNumber]*preCommitEntry{params.pci.SectorNumber: params})
+	if err != nil {
+		return cid.Undef, nil, xerrors.Errorf("pushing message to mpool: %w", err)
+	}
+
+	return mcid, msg, nil
+}
+
+// singleMsg builds the PreCommitSector message of the entry, taking the part of
+// the deposit paid from the miner balance out of avail. Returns the message and
+// the funds the sender needs.
+func (b *PreCommitBatcher) singleMsg(cfg sealiface.Config, mi api.MinerInfo, avail *abi.TokenAmount, params *preCommitEntry) (*types.Message, abi.TokenAmount, error) {
+	enc := new(bytes.Buffer)
+
+	if err := params.pci.MarshalCBOR(enc); err != nil {
+		return nil, big.Zero(), xerrors.Errorf("marshaling precommit params: %w", err)
+	}
+
+	deposit := params.deposit
+	var from address.Address
+	switch {
+	case len(cfg.PreCommitCollateralSources) > 0:
+		plan, err := b.planSourcedCollateral(cfg, deposit, big.Int(b.feeCfg.MaxPreCommitGasFee), *avail)
+		if err != nil {
+			return nil, big.Zero(), err
+		}
+
+		*avail = big.Sub(*avail, plan.fromMiner)
+		deposit, from = plan.value, plan.from
+	case cfg.CollateralFromMinerBalance:
+		c := big.Sub(deposit, *avail)
+		*avail = big.Sub(*avail, deposit)
+		deposit = c
 
 		if deposit.LessThan(big.Zero()) {
 			deposit = big.Zero()
@@ -301,134 +1076,1946 @@ func (b *PreCommitBatcher) processSingle(cfg sealiface.Config, mi api.MinerInfo,
 		}
 	}
 
-	goodFunds := big.Add(deposit, big.Int(b.feeCfg.MaxPreCommitGasFee))
+	goodFunds := big.Add(deposit, big.Int(b.feeCfg.MaxPreCommitGasFee))
+
+	if from == address.Undef {
+		var err error
+		from, _, err = b.addrSel.AddressFor(b.mctx, b.api, mi, api.PreCommitAddr, goodFunds, addrMinFunds(cfg, goodFunds, deposit))
+		if err != nil {
+			return nil, big.Zero(), xerrors.Errorf("no good address to send precommit message from: %w", err)
+		}
+	}
+
+	return &types.Message{
+		To:     b.maddr,
+		From:   from,
+		Value:  deposit,
+		Method: builtin.MethodsMiner.PreCommitSector,
+		Params: enc.Bytes(),
+	}, goodFunds, nil
+}
+
+// upgradeImminent returns the height of the next network upgrade, if it is at most
+// PreCommitBatchUpgradeGuardEpochs after height
+func (b *PreCommitBatcher) upgradeImminent(cfg sealiface.Config, height abi.ChainEpoch) (abi.ChainEpoch, bool) {
+	params, err := b.api.StateGetNetworkParams(b.mctx)
+	if err != nil {
+		log.Warnw("getting network params, not checking for network upgrades", "error", err)
+		return 0, false
+	}
+
+	upgrade, ok := nextUpgrade(params.ForkUpgradeParams, height)
+	if !ok || upgrade-height > abi.ChainEpoch(cfg.PreCommitBatchUpgradeGuardEpochs) {
+		return 0, false
+	}
+
+	return upgrade, true
+}
+
+// nextUpgrade returns the height of the first network upgrade after height. The
+// heights are read from all Upgrade*Height fields of the network params, so that
+// upgrades added to them later are taken into account.
+func nextUpgrade(fp api.ForkUpgradeParams, height abi.ChainEpoch) (abi.ChainEpoch, bool) {
+	var next abi.ChainEpoch
+	found := false
+
+	v := reflect.ValueOf(fp)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if !strings.HasPrefix(name, "Upgrade") || !strings.HasSuffix(name, "Height") {
+			continue
+		}
+
+		h, ok := v.Field(i).Interface().(abi.ChainEpoch)
+		if !ok {
+			continue
+		}
+		if h > height && (!found || h < next) {
+			next, found = h, true
+		}
+	}
+
+	return next, found
+}
+
+// inSendWindow returns whether automatic sends are allowed at the given height
+func inSendWindow(cfg sealiface.Config, height abi.ChainEpoch) bool {
+	period := cfg.PreCommitBatchSendWindowPeriod
+	if period == 0 || height < 0 {
+		return true
+	}
+
+	// the window may wrap around the end of the period
+	offset := (uint64(height)%period + period - cfg.PreCommitBatchSendWindowStart%period) % period
+	return offset < cfg.PreCommitBatchSendWindowLength
+}
+
+// chainLag returns how many epochs the given head is behind the height expected
+// from wall-clock time
+func chainLag(ts *types.TipSet, now time.Time) abi.ChainEpoch {
+	headTime := time.Unix(int64(ts.MinTimestamp()), 0)
+	if !now.After(headTime) {
+		return 0
+	}
+
+	return abi.ChainEpoch(now.Sub(headTime) / (time.Duration(build.BlockDelaySecs) * time.Second))
+}
+
+// isUrgent returns true when the sector cutoff falls within the slack period
+func (b *PreCommitBatcher) isUrgent(sn abi.SectorNumber, slack time.Duration, now time.Time) bool {
+	cutoff := b.cutoffs[sn]
+	return !cutoff.IsZero() && cutoff.Add(-slack).Before(now)
+}
+
+func (b *PreCommitBatcher) hasUrgent(slack time.Duration) bool {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	return b.hasUrgentLocked(slack)
+}
+
+func (b *PreCommitBatcher) hasUrgentLocked(slack time.Duration) bool {
+	now := b.clock.Now()
+
+	for sn := range b.todo {
+		if b.isUrgent(sn, slack, now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// overdueLocked returns the queued sectors at or past their cutoff, in sector
+// number order. Must be called with b.lk held.
+func (b *PreCommitBatcher) overdueLocked(now time.Time) []abi.SectorNumber {
+	var overdue []abi.SectorNumber
+	for sn := range b.todo {
+		if cutoff := b.cutoffs[sn]; !cutoff.IsZero() && !cutoff.After(now) {
+			overdue = append(overdue, sn)
+		}
+	}
+
+	sort.Slice(overdue, func(i, j int) bool {
+		return overdue[i] < overdue[j]
+	})
+
+	return overdue
+}
+
+// cutoffDriverLocked returns the queued sector with the earliest cutoff, if that
+// cutoff is within the slack. Must be called with b.lk held.
+func (b *PreCommitBatcher) cutoffDriverLocked(slack time.Duration) (abi.SectorNumber, bool) {
+	now := b.clock.Now()
+
+	var driver abi.SectorNumber
+	found := false
+	for sn := range b.todo {
+		if !b.isUrgent(sn, slack, now) {
+			continue
+		}
+
+		if !found || b.cutoffs[sn].Before(b.cutoffs[driver]) {
+			driver, found = sn, true
+		}
+	}
+
+	return driver, found
+}
+
+// loneUrgentDealLocked returns the only urgent queued sector, if it has deals and
+// no other queued sector does. Must be called with b.lk held.
+func (b *PreCommitBatcher) loneUrgentDealLocked(slack time.Duration) (abi.SectorNumber, bool) {
+	now := b.clock.Now()
+
+	var lone abi.SectorNumber
+	found := false
+	for sn, p := range b.todo {
+		urgent := b.isUrgent(sn, slack, now)
+		if urgent && found {
+			return 0, false // more than one urgent sector
+		}
+
+		switch {
+		case urgent && p.si.hasDeals():
+			lone, found = sn, true
+		case urgent, p.si.hasDeals():
+			// an urgent CC sector, or another deal sector, which is worth batching with
+			return 0, false
+		}
+	}
+
+	return lone, found
+}
+
+// markForcedByCutoff sets ForcedByCutoff on sent batches with less than
+// MaxPreCommitBatch sectors, and reports them
+func (b *PreCommitBatcher) markForcedByCutoff(cfg sealiface.Config, res []sealiface.PreCommitBatchRes, driver abi.SectorNumber, height abi.ChainEpoch) {
+	cutoffEpoch := b.todo[driver].cutoffEpoch
+
+	for i := range res {
+		if res[i].Msg == nil || len(res[i].Sectors) >= cfg.MaxPreCommitBatch {
+			continue
+		}
+
+		res[i].ForcedByCutoff = cutoffEpoch
+
+		log.Infow("precommit batch sent below max size due to a cutoff",
+			"sectors", len(res[i].Sectors), "max", cfg.MaxPreCommitBatch,
+			"sector", driver, "cutoffEpoch", cutoffEpoch, "height", height, "cid", res[i].Msg)
+	}
+}
+
+// logUrgentSectors logs sectors which are being sent immediately because their
+// cutoff is within PreCommitBatchSlack, so that sectors which are perpetually
+// urgent (e.g. because of bad deal / ticket data) are easy to spot
+func (b *PreCommitBatcher) logUrgentSectors(cfg sealiface.Config, height abi.ChainEpoch) {
+	now := b.clock.Now()
+
+	var urgent int
+	for sn, p := range b.todo {
+		if !b.isUrgent(sn, cfg.PreCommitBatchSlack, now) {
+			continue
+		}
+
+		urgent++
+		log.Warnw("sending precommit immediately due to imminent cutoff", "sector", sn, "cutoffEpoch", p.cutoffEpoch, "height", height, "cutoff", b.cutoffs[sn])
+	}
+
+	if urgent == 0 {
+		log.Infow("sending precommits", "sectors", len(b.todo), "height", height)
+	}
+}
+
+// processHybrid sends sectors which are within PreCommitBatchSlack of their cutoff
+// individually, and batches the remaining sectors
+func (b *PreCommitBatcher) processHybrid(cfg sealiface.Config, maxBatches int, tsk types.TipSetKey, bf abi.TokenAmount, nv network.Version) ([]sealiface.PreCommitBatchRes, error) {
+	now := b.clock.Now()
+
+	urgent := map[abi.SectorNumber]*preCommitEntry{}
+	rest := map[abi.SectorNumber]*preCommitEntry{}
+	for sn, p := range b.todo {
+		if b.isUrgent(sn, cfg.PreCommitBatchSlack, now) {
+			urgent[sn] = p
+		} else {
+			rest[sn] = p
+		}
+	}
+
+	if len(urgent) == 0 {
+		return b.processBatches(cfg, rest, maxBatches, tsk, bf, nv)
+	}
+
+	res, err := b.processIndividually(cfg, urgent, tsk, nv, sealiface.IndividualUrgent)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) == 0 {
+		return res, nil
+	}
+
+	bres, err := b.processBatches(cfg, rest, maxBatches, tsk, bf, nv)
+	if err != nil {
+		log.Warnw("PreCommitBatcher processBatch error in hybrid send", "error", err, "urgent", len(urgent))
+
+		for i := range bres {
+			bres[i].Error = err.Error()
+		}
+	}
+
+	return append(res, bres...), nil
+}
+
+// processBatches sends the entries in as many batch messages as needed, at most
+// maxBatches when it's positive, most urgent sectors first. It stops at the first
+// batch which fails to send; its sectors fail, or stay queued when the send kept
+// failing with transient errors, while the results of the batches sent before it
+// are kept.
+func (b *PreCommitBatcher) processBatches(cfg sealiface.Config, entries map[abi.SectorNumber]*preCommitEntry, maxBatches int, tsk types.TipSetKey, bf abi.TokenAmount, nv network.Version) ([]sealiface.PreCommitBatchRes, error) {
+	left := make(map[abi.SectorNumber]*preCommitEntry, len(entries))
+	for sn, p := range entries {
+		left[sn] = p
+	}
+
+	var res []sealiface.PreCommitBatchRes
+	for n := 0; len(left) > 0 && (maxBatches <= 0 || n < maxBatches); n++ {
+		// sectors may have left the queue while a send waited to be retried
+		for sn, p := range left {
+			if b.todo[sn] != p {
+				delete(left, sn)
+			}
+		}
+		if len(left) == 0 {
+			break
+		}
+
+		bres, err := b.processBatch(cfg, left, tsk, bf, nv)
+		if err != nil {
+			if n == 0 {
+				return bres, err
+			}
+
+			log.Warnw("sending precommit batch failed, keeping the batches already sent", "batch", n+1, "sent", len(res), "error", err)
+			for i := range bres {
+				bres[i].Error = err.Error()
+			}
+			return append(res, bres...), nil
+		}
+
+		res = append(res, bres...)
+
+		sent := 0
+		for _, r := range bres {
+			for _, sn := range r.Sectors {
+				delete(left, sn)
+				sent++
+			}
+			for sn := range r.FailedSectors {
+				delete(left, sn)
+			}
+		}
+		if sent == 0 || len(b.deferredFull) == 0 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func (b *PreCommitBatcher) processBatch(cfg sealiface.Config, entries map[abi.SectorNumber]*preCommitEntry, tsk types.TipSetKey, bf abi.TokenAmount, nv network.Version) ([]sealiface.PreCommitBatchRes, error) {
+	res, bm, err := b.assembleBatch(cfg, entries, tsk, bf, nv)
+	if err != nil {
+		return []sealiface.PreCommitBatchRes{res}, err
+	}
+
+	res, bm, err = b.fitBlockGas(cfg, entries, res, bm, tsk, bf, nv)
+	if err != nil {
+		return []sealiface.PreCommitBatchRes{res}, err
+	}
+
+	if err := b.approveSend(res.Sectors, bm.deposit, bm.maxFee); err != nil {
+		return nil, err
+	}
+
+	if len(bm.deferred) > 0 {
+		log.Infow("precommit batch full, deferring sectors", "max", cfg.MaxPreCommitBatch, "deferred", bm.deferred)
+		b.deferredFull = bm.deferred
+	}
+
+	if warn := cfg.PreCommitBatchDepositWarnThreshold; !warn.Nil() && warn.GreaterThan(big.Zero()) && bm.deposit.GreaterThan(warn) {
+		log.Warnw("precommit batch deposit above warning threshold", "deposit", types.FIL(bm.deposit), "threshold", types.FIL(warn), "sectors", len(res.Sectors))
+		stats.Record(b.mctx, metrics.PreCommitBatchDepositWarn.M(1))
+	}
+
+	release, err := reserveFunds(b.mctx, b.funds, bm.msg.From, big.Add(bm.msg.Value, bm.maxFee))
+	if err != nil {
+		return []sealiface.PreCommitBatchRes{res}, xerrors.Errorf("reserving funds: %w", err)
+	}
+
+	sent := make(map[abi.SectorNumber]*preCommitEntry, len(res.Sectors))
+	for _, sn := range res.Sectors {
+		sent[sn] = entries[sn]
+	}
+
+	msg := bm.msg
+	mcid, err := b.sendRetrying(cfg, &msg, bm.maxFee, sent)
+	release()
+	if xerrors.Is(err, errSendRetriesExhausted) {
+		// the sectors stay queued for the next send attempt
+		return nil, xerrors.Errorf("sending message failed: %w", err)
+	}
+	if err != nil {
+		return []sealiface.PreCommitBatchRes{res}, xerrors.Errorf("sending message failed: %w", err)
+	}
+
+	res.Msg = &mcid
+	res.Nonce = msg.Nonce
+	res.From = msg.From
+
+	b.noteSenderLocked(msg.From, big.Add(msg.Value, bm.maxFee))
+
+	// tagged with the miner, so that fees of all miners of an operator can be summed up
+	_ = stats.RecordWithTags(b.mctx, []tag.Mutator{tag.Upsert(metrics.MinerID, b.maddr.String())},
+		metrics.PreCommitBatchAggregateFee.M(types.BigDivFloat(bm.aggFee, types.NewInt(build.FilecoinPrecision))))
+
+	if cfg.PreCommitBatchGasFeedback {
+		b.landing = append(b.landing, landingBatch{
+			msg:     mcid,
+			sectors: len(res.Sectors),
+			sent:    b.clock.Now(),
+		})
+	}
+
+	log.Infow("Sent PreCommitSectorBatch message", "cid", mcid, "nonce", msg.Nonce, "from", bm.msg.From, "method", bm.msg.Method, "sectors", len(res.Sectors), "proofs", res.SealProofs, "nv", nv, "tipset", tsk)
+
+	return []sealiface.PreCommitBatchRes{res}, nil
+}
+
+// preCommitBatchMsg is an assembled PreCommitSectorBatch message, before gas
+// estimation and signing
+type preCommitBatchMsg struct {
+	msg     types.Message
+	deposit abi.TokenAmount
+	maxFee  abi.TokenAmount
+	aggFee  abi.TokenAmount
+
+	// sectors which didn't fit in the batch, in send order
+	deferred []abi.SectorNumber
+}
+
+// assembleBatch builds the batch message for up to MaxPreCommitBatch of the
+// entries, most urgent first
+func (b *PreCommitBatcher) assembleBatch(cfg sealiface.Config, entries map[abi.SectorNumber]*preCommitEntry, tsk types.TipSetKey, bf abi.TokenAmount, nv network.Version) (sealiface.PreCommitBatchRes, *preCommitBatchMsg, error) {
+	var sectors []miner.SectorPreCommitInfo
+	deposit := big.Zero()
+	res := sealiface.PreCommitBatchRes{NetworkVersion: nv}
+
+	now := b.clock.Now()
+
+	var group *preCommitEntry
+	var deferred []abi.SectorNumber
+	sameHint := true
+
+	maxBatch := batchSizeLimit(cfg)
+
+	// most urgent sectors first, so that they make it into the batch if it gets full
+	for _, sn := range b.sendOrder(cfg, entries) {
+		p := entries[sn]
+		if err := checkPreCommitEntry(p); err != nil {
+			log.Errorw("leaving invalid precommit out of the batch", "sector", sn, "error", err)
+			if res.FailedSectors == nil {
+				res.FailedSectors = map[abi.SectorNumber]string{}
+			}
+			res.FailedSectors[sn] = err.Error()
+			continue
+		}
+
+		if group == nil {
+			group = p
+		}
+
+		otherHint := !equalDeadlineHints(group.deadlineHint, p.deadlineHint)
+		// sectors for other deadlines go in a later batch, unless that's too late
+		if otherHint && cfg.PreCommitBatchGroupByDeadlineHint && !b.isUrgent(sn, cfg.PreCommitBatchSlack, now) {
+			continue
+		}
+
+		if len(sectors) >= maxBatch {
+			deferred = append(deferred, sn)
+			continue
+		}
+
+		if otherHint {
+			sameHint = false
+		}
+
+		res.Sectors = append(res.Sectors, p.pci.SectorNumber)
+		sectors = append(sectors, *p.pci)
+		deposit = big.Add(deposit, p.deposit)
+	}
+
+	if len(sectors) == 0 {
+		return res, nil, xerrors.Errorf("all %d sectors of the batch are invalid", len(res.FailedSectors))
+	}
+
+	if maxBatch < cfg.MaxPreCommitBatch && len(deferred) > 0 {
+		log.Warnw("MaxPreCommitBatch is above the actor limit, splitting the batch", "max", cfg.MaxPreCommitBatch, "limit", maxBatch, "deferred", len(deferred))
+	}
+
+	if group != nil && sameHint {
+		res.DeadlineHint = group.deadlineHint
+	}
+	res.DeferredFull = len(deferred)
+
+	res.SealProofs = sealProofs(sectors)
+	if len(res.SealProofs) > 1 {
+		log.Warnw("precommit batch mixes seal proof types", "proofs", res.SealProofs, "sectors", res.Sectors)
+	}
+
+	method, enc, err := b.methodStrategy.BatchMethod(cfg, nv, sectors)
+	if err != nil {
+		return res, nil, xerrors.Errorf("getting batch method: %w", err)
+	}
+
+	mi, err := b.minerInfo(tsk)
+	if err != nil {
+		return res, nil, err
+	}
+
+	needFunds, goodFunds, maxFee, aggFee, err := b.batchFunds(cfg, len(sectors), deposit, tsk, bf, nv)
+	if err != nil {
+		return res, nil, err
+	}
+	res.MaxFee = maxFee
+	res.AggregateFee = aggFee
+	res.Deposit = deposit
+
+	var from address.Address
+	if len(cfg.PreCommitCollateralSources) > 0 {
+		avail := big.Zero()
+		if usesMinerCollateral(cfg) {
+			avail, err = b.minerAvailable(cfg, tsk)
+			if err != nil {
+				return res, nil, err
+			}
+		}
+
+		plan, err := b.planSourcedCollateral(cfg, big.Add(deposit, aggFee), maxFee, avail)
+		if err != nil {
+			return res, nil, err
+		}
+
+		needFunds, from = plan.value, plan.from
+		goodFunds = big.Add(maxFee, needFunds)
+	}
+
+	if from == address.Undef && cfg.PreCommitRoundRobinAddresses {
+		from = b.roundRobinSender(mi, goodFunds, tsk)
+	}
+
+	if from == address.Undef {
+		from, _, err = b.addrSel.AddressFor(b.mctx, b.api, mi, api.PreCommitAddr, goodFunds, addrMinFunds(cfg, goodFunds, deposit))
+		if err != nil {
+			return res, nil, xerrors.Errorf("no good address found: %w", err)
+		}
+	}
+
+	if cfg.PreCommitBatchVerifySenderBalance {
+		if err := b.checkSenderBalance(from, goodFunds); err != nil {
+			return res, nil, err
+		}
+	}
+
+	var gasLimit int64
+	if cfg.PreCommitBatchGasFeedback {
+		gasLimit, _ = b.gasModel.estimate(len(sectors))
+	}
+
+	return res, &preCommitBatchMsg{
+		msg: types.Message{
+			To:       b.maddr,
+			From:     from,
+			Value:    needFunds,
+			Method:   method,
+			Params:   enc,
+			GasLimit: gasLimit,
+		},
+		deposit:  deposit,
+		maxFee:   maxFee,
+		aggFee:   aggFee,
+		deferred: deferred,
+	}, nil
+}
+
+// batchSizeLimit returns MaxPreCommitBatch, capped at the number of sectors the
+// miner actor accepts in a single PreCommitSectorBatch message, above which the
+// message would fail on chain
+func batchSizeLimit(cfg sealiface.Config) int {
+	if cfg.MaxPreCommitBatch > miner5.PreCommitSectorBatchMaxSize {
+		return miner5.PreCommitSectorBatchMaxSize
+	}
+	return cfg.MaxPreCommitBatch
+}
+
+// sealProofs returns the distinct seal proof types of the sectors, in order
+func sealProofs(sectors []miner.SectorPreCommitInfo) []abi.RegisteredSealProof {
+	var out []abi.RegisteredSealProof
+	seen := map[abi.RegisteredSealProof]struct{}{}
+	for _, pci := range sectors {
+		if _, ok := seen[pci.SealProof]; ok {
+			continue
+		}
+		seen[pci.SealProof] = struct{}{}
+		out = append(out, pci.SealProof)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// recordDecision completes the decision record of a send attempt and writes it to
+// the decision log and telemetry sink, if set. Must be called with b.lk held,
+// after results were delivered.
+func (b *PreCommitBatcher) recordDecision(d *PreCommitDecision, res []sealiface.PreCommitBatchRes, err error) {
+	if b.decisions == nil && b.telemetry == nil {
+		return
+	}
+
+	d.MaxFee = big.Zero()
+	for _, r := range res {
+		for sn := range r.FailedSectors {
+			d.Failed = append(d.Failed, sn)
+		}
+		if r.Error != "" || r.Msg == nil {
+			d.Failed = append(d.Failed, r.Sectors...)
+			continue
+		}
+
+		d.Sent = append(d.Sent, r.Sectors...)
+		d.MaxFee = big.Add(d.MaxFee, b.resMaxFee(d.Path, r))
+	}
+
+	for sn := range b.todo {
+		d.Deferred = append(d.Deferred, sn)
+	}
+	d.DeferredFull = b.deferredFull
+	sort.Slice(d.Deferred, func(i, j int) bool {
+		return d.Deferred[i] < d.Deferred[j]
+	})
+
+	if err != nil {
+		d.Reason = err.Error()
+	}
+
+	if b.decisions != nil {
+		b.decisions.record(*d)
+	}
+	if b.telemetry != nil {
+		b.telemetry.record(*d, res)
+	}
+}
+
+// makeRoomLocked evicts the queued sector with the latest cutoff, failing it, when
+// PreCommitBatchEvictOnFullQueue is set and the sector being added has an earlier
+// cutoff. Must be called with b.lk held.
+func (b *PreCommitBatcher) makeRoomLocked(cfg sealiface.Config, sn abi.SectorNumber, cutoff time.Time) error {
+	if !cfg.PreCommitBatchEvictOnFullQueue || len(b.todo) == 0 {
+		return xerrors.Errorf("%d sectors queued: %w", len(b.todo), ErrQueueFull)
+	}
+
+	order := b.sendOrder(cfg, b.todo)
+	last := order[len(order)-1]
+
+	lastCutoff := b.cutoffs[last]
+	if !lastCutoff.IsZero() && !cutoff.Before(lastCutoff) {
+		return xerrors.Errorf("%d sectors queued, all with earlier cutoffs: %w", len(b.todo), ErrQueueFull)
+	}
+
+	log.Warnw("evicting precommit from full queue", "sector", last, "cutoff", lastCutoff, "for", sn, "newCutoff", cutoff)
+
+	r := sealiface.PreCommitBatchRes{
+		Sectors: []abi.SectorNumber{last},
+		Error:   xerrors.Errorf("evicted for sector %d: %w", sn, ErrQueueFull).Error(),
+	}
+	for _, ch := range b.waiting[last] {
+		ch <- r // buffered
+	}
+
+	delete(b.waiting, last)
+	b.dequeueLocked(last)
+	delete(b.cutoffs, last)
+
+	return nil
+}
+
+func equalDeadlineHints(a, b *uint64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// costPerSector returns the fees of a sent message, without deposits, divided by
+// the number of sectors in it. The max fee is used, so this is an upper bound.
+func costPerSector(r sealiface.PreCommitBatchRes) abi.TokenAmount {
+	fees := big.Zero()
+	if !r.MaxFee.Nil() {
+		fees = big.Add(fees, r.MaxFee)
+	}
+	if !r.AggregateFee.Nil() {
+		fees = big.Add(fees, r.AggregateFee)
+	}
+
+	return big.Div(fees, big.NewInt(int64(len(r.Sectors))))
+}
+
+// resMaxFee returns the max fee of the message sent for the result
+func (b *PreCommitBatcher) resMaxFee(path string, r sealiface.PreCommitBatchRes) abi.TokenAmount {
+	if path != "batch" && len(r.Sectors) == 1 {
+		return big.Int(b.feeCfg.MaxPreCommitGasFee)
+	}
+	return b.feeCfg.MaxPreCommitBatchGasFee.FeeForSectors(len(r.Sectors))
+}
+
+// countResults adds send results to the stats counters, must be called with b.lk held
+func (b *PreCommitBatcher) countResults(path string, res []sealiface.PreCommitBatchRes) {
+	for _, c := range []*sealiface.PreCommitBatchCounters{&b.stats.Total, &b.stats.SinceReset} {
+		for _, r := range res {
+			c.SectorsFailed += uint64(len(r.FailedSectors))
+			if r.Error != "" || r.Msg == nil {
+				c.SectorsFailed += uint64(len(r.Sectors))
+				continue
+			}
+
+			c.Messages++
+			c.SectorsSent += uint64(len(r.Sectors))
+			c.MaxFees = big.Add(c.MaxFees, b.resMaxFee(path, r))
+		}
+	}
+}
+
+// dropPendingInMpool removes sectors which already have a precommit message
+// pending in the mpool from the queue, returning results pointing at the
+// pending messages
+func (b *PreCommitBatcher) dropPendingInMpool(tsk types.TipSetKey, nv network.Version) []sealiface.PreCommitBatchRes {
+	pending, err := b.api.MpoolPending(b.mctx, tsk)
+	if err != nil {
+		log.Warnw("getting pending messages, not checking for precommits already in mpool", "error", err)
+		return nil
+	}
+
+	var res []sealiface.PreCommitBatchRes
+	for _, sm := range pending {
+		if sm.Message.To != b.maddr {
+			continue
+		}
+
+		var sectors []abi.SectorNumber
+		switch sm.Message.Method {
+		case builtin.MethodsMiner.PreCommitSectorBatch:
+			var params miner.PreCommitSectorBatchParams
+			if err := params.UnmarshalCBOR(bytes.NewReader(sm.Message.Params)); err != nil {
+				log.Warnw("decoding pending precommit batch params", "cid", sm.Cid(), "error", err)
+				continue
+			}
+			for _, p := range params.Sectors {
+				sectors = append(sectors, p.SectorNumber)
+			}
+		case builtin.MethodsMiner.PreCommitSector:
+			var params miner.SectorPreCommitInfo
+			if err := params.UnmarshalCBOR(bytes.NewReader(sm.Message.Params)); err != nil {
+				log.Warnw("decoding pending precommit params", "cid", sm.Cid(), "error", err)
+				continue
+			}
+			sectors = append(sectors, params.SectorNumber)
+		default:
+			continue
+		}
+
+		r := sealiface.PreCommitBatchRes{NetworkVersion: nv}
+		for _, sn := range sectors {
+			if _, queued := b.todo[sn]; queued {
+				r.Sectors = append(r.Sectors, sn)
+				b.dequeueLocked(sn)
+			}
+		}
+
+		if len(r.Sectors) > 0 {
+			mcid := sm.Cid()
+			r.Msg = &mcid
+			r.From = sm.Message.From
+
+			log.Warnw("precommits already pending in mpool, not sending them again", "cid", mcid, "sectors", r.Sectors)
+			res = append(res, r)
+		}
+	}
+
+	return res
+}
+
+// dropAllocated removes sectors whose numbers are already allocated on chain from
+// the queue, as precommitting them would fail the whole batch
+func (b *PreCommitBatcher) dropAllocated(tsk types.TipSetKey, nv network.Version) []sealiface.PreCommitBatchRes {
+	var res []sealiface.PreCommitBatchRes
+	for sn := range b.todo {
+		allocated, err := b.api.StateMinerSectorAllocated(b.mctx, b.maddr, sn, tsk)
+		if err != nil {
+			log.Warnw("checking sector number allocation, not dropping sector", "sector", sn, "error", err)
+			continue
+		}
+		if !allocated {
+			continue
+		}
+
+		log.Errorw("dropping precommit for sector number already allocated on chain", "sector", sn)
+
+		res = append(res, sealiface.PreCommitBatchRes{
+			Sectors:        []abi.SectorNumber{sn},
+			NetworkVersion: nv,
+			Error:          fmt.Sprintf("sector number %d is already allocated on chain", sn),
+		})
+		b.dequeueLocked(sn)
+	}
+
+	return res
+}
+
+// checkSenderBalance returns an error when the address doesn't hold at least need.
+// The address selector may pick an address which can't cover the whole message,
+// which otherwise only shows up as a message pool rejection.
+func (b *PreCommitBatcher) checkSenderBalance(from address.Address, need abi.TokenAmount) error {
+	bal, err := b.api.WalletBalance(b.mctx, from)
+	if err != nil {
+		return xerrors.Errorf("getting balance of %s: %w", from, err)
+	}
+
+	if bal.LessThan(need) {
+		return xerrors.Errorf("address %s has %s, needs %s (short %s)", from, types.FIL(bal), types.FIL(need), types.FIL(big.Sub(need, bal)))
+	}
+
+	return nil
+}
+
+// addrMinFunds returns the least funds an address must hold to be selected for
+// sending a precommit message. By default that's the deposit, which is the right
+// choice when the deposit is paid from the sending address. With collateral taken
+// from the miner balance, PreCommitAddressPreferFeeCoverage makes the selector
+// look for an address which covers what the message actually draws from it.
+func addrMinFunds(cfg sealiface.Config, goodFunds, deposit abi.TokenAmount) abi.TokenAmount {
+	if cfg.PreCommitAddressPreferFeeCoverage {
+		return goodFunds
+	}
+
+	return deposit
+}
+
+// checkBlockFill returns errBlocksFull when the estimated gas of the next batch
+// is more than the free gas in blocks at the chain head
+func (b *PreCommitBatcher) checkBlockFill(cfg sealiface.Config, ts *types.TipSet) error {
+	n := len(b.todo)
+	if n > cfg.MaxPreCommitBatch {
+		n = cfg.MaxPreCommitBatch
+	}
+
+	need, ok := b.gasModel.estimate(n)
+	if !ok {
+		return nil // no landed batches to estimate from yet
+	}
+
+	used, err := blockGasUsed(b.mctx, b.api, ts)
+	if err != nil {
+		log.Warnw("getting block gas usage, not checking if the precommit batch fits", "error", err)
+		return nil
+	}
+
+	if free := build.BlockGasLimit - used; need > free {
+		log.Infow("deferring precommit batch, not enough free gas in recent blocks", "need", need, "free", free, "height", ts.Height())
+		return xerrors.Errorf("batch of %d sectors needs %d gas, %d free: %w", n, need, free, errBlocksFull)
+	}
+
+	return nil
+}
+
+// checkLanded feeds gas used by landed batch messages into the gas model
+func (b *PreCommitBatcher) checkLanded() {
+	pending := b.landing[:0]
+	for _, lb := range b.landing {
+		ml, err := b.api.StateSearchMsg(b.mctx, types.EmptyTSK, lb.msg, api.LookbackNoLimit, true)
+		if err != nil {
+			log.Warnw("looking up precommit batch message", "cid", lb.msg, "error", err)
+		}
+
+		switch {
+		case ml != nil:
+			if ml.Receipt.ExitCode.IsSuccess() {
+				b.gasModel.observe(lb.sectors, ml.Receipt.GasUsed)
+			}
+		case b.clock.Since(lb.sent) < batchGasLandingTTL:
+			pending = append(pending, lb)
+		}
+	}
+
+	b.landing = pending
+}
+
+// sendOrder returns sector numbers of the entries in the order in which they
+// should be sent. Sectors close to their cutoff go first, then sectors which
+// have waited longer than PreCommitBatchMaxSectorWait, oldest first, then the
+// remaining sectors by deal value, highest first, and cutoff epoch. Sectors
+// without a cutoff go last, ties are broken by sector number.
+func (b *PreCommitBatcher) sendOrder(cfg sealiface.Config, entries map[abi.SectorNumber]*preCommitEntry) []abi.SectorNumber {
+	now := b.clock.Now()
+	maxWait := cfg.PreCommitBatchMaxSectorWait
+
+	class := func(sn abi.SectorNumber) int {
+		switch {
+		case b.isUrgent(sn, cfg.PreCommitBatchSlack, now):
+			return 0
+		case maxWait > 0 && now.Sub(entries[sn].queued) > maxWait:
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	sns := make([]abi.SectorNumber, 0, len(entries))
+	for sn := range entries {
+		sns = append(sns, sn)
+	}
+
+	sort.Slice(sns, func(i, j int) bool {
+		ki, kj := class(sns[i]), class(sns[j])
+		if ki != kj {
+			return ki < kj
+		}
+
+		qi, qj := entries[sns[i]].queued, entries[sns[j]].queued
+		if ki == 1 && !qi.Equal(qj) {
+			return qi.Before(qj)
+		}
+
+		vi, vj := entries[sns[i]].dealValue, entries[sns[j]].dealValue
+		if ki == 2 && !vi.Nil() && !vj.Nil() && !vi.Equals(vj) {
+			return vi.GreaterThan(vj)
+		}
+
+		// compare epochs, cutoff times of sectors queued at different times differ
+		// even when their cutoff epochs are the same
+		ci, cj := entries[sns[i]].cutoffEpoch, entries[sns[j]].cutoffEpoch
+		switch {
+		case ci == cj:
+			return sns[i] < sns[j]
+		case ci == 0:
+			return false
+		case cj == 0:
+			return true
+		default:
+			return ci < cj
+		}
+	})
+
+	return sns
+}
+
+// aggFeeBuffer returns the fraction of the aggregate network fee estimate which is
+// provisioned, PreCommitBatchFeeBufferByVersion overrides the default per version
+func aggFeeBuffer(cfg sealiface.Config, nv network.Version) (num, den abi.TokenAmount) {
+	if pct, ok := cfg.PreCommitBatchFeeBufferByVersion[nv]; ok {
+		return big.NewIntUnsigned(pct), big.NewInt(100)
+	}
+
+	return aggFeeNum, aggFeeDen
+}
+
+// batchFunds computes the value sent with a batch of n sectors, the amount of funds
+// the sending address should have, and the max fee for the batch message
+func (b *PreCommitBatcher) batchFunds(cfg sealiface.Config, n int, deposit abi.TokenAmount, tsk types.TipSetKey, bf abi.TokenAmount, nv network.Version) (needFunds, goodFunds, maxFee, aggFee abi.TokenAmount, err error) {
+	maxFee = b.feeCfg.MaxPreCommitBatchGasFee.FeeForSectors(n)
+
+	aggFeeRaw, err := policy.AggregatePreCommitNetworkFee(nv, n, bf)
+	if err != nil {
+		log.Errorf("getting aggregate precommit network fee: %s", err)
+		return big.Zero(), big.Zero(), big.Zero(), big.Zero(), xerrors.Errorf("getting aggregate precommit network fee: %s", err)
+	}
+
+	num, den := aggFeeBuffer(cfg, nv)
+	aggFee = big.Div(big.Mul(aggFeeRaw, num), den)
+
+	needFunds = big.Add(deposit, aggFee)
+	needFunds, err = collateralSendAmount(b.mctx, b.api, b.maddr, tsk, cfg, needFunds)
+	if err != nil {
+		return big.Zero(), big.Zero(), big.Zero(), big.Zero(), err
+	}
+
+	goodFunds = big.Add(maxFee, needFunds)
+
+	return needFunds, goodFunds, maxFee, aggFee, nil
+}
+
+// PreviewSendAddress returns the address which would be selected to send a batch
+// containing the currently queued sectors, without sending anything. The batch
+// is assembled as the first one of a send would be, so that the same sender
+// selection applies.
+func (b *PreCommitBatcher) PreviewSendAddress(ctx context.Context) (address.Address, error) {
+	cfg, err := b.getConfig()
+	if err != nil {
+		return address.Undef, xerrors.Errorf("getting config: %w", err)
+	}
+
+	ts, err := b.api.ChainHead(ctx)
+	if err != nil {
+		return address.Undef, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if len(b.todo) == 0 {
+		mi, err := b.api.StateMinerInfo(ctx, b.maddr, ts.Key())
+		if err != nil {
+			return address.Undef, xerrors.Errorf("couldn't get miner info: %w", err)
+		}
+
+		// nothing queued, select an address for an empty send
+		from, _, err := b.addrSel.AddressFor(ctx, b.api, mi, api.PreCommitAddr, big.Zero(), big.Zero())
+		if err != nil {
+			return address.Undef, xerrors.Errorf("no good address found: %w", err)
+		}
+
+		return from, nil
+	}
+
+	nv, err := b.api.StateNetworkVersion(ctx, ts.Key())
+	if err != nil {
+		return address.Undef, xerrors.Errorf("couldn't get network version: %w", err)
+	}
+
+	entries := make(map[abi.SectorNumber]*preCommitEntry, len(b.todo))
+	for sn, p := range b.todo {
+		entries[sn] = p
+	}
+
+	_, bm, err := b.assembleBatch(cfg, entries, ts.Key(), ts.MinTicketBlock().ParentBaseFee, nv)
+	if err != nil {
+		return address.Undef, xerrors.Errorf("assembling batch: %w", err)
+	}
+
+	return bm.msg.From, nil
+}
+
+// register PreCommit, wait for batch message, return message CID
+func (b *PreCommitBatcher) AddPreCommit(ctx context.Context, s SectorInfo, deposit abi.TokenAmount, in *miner.SectorPreCommitInfo) (res sealiface.PreCommitBatchRes, err error) {
+	return b.addPreCommit(ctx, s, deposit, in, nil)
+}
+
+// AddPreCommitWithDeadlineHint is like AddPreCommit, also passing the proving deadline
+// the sector is meant for. With PreCommitBatchGroupByDeadlineHint, sectors with
+// the same hint are batched together.
+func (b *PreCommitBatcher) AddPreCommitWithDeadlineHint(ctx context.Context, s SectorInfo, deposit abi.TokenAmount, in *miner.SectorPreCommitInfo, dlIdx uint64) (res sealiface.PreCommitBatchRes, err error) {
+	return b.addPreCommit(ctx, s, deposit, in, &dlIdx)
+}
+
+func (b *PreCommitBatcher) addPreCommit(ctx context.Context, s SectorInfo, deposit abi.TokenAmount, in *miner.SectorPreCommitInfo, deadlineHint *uint64) (res sealiface.PreCommitBatchRes, err error) {
+	ts, err := b.api.ChainHead(b.mctx)
+	if err != nil {
+		log.Errorf("getting chain head: %s", err)
+		return sealiface.PreCommitBatchRes{}, err
+	}
+
+	// a ticket from the future would push the cutoff out, and the sector would wait indefinitely
+	if s.TicketEpoch > ts.Height() {
+		log.Errorw("rejecting precommit with a ticket epoch ahead of the chain head", "sector", s.SectorNumber, "ticketEpoch", s.TicketEpoch, "height", ts.Height())
+		return sealiface.PreCommitBatchRes{}, xerrors.Errorf("sector %d ticket epoch %d is ahead of the chain head at %d", s.SectorNumber, s.TicketEpoch, ts.Height())
+	}
+
+	sn := s.SectorNumber
+
+	// sending a precommit again would fail on chain, along with the whole batch
+	msg, found, err := b.sentBefore(ts, sn)
+	if err != nil {
+		return sealiface.PreCommitBatchRes{}, xerrors.Errorf("checking for a sent precommit: %w", err)
+	}
+	if found {
+		log.Infow("precommit was already sent, not queuing it again", "sector", sn, "cid", msg)
+		return sealiface.PreCommitBatchRes{
+			Sectors: []abi.SectorNumber{sn},
+			TipSet:  ts.Cids(),
+			Msg:     &msg,
+		}, nil
+	}
+
+	cutoffEpoch, err := b.cutoffStrategy.PreCommitCutoff(ts.Height(), s)
+	if err != nil {
+		return sealiface.PreCommitBatchRes{}, xerrors.Errorf("failed to calculate cutoff: %w", err)
+	}
+	cutoff := b.cutoffTime(ts.Height(), cutoffEpoch)
+
+	cfg, err := b.getConfig()
+	if err != nil {
+		return sealiface.PreCommitBatchRes{}, xerrors.Errorf("getting config: %w", err)
+	}
+
+	if !cfg.PreCommitBatchSkipExpirationCheck {
+		// a single sector with a too-short lifetime would make the whole batch fail on chain
+		if err := b.checkExpiration(ts, s, in); err != nil {
+			log.Errorw("rejecting precommit", "sector", sn, "error", err)
+			return sealiface.PreCommitBatchRes{}, err
+		}
+	}
+
+	log.Debugw("queueing precommit", "sector", sn, "cutoffEpoch", cutoffEpoch, "height", ts.Height(), "margin", cutoffEpoch-ts.Height())
+
+	if cutoff.Add(-cfg.PreCommitBatchSlack).Before(b.clock.Now()) {
+		log.Warnw("precommit cutoff is imminent, sector will be sent immediately", "sector", sn, "cutoffEpoch", cutoffEpoch, "height", ts.Height(), "slack", cfg.PreCommitBatchSlack)
+	}
+
+	if deposit.Nil() {
+		// CC sectors can have no deposit, make sure that fund math doesn't trip over a nil value
+		deposit = big.Zero()
+	}
+
+	if err := checkMinDeposit(cfg, in, deposit); err != nil {
+		if cfg.PreCommitBatchRejectLowDeposit {
+			log.Errorw("rejecting precommit", "sector", sn, "error", err)
+			return sealiface.PreCommitBatchRes{}, err
+		}
+
+		log.Warnw("precommit deposit looks too low", "sector", sn, "error", err)
+	}
+
+	unlock := b.lockTimed("AddPreCommit")
+
+	// with a full queue the caller waits for room, unless the cutoff gets close
+	urgentAt := cutoff.Add(-cfg.PreCommitBatchSlack)
+	for cfg.PreCommitBatchBlockOnFullQueue && b.queueFullLocked(cfg, sn) && b.clock.Now().Before(urgentAt) {
+		space := b.queueSpaceLocked()
+		queued := len(b.todo)
+		unlock()
+
+		log.Infow("precommit queue is full, waiting for room", "sector", sn, "queued", queued, "max", cfg.PreCommitBatchMaxQueue)
+		if err := b.waitQueueSpace(ctx, space, urgentAt); err != nil {
+			return sealiface.PreCommitBatchRes{}, err
+		}
+
+		unlock = b.lockTimed("AddPreCommit")
+	}
+
+	if n := len(b.waiting[sn]); cfg.PreCommitBatchMaxWaitersPerSector > 0 && n >= cfg.PreCommitBatchMaxWaitersPerSector {
+		unlock()
+		log.Errorw("rejecting precommit, sector has too many waiters", "sector", sn, "waiters", n)
+		return sealiface.PreCommitBatchRes{}, xerrors.Errorf("sector %d has %d waiters: %w", sn, n, ErrTooManyWaiters)
+	}
+
+	// waiting sectors close to their cutoff are queued above the limit
+	if b.queueFullLocked(cfg, sn) && !cfg.PreCommitBatchBlockOnFullQueue {
+		if err := b.makeRoomLocked(cfg, sn, cutoff); err != nil {
+			unlock()
+			log.Errorw("rejecting precommit", "sector", sn, "error", err)
+			return sealiface.PreCommitBatchRes{}, err
+		}
+	}
+
+	// a sector restored from the store is already queued, the new entry keeps its age
+	queued := b.clock.Now()
+	if _, ok := b.restored[sn]; ok {
+		queued = b.todo[sn].queued
+		delete(b.restored, sn)
+	}
+
+	b.cutoffs[sn] = cutoff
+	b.todo[sn] = &preCommitEntry{
+		deposit: deposit,
+		pci:     in,
+		si:      s,
+
+		deadlineHint: deadlineHint,
+
+		cutoffEpoch: cutoffEpoch,
+		queued:      queued,
+
+		margin: cutoffEpoch - ts.Height(),
+
+		dealValue: s.dealValue(),
+	}
+	b.storeLocked(sn)
+	b.recordQueuedLocked()
+	b.arrivals.add(b.clock.Now())
+
+	// full batches are sent as soon as the run loop is notified
+	var expectedWait time.Duration
+	if len(b.todo) < cfg.MaxPreCommitBatch {
+		expectedWait = b.batchWaitLocked(cfg, b.clock.Now())
+	}
+
+	sent := make(chan sealiface.PreCommitBatchRes, 1)
+	b.waiting[sn] = append(b.waiting[sn], sent)
+
+	select {
+	case b.notify <- struct{}{}:
+	default: // already have a pending notification, don't need more
+		b.stats.Total.DroppedNotifies++
+		b.stats.SinceReset.DroppedNotifies++
+	}
+	unlock()
+
+	var stopped chan struct{}
+	if !cfg.PreCommitBatchKeepWaitersOnStop {
+		stopped = b.stopped
+	}
+
+	select {
+	case c := <-sent:
+		c.ExpectedWait = expectedWait
+		return c, nil
+	case <-stopped:
+		select {
+		case c := <-sent:
+			c.ExpectedWait = expectedWait
+			return c, nil
+		default:
+		}
+
+		return sealiface.PreCommitBatchRes{}, ErrBatcherStopped
+	case <-ctx.Done():
+		return sealiface.PreCommitBatchRes{}, ctx.Err()
+	}
+}
+
+func (b *PreCommitBatcher) checkExpiration(ts *types.TipSet, si SectorInfo, pci *miner.SectorPreCommitInfo) error {
+	nv, err := b.api.StateNetworkVersion(b.mctx, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("couldn't get network version: %w", err)
+	}
+
+	return checkExpirationAt(nv, ts.Height(), si, pci)
+}
+
+// checkExpirationAt returns an error when the sector lifetime would be too short
+// if it was precommitted at height
+func checkExpirationAt(nv network.Version, height abi.ChainEpoch, si SectorInfo, pci *miner.SectorPreCommitInfo) error {
+	av, err := actors.VersionForNetwork(nv)
+	if err != nil {
+		return xerrors.Errorf("getting actors version: %w", err)
+	}
+
+	msd, err := policy.GetMaxProveCommitDuration(av, si.SectorType)
+	if err != nil {
+		return xerrors.Errorf("getting max prove commit duration: %w", err)
+	}
+
+	// the chain checks the lifetime against the latest possible activation epoch
+	if minExpiration := height + msd + policy.GetMinSectorExpiration(); pci.Expiration < minExpiration {
+		return xerrors.Errorf("sector %d expiration %d is below the minimum sector lifetime (min expiration %d at height %d)", si.SectorNumber, pci.Expiration, minExpiration, height)
+	}
+
+	return nil
+}
+
+// checkMinDeposit returns an error when the deposit is below the configured
+// PreCommitBatchMinDepositPer32GiB floor, scaled to the sector size
+func checkMinDeposit(cfg sealiface.Config, pci *miner.SectorPreCommitInfo, deposit abi.TokenAmount) error {
+	floor := cfg.PreCommitBatchMinDepositPer32GiB
+	if floor.Nil() || !floor.GreaterThan(big.Zero()) {
+		return nil
+	}
+
+	ssize, err := pci.SealProof.SectorSize()
+	if err != nil {
+		return xerrors.Errorf("getting sector size: %w", err)
+	}
+
+	minDeposit := big.Div(big.Mul(floor, big.NewIntUnsigned(uint64(ssize))), big.NewIntUnsigned(32<<30))
+	if deposit.LessThan(minDeposit) {
+		return xerrors.Errorf("sector %d deposit %s is below the minimum of %s for %s sectors", pci.SectorNumber, types.FIL(deposit), types.FIL(minDeposit), ssize.ShortString())
+	}
+
+	return nil
+}
+
+// forceRequest asks the run loop for a forced send attempt, with the results
+// sent on res
+type forceRequest struct {
+	res chan []sealiface.PreCommitBatchRes
+
+	// send all queued sectors in individual messages
+	individual bool
+}
+
+func (b *PreCommitBatcher) Flush(ctx context.Context) ([]sealiface.PreCommitBatchRes, error) {
+	resCh := make(chan []sealiface.PreCommitBatchRes, 1)
+
+	if b.joinAttempt(resCh) {
+		return b.waitForced(ctx, resCh)
+	}
+
+	return b.forceAttempt(ctx, forceRequest{res: resCh})
+}
+
+// ForceIndividual sends all queued sectors right away, each in its own
+// PreCommitSector message, regardless of the base fee and batching settings.
+// Like Flush, it's serialized with the run loop, but it never joins a send
+// attempt in progress.
+func (b *PreCommitBatcher) ForceIndividual(ctx context.Context) ([]sealiface.PreCommitBatchRes, error) {
+	return b.forceAttempt(ctx, forceRequest{
+		res:        make(chan []sealiface.PreCommitBatchRes, 1),
+		individual: true,
+	})
+}
+
+// forceAttempt passes req to the run loop, and waits for the results
+func (b *PreCommitBatcher) forceAttempt(ctx context.Context, req forceRequest) ([]sealiface.PreCommitBatchRes, error) {
+	select {
+	case b.force <- req:
+	case <-b.stop:
+		// the run loop may exit without taking the flush request
+		return nil, ErrBatcherStopped
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return b.waitForced(ctx, req.res)
+}
+
+// waitForced waits for the results of a forced send attempt
+func (b *PreCommitBatcher) waitForced(ctx context.Context, resCh chan []sealiface.PreCommitBatchRes) ([]sealiface.PreCommitBatchRes, error) {
+	select {
+	case res := <-resCh:
+		return res, nil
+	case <-b.stopped:
+		select {
+		case res := <-resCh:
+			return res, nil
+		default:
+		}
+
+		return nil, ErrBatcherStopped
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Drain sends all queued sectors in as many messages as needed, most urgent
+// sectors first, until the queue is empty or ctx is cancelled
+func (b *PreCommitBatcher) Drain(ctx context.Context) ([]sealiface.PreCommitBatchRes, error) {
+	var out []sealiface.PreCommitBatchRes
+
+	for {
+		b.lk.Lock()
+		left := len(b.todo)
+		b.lk.Unlock()
+
+		if left == 0 {
+			return out, nil
+		}
+
+		res, err := b.Flush(ctx)
+		if err != nil {
+			return out, xerrors.Errorf("draining precommit batcher (%d sectors left): %w", left, err)
+		}
+
+		if len(res) == 0 {
+			return out, xerrors.Errorf("draining precommit batcher: no sectors were sent (%d sectors left)", left)
+		}
+
+		out = append(out, res...)
+	}
+}
+
+// WaitConfirmed waits until the message carrying the precommit of the given
+// sector lands on chain, returning its lookup. Sectors which are still queued
+// are waited on until they are sent. Messages which were executed with a non-zero
+// exit code return ErrPreCommitReverted.
+func (b *PreCommitBatcher) WaitConfirmed(ctx context.Context, sn abi.SectorNumber) (*api.MsgLookup, error) {
+	_, ml, err := b.waitIncluded(ctx, sn)
+	if err != nil {
+		return nil, err
+	}
+
+	if ml.Receipt.ExitCode != exitcode.Ok {
+		return nil, xerrors.Errorf("sector %d precommit message %s exit code %d: %w", sn, ml.Message, ml.Receipt.ExitCode, ErrPreCommitReverted)
+	}
+
+	return ml, nil
+}
+
+// PreCommitConfirmStatus is the outcome of waiting for a sent precommit message
+type PreCommitConfirmStatus string
+
+const (
+	// the message was executed successfully
+	PreCommitSucceeded PreCommitConfirmStatus = "succeeded"
+	// the message was executed, but failed with a non-zero exit code
+	PreCommitReverted PreCommitConfirmStatus = "reverted"
+	// the message wasn't executed within PreCommitBatchConfirmTimeoutEpochs
+	PreCommitNotIncluded PreCommitConfirmStatus = "not-included"
+)
+
+// PreCommitConfirmation classifies the outcome of a sent precommit message
+type PreCommitConfirmation struct {
+	Sector abi.SectorNumber
+	Msg    cid.Cid
+	Status PreCommitConfirmStatus
+
+	// set when the message was executed
+	TipSet   types.TipSetKey
+	Height   abi.ChainEpoch
+	ExitCode exitcode.ExitCode
+
+	// whether sending the precommit again with a higher fee is expected to help.
+	// Set for messages which weren't included; reverted messages would most likely
+	// fail the same way again.
+	RetryWithFeeBump bool
+}
+
+// WaitConfirmation is like WaitConfirmed, classifying the outcome of the message
+// instead of returning an error when it was reverted or not included in time.
+// Errors are only returned when the outcome couldn't be determined.
+func (b *PreCommitBatcher) WaitConfirmation(ctx context.Context, sn abi.SectorNumber) (PreCommitConfirmation, error) {
+	msg, ml, err := b.waitIncluded(ctx, sn)
+	switch {
+	case xerrors.Is(err, ErrConfirmTimeout):
+		// most likely priced out of the mpool, a higher fee should get it in
+		return PreCommitConfirmation{
+			Sector:           sn,
+			Msg:              msg,
+			Status:           PreCommitNotIncluded,
+			RetryWithFeeBump: true,
+		}, nil
+	case err != nil:
+		return PreCommitConfirmation{}, err
+	}
+
+	out := PreCommitConfirmation{
+		Sector:   sn,
+		Msg:      ml.Message,
+		Status:   PreCommitSucceeded,
+		TipSet:   ml.TipSet,
+		Height:   ml.Height,
+		ExitCode: ml.Receipt.ExitCode,
+	}
+
+	if ml.Receipt.ExitCode != exitcode.Ok {
+		log.Errorw("precommit message reverted", "sector", sn, "cid", ml.Message, "height", ml.Height, "exitCode", ml.Receipt.ExitCode)
+		out.Status = PreCommitReverted
+	}
+
+	return out, nil
+}
+
+// waitIncluded waits until the message carrying the precommit of the given sector
+// is executed, returning the sent message and its lookup
+func (b *PreCommitBatcher) waitIncluded(ctx context.Context, sn abi.SectorNumber) (cid.Cid, *api.MsgLookup, error) {
+	b.lk.Lock()
+	sp, found := b.sent[sn]
+	var sent chan sealiface.PreCommitBatchRes
+	if !found {
+		if _, queued := b.todo[sn]; !queued {
+			b.lk.Unlock()
+			return cid.Undef, nil, xerrors.Errorf("sector %d precommit isn't queued or recently sent", sn)
+		}
+
+		sent = make(chan sealiface.PreCommitBatchRes, 1)
+		b.waiting[sn] = append(b.waiting[sn], sent)
+	}
+	b.lk.Unlock()
+
+	if sent != nil {
+		select {
+		case res := <-sent:
+			if res.Error != "" {
+				return cid.Undef, nil, xerrors.Errorf("sending sector %d precommit: %s", sn, res.Error)
+			}
+			if res.Msg == nil {
+				return cid.Undef, nil, xerrors.Errorf("sector %d precommit was sent without a message", sn)
+			}
+			sp.msg, sp.nonce = *res.Msg, res.Nonce
+
+			b.lk.Lock()
+			if cur, ok := b.sent[sn]; ok && cur.msg == sp.msg {
+				sp = cur
+			}
+			b.lk.Unlock()
+		case <-b.stopped:
+			return cid.Undef, nil, ErrBatcherStopped
+		case <-ctx.Done():
+			return cid.Undef, nil, ctx.Err()
+		}
+	}
+
+	for {
+		ml, err := b.api.StateSearchMsg(ctx, types.EmptyTSK, sp.msg, api.LookbackNoLimit, true)
+		if err != nil {
+			return cid.Undef, nil, xerrors.Errorf("looking up sector %d precommit message %s: %w", sn, sp.msg, err)
+		}
+
+		if ml != nil {
+			ml, err = b.confirm(ctx, ml)
+			if err != nil {
+				return cid.Undef, nil, xerrors.Errorf("confirming sector %d precommit message %s: %w", sn, sp.msg, err)
+			}
+
+			b.lk.Lock()
+			delete(b.sent, sn)
+			b.lk.Unlock()
+
+			return sp.msg, ml, nil
+		}
+
+		if err := b.checkConfirmTimeout(ctx, sn, sp); err != nil {
+			return sp.msg, nil, err
+		}
+
+		select {
+		case <-b.clk().After(confirmPollInterval):
+		case <-b.stopped:
+			return cid.Undef, nil, ErrBatcherStopped
+		case <-ctx.Done():
+			return cid.Undef, nil, ctx.Err()
+		}
+	}
+}
+
+// PreCommitLanded describes the precommit of a sector which landed on chain
+type PreCommitLanded struct {
+	Sector abi.SectorNumber
+
+	// message which carried the precommit, and the tipset it was executed in
+	Msg    cid.Cid
+	TipSet types.TipSetKey
+	Height abi.ChainEpoch
+
+	// epoch at which the precommit was recorded in miner state, the prove-commit
+	// window is counted from it
+	PreCommitEpoch abi.ChainEpoch
+}
+
+// WaitPreCommitLanded is like WaitConfirmed, also reading the precommit of the
+// sector from miner state at the tipset the message was executed in, to report
+// the epoch at which it was recorded on chain
+func (b *PreCommitBatcher) WaitPreCommitLanded(ctx context.Context, sn abi.SectorNumber) (*PreCommitLanded, error) {
+	ml, err := b.WaitConfirmed(ctx, sn)
+	if err != nil {
+		return nil, err
+	}
+
+	pci, err := b.api.StateSectorPreCommitInfo(ctx, b.maddr, sn, ml.TipSet)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector %d precommit info: %w", sn, err)
+	}
+	if pci == nil {
+		return nil, xerrors.Errorf("sector %d precommit not found on chain after message %s landed", sn, ml.Message)
+	}
+
+	return &PreCommitLanded{
+		Sector:         sn,
+		Msg:            ml.Message,
+		TipSet:         ml.TipSet,
+		Height:         ml.Height,
+		PreCommitEpoch: pci.PreCommitEpoch,
+	}, nil
+}
+
+// PauseWithDeadlineRisk stops all automatic sends until the given time, including
+// sends of sectors close to their cutoff, which may make those sectors expire.
+// Explicit flushes are still sent. A zero time ends the pause.
+func (b *PreCommitBatcher) PauseWithDeadlineRisk(until time.Time) {
+	b.lk.Lock()
+	b.pausedUntil = until
+	b.lk.Unlock()
+
+	if until.IsZero() {
+		log.Warnw("precommit sends resumed")
+	} else {
+		log.Errorw("precommit sends paused, sectors close to their cutoff will NOT be sent", "until", until)
+	}
+
+	// wake up the run loop, so that it picks up the new pause deadline
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// SetDecisionLog makes the batcher write a record of each send attempt to w as
+// newline-delimited JSON, replacing the previously set log. A nil w disables
+// the log.
+func (b *PreCommitBatcher) SetDecisionLog(w io.Writer) {
+	b.setDecisionLog(w, nil)
+}
+
+func (b *PreCommitBatcher) setDecisionLog(w io.Writer, c io.Closer) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if b.decisions != nil {
+		b.decisions.close()
+		b.decisions = nil
+	}
+
+	if w != nil {
+		b.decisions = newDecisionLog(w, c)
+	}
+}
+
+// UnsignedBatch is a precommit batch message assembled for external signing
+type UnsignedBatch struct {
+	Sectors []abi.SectorNumber
+
+	NetworkVersion network.Version
+
+	Message *types.Message
+}
+
+// AssembleUnsigned builds the batch messages which would be sent for the queued
+// sectors, without sending them, so that they can be signed and submitted
+// externally. Nonce and gas fields are estimated. The queue isn't changed.
+func (b *PreCommitBatcher) AssembleUnsigned(ctx context.Context) ([]UnsignedBatch, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if len(b.todo) == 0 {
+		return nil, nil
+	}
+
+	cfg, err := b.getConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("getting config: %w", err)
+	}
 
-	from, _, err := b.addrSel.AddressFor(b.mctx, b.api, mi, api.PreCommitAddr, goodFunds, deposit)
+	ts, err := b.api.ChainHead(ctx)
 	if err != nil {
-		return cid.Undef, xerrors.Errorf("no good address to send precommit message from: %w", err)
+		return nil, xerrors.Errorf("getting chain head: %w", err)
 	}
 
-	mcid, err := sendMsg(b.mctx, b.api, from, b.maddr, builtin.MethodsMiner.PreCommitSector, deposit, big.Int(b.feeCfg.MaxPreCommitGasFee), enc.Bytes())
+	nv, err := b.api.StateNetworkVersion(ctx, ts.Key())
 	if err != nil {
-		return cid.Undef, xerrors.Errorf("pushing message to mpool: %w", err)
+		return nil, xerrors.Errorf("couldn't get network version: %w", err)
 	}
 
-	return mcid, nil
-}
+	left := make(map[abi.SectorNumber]*preCommitEntry, len(b.todo))
+	for sn, p := range b.todo {
+		left[sn] = p
+	}
 
-func (b *PreCommitBatcher) processBatch(cfg sealiface.Config, tsk types.TipSetKey, bf abi.TokenAmount, nv network.Version) ([]sealiface.PreCommitBatchRes, error) {
-	params := miner.PreCommitSectorBatchParams{}
-	deposit := big.Zero()
-	var res sealiface.PreCommitBatchRes
+	nonces := map[address.Address]uint64{}
 
-	for _, p := range b.todo {
-		if len(params.Sectors) >= cfg.MaxPreCommitBatch {
-			log.Infow("precommit batch full")
-			break
+	var out []UnsignedBatch
+	for len(left) > 0 {
+		res, bm, err := b.assembleBatch(cfg, left, ts.Key(), ts.MinTicketBlock().ParentBaseFee, nv)
+		// invalid sectors can't go in any batch, they stay queued to fail on the next send
+		for sn := range res.FailedSectors {
+			delete(left, sn)
+		}
+		if err != nil {
+			if len(res.Sectors) == 0 && len(res.FailedSectors) > 0 {
+				continue
+			}
+			return nil, xerrors.Errorf("assembling batch: %w", err)
 		}
 
-		res.Sectors = append(res.Sectors, p.pci.SectorNumber)
-		params.Sectors = append(params.Sectors, *p.pci)
-		deposit = big.Add(deposit, p.deposit)
+		msg, err := b.api.GasEstimateMessageGas(ctx, &bm.msg, &api.MessageSendSpec{MaxFee: bm.maxFee}, ts.Key())
+		if err != nil {
+			return nil, xerrors.Errorf("estimating batch message gas: %w", err)
+		}
+
+		nonce, ok := nonces[msg.From]
+		if !ok {
+			nonce, err = b.api.MpoolGetNonce(ctx, msg.From)
+			if err != nil {
+				return nil, xerrors.Errorf("getting nonce for %s: %w", msg.From, err)
+			}
+		}
+		msg.Nonce = nonce
+		nonces[msg.From] = nonce + 1
+
+		out = append(out, UnsignedBatch{
+			Sectors:        res.Sectors,
+			NetworkVersion: nv,
+			Message:        msg,
+		})
+
+		for _, sn := range res.Sectors {
+			delete(left, sn)
+		}
 	}
 
-	enc := new(bytes.Buffer)
-	if err := params.MarshalCBOR(enc); err != nil {
-		return []sealiface.PreCommitBatchRes{res}, xerrors.Errorf("couldn't serialize PreCommitSectorBatchParams: %w", err)
+	return out, nil
+}
+
+// startupGraceRemaining returns how much of PreCommitBatchStartupGrace is left, 0
+// once it's over
+func (b *PreCommitBatcher) startupGraceRemaining(cfg sealiface.Config, now time.Time) time.Duration {
+	left := b.started.Add(cfg.PreCommitBatchStartupGrace).Sub(now)
+	if left < 0 {
+		return 0
 	}
+	return left
+}
 
-	mi, err := b.api.StateMinerInfo(b.mctx, b.maddr, types.EmptyTSK)
-	if err != nil {
-		return []sealiface.PreCommitBatchRes{res}, xerrors.Errorf("couldn't get miner info: %w", err)
+func (b *PreCommitBatcher) pauseRemaining() time.Duration {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	return b.clock.Until(b.pausedUntil)
+}
+
+// PendingDeposit returns the total deposit of queued precommits
+func (b *PreCommitBatcher) PendingDeposit() abi.TokenAmount {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	deposit := big.Zero()
+	for _, p := range b.todo {
+		deposit = big.Add(deposit, p.deposit)
 	}
+	return deposit
+}
 
-	maxFee := b.feeCfg.MaxPreCommitBatchGasFee.FeeForSectors(len(params.Sectors))
+// CheckQueuedFunds compares the deposit of queued precommits with the available
+// miner balance and funds of the address they would be sent from, returning the
+// part of the deposit which isn't covered
+func (b *PreCommitBatcher) CheckQueuedFunds(ctx context.Context) (abi.TokenAmount, error) {
+	deposit := b.PendingDeposit()
 
-	aggFeeRaw, err := policy.AggregatePreCommitNetworkFee(nv, len(params.Sectors), bf)
-	if err != nil {
-		log.Errorf("getting aggregate precommit network fee: %s", err)
-		return []sealiface.PreCommitBatchRes{res}, xerrors.Errorf("getting aggregate precommit network fee: %s", err)
+	shortfall := big.Zero()
+	if !deposit.IsZero() {
+		cfg, err := b.getConfig()
+		if err != nil {
+			return big.Zero(), xerrors.Errorf("getting config: %w", err)
+		}
+
+		ts, err := b.api.ChainHead(ctx)
+		if err != nil {
+			return big.Zero(), xerrors.Errorf("getting chain head: %w", err)
+		}
+
+		mi, err := b.api.StateMinerInfo(ctx, b.maddr, ts.Key())
+		if err != nil {
+			return big.Zero(), xerrors.Errorf("couldn't get miner info: %w", err)
+		}
+
+		need, err := collateralSendAmount(ctx, b.api, b.maddr, ts.Key(), cfg, deposit)
+		if err != nil {
+			return big.Zero(), err
+		}
+
+		_, avail, err := b.addrSel.AddressFor(ctx, b.api, mi, api.PreCommitAddr, need, need)
+		if err != nil {
+			return big.Zero(), xerrors.Errorf("no good address found: %w", err)
+		}
+
+		if need.GreaterThan(avail) {
+			shortfall = big.Sub(need, avail)
+			log.Errorw("deposits of queued precommits exceed available funds, sends will fail until funds are added",
+				"deposit", types.FIL(deposit), "fromWallet", types.FIL(need), "available", types.FIL(avail), "shortfall", types.FIL(shortfall))
+		}
 	}
 
-	aggFee := big.Div(big.Mul(aggFeeRaw, aggFeeNum), aggFeeDen)
+	stats.Record(b.mctx, metrics.PreCommitDepositShortfall.M(types.BigDivFloat(shortfall, types.NewInt(build.FilecoinPrecision))))
+
+	return shortfall, nil
+}
+
+// watchFunds runs CheckQueuedFunds every interval until the batcher is stopped
+func (b *PreCommitBatcher) watchFunds(interval time.Duration) {
+	ticker := b.clk().Ticker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := b.CheckQueuedFunds(b.mctx); err != nil {
+				log.Warnw("checking funds for queued precommits", "error", err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
 
-	needFunds := big.Add(deposit, aggFee)
-	needFunds, err = collateralSendAmount(b.mctx, b.api, b.maddr, cfg, needFunds)
+// RecomputeCutoffs recomputes cutoffs of all queued sectors from the current chain
+// head, e.g. after a deep reorg, and reschedules the next send accordingly.
+// Sectors whose cutoff can't be computed are sent with the next send attempt.
+func (b *PreCommitBatcher) RecomputeCutoffs(ctx context.Context) error {
+	ts, err := b.api.ChainHead(ctx)
 	if err != nil {
-		return []sealiface.PreCommitBatchRes{res}, err
+		return xerrors.Errorf("getting chain head: %w", err)
 	}
 
-	goodFunds := big.Add(maxFee, needFunds)
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	for sn, p := range b.todo {
+		cutoffEpoch, err := b.cutoffStrategy.PreCommitCutoff(ts.Height(), p.si)
+		if err != nil {
+			// the other sectors are still recomputed, this one is sent with the next
+			// send attempt rather than waiting on a stale cutoff
+			log.Errorw("computing precommit cutoff, sending the sector right away", "sector", sn, "height", ts.Height(), "error", err)
+			if cutoffEpoch == 0 || cutoffEpoch > ts.Height() {
+				cutoffEpoch = ts.Height()
+			}
+		}
+		cutoff := b.cutoffTime(ts.Height(), cutoffEpoch)
+
+		if p.cutoffEpoch != cutoffEpoch || !b.cutoffs[sn].Equal(cutoff) {
+			log.Infow("recomputed precommit cutoff", "sector", sn, "height", ts.Height(),
+				"cutoffEpoch", cutoffEpoch, "oldCutoffEpoch", p.cutoffEpoch, "cutoff", cutoff, "oldCutoff", b.cutoffs[sn])
+		}
 
-	from, _, err := b.addrSel.AddressFor(b.mctx, b.api, mi, api.PreCommitAddr, goodFunds, deposit)
-	if err != nil {
-		return []sealiface.PreCommitBatchRes{res}, xerrors.Errorf("no good address found: %w", err)
+		p.cutoffEpoch = cutoffEpoch
+		b.cutoffs[sn] = cutoff
 	}
 
-	mcid, err := sendMsg(b.mctx, b.api, from, b.maddr, builtin.MethodsMiner.PreCommitSectorBatch, needFunds, maxFee, enc.Bytes())
-	if err != nil {
-		return []sealiface.PreCommitBatchRes{res}, xerrors.Errorf("sending message failed: %w", err)
+	// wake up the run loop, so that it reschedules the next send
+	select {
+	case b.notify <- struct{}{}:
+	default:
 	}
 
-	res.Msg = &mcid
+	return nil
+}
 
-	log.Infow("Sent PreCommitSectorBatch message", "cid", mcid, "from", from, "sectors", len(b.todo))
+// BatchStats returns the send counters of the batcher
+func (b *PreCommitBatcher) BatchStats() sealiface.PreCommitBatchStats {
+	b.lk.Lock()
+	defer b.lk.Unlock()
 
-	return []sealiface.PreCommitBatchRes{res}, nil
+	return b.stats
 }
 
-// register PreCommit, wait for batch message, return message CID
-func (b *PreCommitBatcher) AddPreCommit(ctx context.Context, s SectorInfo, deposit abi.TokenAmount, in *miner.SectorPreCommitInfo) (res sealiface.PreCommitBatchRes, err error) {
-	ts, err := b.api.ChainHead(b.mctx)
-	if err != nil {
-		log.Errorf("getting chain head: %s", err)
-		return sealiface.PreCommitBatchRes{}, err
+// QueueAges returns how many queued sectors have been waiting for how long
+func (b *PreCommitBatcher) QueueAges() sealiface.QueueAges {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	return b.queueAgesLocked(b.clock.Now())
+}
+
+func (b *PreCommitBatcher) queueAgesLocked(now time.Time) sealiface.QueueAges {
+	var ages sealiface.QueueAges
+	for _, p := range b.todo {
+		switch age := now.Sub(p.queued); {
+		case age < time.Minute:
+			ages.Under1m++
+		case age < 5*time.Minute:
+			ages.Under5m++
+		case age < 30*time.Minute:
+			ages.Under30m++
+		default:
+			ages.Over30m++
+		}
+	}
+
+	return ages
+}
+
+// ResetStats zeroes the since-reset counters, cumulative counters are kept
+func (b *PreCommitBatcher) ResetStats() {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.stats.SinceReset = sealiface.PreCommitBatchCounters{MaxFees: big.Zero()}
+	b.stats.LastReset = b.clock.Now()
+}
+
+// SetFundsCoordinator makes the batcher reserve funds with the given coordinator
+// before sending messages
+func (b *PreCommitBatcher) SetFundsCoordinator(fc FundsCoordinator) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.funds = fc
+}
+
+// SubmittedFunc is called with the result of each precommit message once it was
+// sent, batched or individual, after its sectors left the queue. It's called from
+// the run loop without the batcher lock held, so it should return quickly.
+type SubmittedFunc func(sealiface.PreCommitBatchRes)
+
+// notifySubmitted calls the submit callback, if set, with each result. Must be
+// called without b.lk held.
+func (b *PreCommitBatcher) notifySubmitted(res []sealiface.PreCommitBatchRes) {
+	if b.onSubmit == nil {
+		return
 	}
 
-	cutoff, err := getPreCommitCutoff(ts.Height(), s)
+	for _, r := range res {
+		b.onSubmit(r)
+	}
+}
+
+// SendFunc sends a message, returning its CID. A zero gas limit means that gas
+// should be estimated. Implementations should set the nonce the message was sent
+// with in msg, it's reported in the batch results.
+type SendFunc func(ctx context.Context, msg *types.Message, maxFee abi.TokenAmount) (cid.Cid, error)
+
+// mpoolSend is the default SendFunc, pushing messages to the mpool
+func (b *PreCommitBatcher) mpoolSend(ctx context.Context, msg *types.Message, maxFee abi.TokenAmount) (cid.Cid, error) {
+	smsg, err := b.api.MpoolPushMessage(ctx, msg, &api.MessageSendSpec{MaxFee: maxFee})
 	if err != nil {
-		return sealiface.PreCommitBatchRes{}, xerrors.Errorf("failed to calculate cutoff: %w", err)
+		return cid.Undef, err
 	}
 
-	sn := s.SectorNumber
+	msg.Nonce = smsg.Message.Nonce
+
+	return smsg.Cid(), nil
+}
 
+// SetSendFunc makes the batcher send precommit messages with the given function
+// instead of pushing them to the mpool, e.g. to add validation or logging. A nil
+// function restores the default.
+func (b *PreCommitBatcher) SetSendFunc(send SendFunc) {
 	b.lk.Lock()
-	b.cutoffs[sn] = cutoff
-	b.todo[sn] = &preCommitEntry{
-		deposit: deposit,
-		pci:     in,
+	defer b.lk.Unlock()
+
+	if send == nil {
+		send = b.mpoolSend
 	}
+	b.send = send
+}
 
-	sent := make(chan sealiface.PreCommitBatchRes, 1)
-	b.waiting[sn] = append(b.waiting[sn], sent)
+// SetBatchMethodStrategy makes the batcher send batches with the method and params
+// selected by the given strategy. A nil strategy restores the default.
+func (b *PreCommitBatcher) SetBatchMethodStrategy(ms BatchMethodStrategy) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
 
-	select {
-	case b.notify <- struct{}{}:
-	default: // already have a pending notification, don't need more
+	if ms == nil {
+		ms = DefaultBatchMethodStrategy{}
 	}
-	b.lk.Unlock()
+	b.methodStrategy = ms
+}
 
-	select {
-	case c := <-sent:
-		return c, nil
-	case <-ctx.Done():
-		return sealiface.PreCommitBatchRes{}, ctx.Err()
+// CutoffMargin returns the number of epochs which were left until the precommit
+// cutoff of the sector when it was queued, for queued and recently sent sectors.
+// Returns 0 for other sectors.
+func (b *PreCommitBatcher) CutoffMargin(sn abi.SectorNumber) abi.ChainEpoch {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if p, ok := b.todo[sn]; ok {
+		return p.margin
 	}
+
+	return b.sent[sn].margin
 }
 
-func (b *PreCommitBatcher) Flush(ctx context.Context) ([]sealiface.PreCommitBatchRes, error) {
-	resCh := make(chan []sealiface.PreCommitBatchRes, 1)
-	select {
-	case b.force <- resCh:
-		select {
-		case res := <-resCh:
-			return res, nil
-		case <-ctx.Done():
-			return nil, ctx.Err()
+// confirm applies PreCommitConfirmStrategy to a message found on chain. With the
+// "wait" strategy it waits for the message to get MessageConfidence confirmations.
+func (b *PreCommitBatcher) confirm(ctx context.Context, found *api.MsgLookup) (*api.MsgLookup, error) {
+	cfg, err := b.getConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("getting config: %w", err)
+	}
+
+	switch cfg.PreCommitConfirmStrategy {
+	case "", "search":
+		return found, nil
+	case "wait":
+		// the message may have been replaced, wait for the one which landed
+		ml, err := b.api.StateWaitMsg(ctx, found.Message, build.MessageConfidence, api.LookbackNoLimit, true)
+		if err != nil {
+			return nil, xerrors.Errorf("waiting for message confirmations: %w", err)
 		}
-	case <-ctx.Done():
-		return nil, ctx.Err()
+		return ml, nil
+	default:
+		return nil, xerrors.Errorf("unknown confirm strategy %q", cfg.PreCommitConfirmStrategy)
+	}
+}
+
+// checkConfirmTimeout returns ErrConfirmTimeout, and forgets the sent message,
+// when it was sent more than PreCommitBatchConfirmTimeoutEpochs ago
+func (b *PreCommitBatcher) checkConfirmTimeout(ctx context.Context, sn abi.SectorNumber, sp sentPreCommit) error {
+	cfg, err := b.getConfig()
+	if err != nil {
+		return xerrors.Errorf("getting config: %w", err)
+	}
+
+	if cfg.PreCommitBatchConfirmTimeoutEpochs == 0 {
+		return nil
+	}
+
+	ts, err := b.api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
 	}
+
+	if ts.Height()-sp.height <= abi.ChainEpoch(cfg.PreCommitBatchConfirmTimeoutEpochs) {
+		return nil
+	}
+
+	log.Warnw("precommit message didn't land on chain in time", "sector", sn, "cid", sp.msg, "nonce", sp.nonce, "sentAt", sp.height, "height", ts.Height())
+
+	b.lk.Lock()
+	if cur, ok := b.sent[sn]; ok && cur.msg == sp.msg {
+		delete(b.sent, sn)
+	}
+	b.lk.Unlock()
+
+	return xerrors.Errorf("sector %d message %s sent at %d, head at %d: %w", sn, sp.msg, sp.height, ts.Height(), ErrConfirmTimeout)
 }
 
 func (b *PreCommitBatcher) Pending(ctx context.Context) ([]abi.SectorID, error) {
@@ -459,8 +3046,72 @@ func (b *PreCommitBatcher) Pending(ctx context.Context) ([]abi.SectorID, error)
 	return res, nil
 }
 
+// PendingDetailed returns the queued precommits with their deposit and cutoff,
+// for diagnosing why a batch wasn't sent yet
+func (b *PreCommitBatcher) PendingDetailed(ctx context.Context) ([]sealiface.PreCommitPending, error) {
+	cfg, err := b.getConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("getting config: %w", err)
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	now := b.clock.Now()
+
+	res := make([]sealiface.PreCommitPending, 0, len(b.todo))
+	for sn, p := range b.todo {
+		pp := sealiface.PreCommitPending{
+			Sector:      sn,
+			Deposit:     p.deposit,
+			CutoffEpoch: p.cutoffEpoch,
+		}
+		if cutoff, ok := b.cutoffs[sn]; ok && !cutoff.IsZero() {
+			pp.UntilCutoff = cutoff.Add(-cfg.PreCommitBatchSlack).Sub(now)
+		}
+
+		res = append(res, pp)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Sector < res[j].Sector
+	})
+
+	return res, nil
+}
+
+// RemovePreCommit takes a queued sector out of the queue, so that it isn't sent.
+// Callers waiting on the sector get a result with ErrPreCommitRemoved. Returns an
+// error when the sector isn't queued, e.g. because it was already sent.
+func (b *PreCommitBatcher) RemovePreCommit(ctx context.Context, sn abi.SectorNumber) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if _, ok := b.todo[sn]; !ok {
+		return xerrors.Errorf("sector %d precommit isn't queued", sn)
+	}
+
+	log.Infow("removing precommit from the queue", "sector", sn, "waiters", len(b.waiting[sn]))
+
+	r := sealiface.PreCommitBatchRes{
+		Sectors: []abi.SectorNumber{sn},
+		Error:   ErrPreCommitRemoved.Error(),
+	}
+	for _, ch := range b.waiting[sn] {
+		ch <- r // buffered
+	}
+
+	delete(b.waiting, sn)
+	b.dequeueLocked(sn)
+	delete(b.cutoffs, sn)
+
+	return nil
+}
+
 func (b *PreCommitBatcher) Stop(ctx context.Context) error {
-	close(b.stop)
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
 
 	select {
 	case <-b.stopped:
@@ -470,8 +3121,46 @@ func (b *PreCommitBatcher) Stop(ctx context.Context) error {
 	}
 }
 
-// TODO: If this returned epochs, it would make testing much easier
-func getPreCommitCutoff(curEpoch abi.ChainEpoch, si SectorInfo) (time.Time, error) {
+// CutoffStrategy computes the epoch by which the precommit of a sector has to be
+// sent. Returns an error when the cutoff is at or before curEpoch.
+type CutoffStrategy interface {
+	PreCommitCutoff(curEpoch abi.ChainEpoch, si SectorInfo) (abi.ChainEpoch, error)
+}
+
+// DefaultCutoffStrategy cuts off at the earlier of seal ticket expiration and
+// the start of the earliest deal in the sector
+type DefaultCutoffStrategy struct{}
+
+func (DefaultCutoffStrategy) PreCommitCutoff(curEpoch abi.ChainEpoch, si SectorInfo) (abi.ChainEpoch, error) {
+	return getPreCommitCutoff(curEpoch, si)
+}
+
+var _ CutoffStrategy = DefaultCutoffStrategy{}
+
+// BatchMethodStrategy selects the miner actor method used to precommit a batch of
+// sectors, and encodes its params. This allows sending batches to actors with
+// alternative precommit methods, e.g. on devnets.
+type BatchMethodStrategy interface {
+	BatchMethod(cfg sealiface.Config, nv network.Version, sectors []miner.SectorPreCommitInfo) (abi.MethodNum, []byte, error)
+}
+
+// DefaultBatchMethodStrategy sends batches with PreCommitSectorBatch
+type DefaultBatchMethodStrategy struct{}
+
+func (DefaultBatchMethodStrategy) BatchMethod(cfg sealiface.Config, nv network.Version, sectors []miner.SectorPreCommitInfo) (abi.MethodNum, []byte, error) {
+	params := miner.PreCommitSectorBatchParams{Sectors: sectors}
+
+	enc := new(bytes.Buffer)
+	if err := params.MarshalCBOR(enc); err != nil {
+		return 0, nil, xerrors.Errorf("couldn't serialize PreCommitSectorBatchParams: %w", err)
+	}
+
+	return builtin.MethodsMiner.PreCommitSectorBatch, enc.Bytes(), nil
+}
+
+var _ BatchMethodStrategy = DefaultBatchMethodStrategy{}
+
+func getPreCommitCutoff(curEpoch abi.ChainEpoch, si SectorInfo) (abi.ChainEpoch, error) {
 	cutoffEpoch := si.TicketEpoch + policy.MaxPreCommitRandomnessLookback
 	for _, p := range si.Pieces {
 		if p.DealInfo == nil {
@@ -485,8 +3174,34 @@ func getPreCommitCutoff(curEpoch abi.ChainEpoch, si SectorInfo) (time.Time, erro
 	}
 
 	if cutoffEpoch <= curEpoch {
-		return time.Now(), xerrors.Errorf("cutoff has already passed (cutoff %d <= curEpoch %d)", cutoffEpoch, curEpoch)
+		return cutoffEpoch, xerrors.Errorf("cutoff has already passed (cutoff %d <= curEpoch %d)", cutoffEpoch, curEpoch)
+	}
+
+	return cutoffEpoch, nil
+}
+
+// cutoffTime converts a cutoff epoch to the wall clock time at which it's
+// expected to be reached, with the head at curEpoch
+func (b *PreCommitBatcher) cutoffTime(curEpoch, cutoffEpoch abi.ChainEpoch) time.Time {
+	return b.clock.Now().Add(time.Duration(cutoffEpoch-curEpoch) * time.Duration(build.BlockDelaySecs) * time.Second)
+}
+
+// SetClock makes the batcher read the time from c for all cutoffs, waits and
+// timestamps, e.g. to use a mock clock in tests. A nil clock restores the default.
+func (b *PreCommitBatcher) SetClock(c clock.Clock) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if c == nil {
+		c = build.Clock
 	}
+	b.clock = c
+}
+
+// clk returns the clock, for use without b.lk held
+func (b *PreCommitBatcher) clk() clock.Clock {
+	b.lk.Lock()
+	defer b.lk.Unlock()
 
-	return time.Now().Add(time.Duration(cutoffEpoch-curEpoch) * time.Duration(build.BlockDelaySecs) * time.Second), nil
+	return b.clock
 }
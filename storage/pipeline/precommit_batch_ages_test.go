@@ -0,0 +1,36 @@
+package sealing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+func TestQueueAges(t *testing.T) {
+	now := time.Now()
+
+	b := &PreCommitBatcher{todo: map[abi.SectorNumber]*preCommitEntry{}}
+	for sn, age := range []time.Duration{
+		10 * time.Second,
+		59 * time.Second,
+		time.Minute, // bucket bounds are exclusive
+		4 * time.Minute,
+		29 * time.Minute,
+		30 * time.Minute,
+		3 * time.Hour,
+	} {
+		b.todo[abi.SectorNumber(sn)] = &preCommitEntry{queued: now.Add(-age)}
+	}
+
+	require.Equal(t, sealiface.QueueAges{
+		Under1m:  2,
+		Under5m:  2,
+		Under30m: 1,
+		Over30m:  2,
+	}, b.queueAgesLocked(now))
+}
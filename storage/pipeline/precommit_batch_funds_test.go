@@ -0,0 +1,70 @@
+package sealing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/node/config"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+func TestBatchFundsFeeBuffer(t *testing.T) {
+	b := &PreCommitBatcher{
+		feeCfg: config.MinerFeeConfig{
+			MaxPreCommitBatchGasFee: config.BatchFeeConfig{
+				Base:      types.FIL(big.Zero()),
+				PerSector: types.FIL(types.FromFil(1)),
+			},
+		},
+	}
+
+	cfg := sealiface.Config{
+		PreCommitBatchFeeBufferByVersion: map[network.Version]uint64{
+			network.Version16: 200,
+		},
+	}
+
+	bf := big.NewInt(1_000_000_000)
+
+	for _, tc := range []struct {
+		nv       network.Version
+		num, den int64
+	}{
+		{nv: network.Version16, num: 200, den: 100}, // overridden
+		{nv: network.Version15, num: 110, den: 100}, // default
+	} {
+		raw, err := policy.AggregatePreCommitNetworkFee(tc.nv, 10, bf)
+		require.NoError(t, err)
+		require.True(t, raw.GreaterThan(big.Zero()))
+
+		needFunds, _, _, aggFee, err := b.batchFunds(cfg, 10, abi.NewTokenAmount(0), types.EmptyTSK, bf, tc.nv)
+		require.NoError(t, err)
+
+		want := big.Div(big.Mul(raw, big.NewInt(tc.num)), big.NewInt(tc.den))
+		require.True(t, want.Equals(aggFee), "nv%d: want %s, got %s", tc.nv, want, aggFee)
+		require.True(t, want.Equals(needFunds))
+	}
+}
+
+func TestCostPerSector(t *testing.T) {
+	r := sealiface.PreCommitBatchRes{
+		Sectors:      []abi.SectorNumber{1, 2, 3, 4},
+		MaxFee:       abi.NewTokenAmount(1000),
+		AggregateFee: abi.NewTokenAmount(203),
+	}
+	require.True(t, abi.NewTokenAmount(300).Equals(costPerSector(r))) // rounded down
+
+	// individual messages have no aggregate fee
+	r = sealiface.PreCommitBatchRes{
+		Sectors: []abi.SectorNumber{1},
+		MaxFee:  abi.NewTokenAmount(1000),
+	}
+	require.True(t, abi.NewTokenAmount(1000).Equals(costPerSector(r)))
+}
@@ -4,22 +4,36 @@ package sealing_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/ipfs/go-cid"
+	"github.com/raulk/clock"
 	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v8/market"
 	minertypes "github.com/filecoin-project/go-state-types/builtin/v8/miner"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
 	"github.com/filecoin-project/go-state-types/network"
 	miner6 "github.com/filecoin-project/specs-actors/v6/actors/builtin/miner"
 
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/node/config"
 	pipeline "github.com/filecoin-project/lotus/storage/pipeline"
@@ -39,10 +53,22 @@ func TestPrecommitBatcher(t *testing.T) {
 	//stm: @CHAIN_STATE_MINER_CALCULATE_DEADLINE_001
 	t0123, err := address.NewFromString("t0123")
 	require.NoError(t, err)
+	t01001, err := address.NewFromString("t01001")
+	require.NoError(t, err)
+	t01002, err := address.NewFromString("t01002")
+	require.NoError(t, err)
 
 	ctx := context.Background()
 
+	// funds requested from the address selector in the last call
+	var selLk sync.Mutex
+	var selGoodFunds, selMinFunds abi.TokenAmount
+
 	as := asel(func(ctx context.Context, mi api.MinerInfo, use api.AddrUse, goodFunds, minFunds abi.TokenAmount) (address.Address, abi.TokenAmount, error) {
+		selLk.Lock()
+		selGoodFunds, selMinFunds = goodFunds, minFunds
+		selLk.Unlock()
+
 		return t0123, big.Zero(), nil
 	})
 
@@ -74,6 +100,255 @@ func TestPrecommitBatcher(t *testing.T) {
 		}, nil
 	}
 
+	aboveActorLimitCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.MaxPreCommitBatch = maxBatch + 1
+		return c, err
+	}
+
+	sendRetryCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchSendRetries = 2
+		c.PreCommitBatchSendRetryBackoff = time.Millisecond
+		return c, err
+	}
+
+	slowRetryCfg := func() (sealiface.Config, error) {
+		c, err := sendRetryCfg()
+		c.PreCommitBatchSendRetryBackoff = 200 * time.Millisecond
+		return c, err
+	}
+
+	// PreCommitBatchWait of reconfigurableCfg, set by reconfigure
+	reconfigurableWait := int64(24 * time.Hour)
+	reconfigurableCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchWait = time.Duration(atomic.LoadInt64(&reconfigurableWait))
+		return c, err
+	}
+
+	hybridCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchSendUrgentIndividually = true
+		return c, err
+	}
+
+	// with the chain lag check enabled, automatic sends are deferred while the head
+	// from expectLaggingChainAnyTimes is an hour old, so sectors can queue up above
+	// MaxPreCommitBatch
+	laggingCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.MaxPreCommitBatch = 2
+		c.PreCommitBatchMaxChainLag = 10
+		return c, err
+	}
+
+	agingCfg := func() (sealiface.Config, error) {
+		c, err := laggingCfg()
+		c.PreCommitBatchMaxSectorWait = 100 * time.Millisecond
+		return c, err
+	}
+
+	urgentDealAloneCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchSendUrgentDealAlone = true
+		return c, err
+	}
+
+	graceCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchStartupGrace = time.Hour
+		return c, err
+	}
+
+	graceRespectedCfg := func() (sealiface.Config, error) {
+		c, err := graceCfg()
+		c.PreCommitBatchFlushRespectsGrace = true
+		return c, err
+	}
+
+	flushBatchesCfg := func() (sealiface.Config, error) {
+		c, err := laggingCfg()
+		c.PreCommitBatchMaxBatchesPerFlush = 2
+		return c, err
+	}
+
+	// batches may use half of the block gas limit
+	gasCeilingCfg := func() (sealiface.Config, error) {
+		c, err := laggingCfg()
+		c.MaxPreCommitBatch = 10
+		c.PreCommitBatchMaxGasFraction = 0.5
+		return c, err
+	}
+
+	minBatchCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.MinPreCommitBatch = 3
+		c.PreCommitBatchWait = 50 * time.Millisecond
+		return c, err
+	}
+
+	smallBatchCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.MaxPreCommitBatch = 2
+		return c, err
+	}
+
+	roundRobinCfg := func() (sealiface.Config, error) {
+		c, err := smallBatchCfg()
+		c.PreCommitRoundRobinAddresses = true
+		return c, err
+	}
+
+	collateralSourceCfg := func() (sealiface.Config, error) {
+		c, err := laggingCfg()
+		c.PreCommitCollateralSources = []string{t01001.String()}
+		return c, err
+	}
+
+	mergeFlushCfg := func() (sealiface.Config, error) {
+		c, err := laggingCfg()
+		c.PreCommitBatchFlushDuringSend = "merge"
+		return c, err
+	}
+
+	waiterCapCfg := func() (sealiface.Config, error) {
+		c, err := laggingCfg()
+		c.PreCommitBatchMaxWaitersPerSector = 3
+		return c, err
+	}
+
+	mpoolCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchCheckMpool = true
+		return c, err
+	}
+
+	feeCoverageCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitAddressPreferFeeCoverage = true
+		return c, err
+	}
+
+	economicCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.EconomicBatchDecision = true
+		return c, err
+	}
+
+	verifyBalanceCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchVerifySenderBalance = true
+		return c, err
+	}
+
+	diagnoseCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.DiagnoseBatchReverts = true
+		return c, err
+	}
+
+	collateralCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.CollateralFromMinerBalance = true
+		c.AvailableBalanceBuffer = big.Zero()
+		return c, err
+	}
+
+	boundedQueueCfg := func() (sealiface.Config, error) {
+		c, err := laggingCfg()
+		c.PreCommitBatchMaxQueue = 2
+		c.PreCommitBatchEvictOnFullQueue = true
+		return c, err
+	}
+
+	blockingQueueCfg := func() (sealiface.Config, error) {
+		c, err := laggingCfg()
+		c.PreCommitBatchMaxQueue = 1
+		c.PreCommitBatchBlockOnFullQueue = true
+		return c, err
+	}
+
+	deadlineGroupCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchGroupByDeadlineHint = true
+		return c, err
+	}
+
+	// full batches are sent right away, but only in epochs [start, start+10) of every 100
+	sendWindowCfg := func(start uint64) func() (sealiface.Config, error) {
+		return func() (sealiface.Config, error) {
+			c, err := cfg()
+			c.MaxPreCommitBatch = 1
+			c.PreCommitBatchSendWindowPeriod = 100
+			c.PreCommitBatchSendWindowStart = start
+			c.PreCommitBatchSendWindowLength = 10
+			return c, err
+		}
+	}
+
+	upgradeGuardCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.MaxPreCommitBatch = 1
+		c.PreCommitBatchUpgradeGuardEpochs = 10
+		return c, err
+	}
+
+	emergencyCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchEmergencySendAlert = 2
+		c.PreCommitBatchAutoTuneWait = true
+		return c, err
+	}
+
+	minDepositCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchMinDepositPer32GiB = types.FromFil(1)
+		c.PreCommitBatchRejectLowDeposit = true
+		return c, err
+	}
+
+	allocationCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchCheckAllocation = true
+		return c, err
+	}
+
+	manualCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchManualSendMode = true
+		c.PreCommitBatchWait = 10 * time.Millisecond
+		return c, err
+	}
+
+	confirmTimeoutCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitBatchConfirmTimeoutEpochs = 10
+		return c, err
+	}
+
+	confirmSearchCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitConfirmStrategy = "search"
+		return c, err
+	}
+
+	confirmWaitCfg := func() (sealiface.Config, error) {
+		c, err := cfg()
+		c.PreCommitConfirmStrategy = "wait"
+		return c, err
+	}
+
+	// fails the first read, as can happen when the config file is being rewritten
+	var flakyCfgCalls int
+	flakyCfg := func() (sealiface.Config, error) {
+		flakyCfgCalls++
+		if flakyCfgCalls == 1 {
+			return sealiface.Config{}, xerrors.Errorf("config temporarily unavailable")
+		}
+		return cfg()
+	}
+
 	type promise func(t *testing.T)
 	type action func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise
 
@@ -98,32 +373,27 @@ func TestPrecommitBatcher(t *testing.T) {
 		}
 	}
 
-	addSector := func(sn abi.SectorNumber, aboveBalancer bool) action {
+	// queueSector adds a sector without setting up any api expectations
+	queueSectorWith := func(si pipeline.SectorInfo, deposit abi.TokenAmount, dlHint *uint64) action {
 		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
 			var pcres sealiface.PreCommitBatchRes
 			var pcerr error
 			done := sync.Mutex{}
 			done.Lock()
 
-			si := pipeline.SectorInfo{
-				SectorNumber: sn,
-			}
-
-			basefee := big.NewInt(9999)
-			if aboveBalancer {
-				basefee = big.NewInt(10001)
-			}
-
-			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, basefee, 1), nil)
-
 			go func() {
 				defer done.Unlock()
-				pcres, pcerr = pcb.AddPreCommit(ctx, si, big.Zero(), &minertypes.SectorPreCommitInfo{
+				pci := &minertypes.SectorPreCommitInfo{
 					SectorNumber: si.SectorNumber,
 					SealedCID:    fakePieceCid(t),
 					DealIDs:      nil,
-					Expiration:   0,
-				})
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				}
+				if dlHint != nil {
+					pcres, pcerr = pcb.AddPreCommitWithDeadlineHint(ctx, si, deposit, pci, *dlHint)
+				} else {
+					pcres, pcerr = pcb.AddPreCommit(ctx, si, deposit, pci)
+				}
 			}()
 
 			return func(t *testing.T) {
@@ -135,156 +405,3511 @@ func TestPrecommitBatcher(t *testing.T) {
 		}
 	}
 
-	addSectors := func(sectors []abi.SectorNumber, aboveBalancer bool) action {
-		as := make([]action, len(sectors))
-		for i, sector := range sectors {
-			as[i] = addSector(sector, aboveBalancer)
-		}
-		return actions(as...)
+	queueSectorInfo := func(si pipeline.SectorInfo) action {
+		return queueSectorWith(si, big.Zero(), nil)
 	}
 
-	waitPending := func(n int) action {
+	queueSectorHint := func(sn abi.SectorNumber, dlIdx uint64) action {
+		return queueSectorWith(pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &dlIdx)
+	}
+
+	queueSectorDeposit := func(sn abi.SectorNumber, deposit abi.TokenAmount) action {
+		return queueSectorWith(pipeline.SectorInfo{SectorNumber: sn}, deposit, nil)
+	}
+
+	queueSector := func(sn abi.SectorNumber, ticketEpoch abi.ChainEpoch) action {
+		return queueSectorInfo(pipeline.SectorInfo{
+			SectorNumber: sn,
+			TicketEpoch:  ticketEpoch,
+		})
+	}
+
+	// queues a sector with a single deal paying the given price for 1000 epochs
+	queueDealSector := func(sn abi.SectorNumber, pricePerEpoch int64) action {
+		return queueSectorInfo(pipeline.SectorInfo{
+			SectorNumber: sn,
+			Pieces: []pipeline.Piece{{
+				DealInfo: &api.PieceDealInfo{
+					DealProposal: &market.DealProposal{
+						StartEpoch:           1000,
+						EndEpoch:             2000,
+						StoragePricePerEpoch: big.NewInt(pricePerEpoch),
+					},
+					DealSchedule: api.DealSchedule{
+						StartEpoch: 1000,
+						EndEpoch:   2000,
+					},
+				},
+			}},
+		})
+	}
+
+	queueSectorEvicted := func(sn abi.SectorNumber, ticketEpoch abi.ChainEpoch) action {
 		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
-			require.Eventually(t, func() bool {
-				p, err := pcb.Pending(ctx)
-				require.NoError(t, err)
-				return len(p) == n
-			}, time.Second*5, 10*time.Millisecond)
+			resCh := make(chan sealiface.PreCommitBatchRes, 1)
+			errCh := make(chan error, 1)
+			go func() {
+				res, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn, TicketEpoch: ticketEpoch}, big.Zero(), &minertypes.SectorPreCommitInfo{
+					SectorNumber: sn,
+					SealedCID:    fakePieceCid(t),
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				})
+				resCh <- res
+				errCh <- err
+			}()
 
+			return func(t *testing.T) {
+				res := <-resCh
+				require.NoError(t, <-errCh)
+				require.Nil(t, res.Msg)
+				require.Equal(t, []abi.SectorNumber{sn}, res.Sectors)
+				require.Contains(t, res.Error, pipeline.ErrQueueFull.Error())
+			}
+		}
+	}
+
+	addSectorQueueFull := func(sn abi.SectorNumber, ticketEpoch abi.ChainEpoch) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			_, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn, TicketEpoch: ticketEpoch}, big.Zero(), &minertypes.SectorPreCommitInfo{
+				SectorNumber: sn,
+				SealedCID:    fakePieceCid(t),
+				Expiration:   policy.GetMaxSectorExpirationExtension(),
+			})
+			require.ErrorIs(t, err, pipeline.ErrQueueFull)
 			return nil
 		}
 	}
 
-	//stm: @CHAIN_STATE_MINER_INFO_001, @CHAIN_STATE_NETWORK_VERSION_001
-	expectSend := func(expect []abi.SectorNumber) action {
+	// adds the same sector n times concurrently, expecting the adds above the waiter
+	// cap to fail, and the others to get the send result
+	addSectorWaiters := func(sn abi.SectorNumber, n, limit int) action {
 		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
-			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
-			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			errCh := make(chan error, n)
+			for i := 0; i < n; i++ {
+				go func() {
+					_, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+						SectorNumber: sn,
+						SealedCID:    fakePieceCid(t),
+						Expiration:   policy.GetMaxSectorExpirationExtension(),
+					})
+					errCh <- err
+				}()
+			}
 
-			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
-			s.EXPECT().MpoolPushMessage(gomock.Any(), funMatcher(func(i interface{}) bool {
-				b := i.(*types.Message)
-				var params miner6.PreCommitSectorBatchParams
-				require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(b.Params)))
-				for s, number := range expect {
-					require.Equal(t, number, params.Sectors[s].SectorNumber)
+			for i := 0; i < n-limit; i++ {
+				select {
+				case err := <-errCh:
+					require.ErrorIs(t, err, pipeline.ErrTooManyWaiters)
+				case <-time.After(5 * time.Second):
+					t.Fatal("adds above the waiter cap weren't rejected")
 				}
-				return true
-			}), gomock.Any()).Return(dummySmsg, nil)
-			return nil
+			}
+
+			return func(t *testing.T) {
+				for i := 0; i < limit; i++ {
+					require.NoError(t, <-errCh)
+				}
+			}
 		}
 	}
 
-	//stm: @CHAIN_STATE_MINER_INFO_001, @CHAIN_STATE_NETWORK_VERSION_001
-	expectSendsSingle := func(expect []abi.SectorNumber) action {
+	// adds a sector expecting it to be sent right away, forced by its cutoff
+	addSectorCutoffForced := func(sn abi.SectorNumber, cutoffEpoch abi.ChainEpoch) action {
 		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
-			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(9999), 1), nil)
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
 			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
 
-			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
-			for _, number := range expect {
-				numClone := number
-				s.EXPECT().MpoolPushMessage(gomock.Any(), funMatcher(func(i interface{}) bool {
-					b := i.(*types.Message)
-					var params miner6.PreCommitSectorParams
-					require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(b.Params)))
-					require.Equal(t, numClone, params.SectorNumber)
-					return true
-				}), gomock.Any()).Return(dummySmsg, nil)
+			resCh := make(chan sealiface.PreCommitBatchRes, 1)
+			errCh := make(chan error, 1)
+			go func() {
+				res, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+					SectorNumber: sn,
+					SealedCID:    fakePieceCid(t),
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				})
+				resCh <- res
+				errCh <- err
+			}()
+
+			return func(t *testing.T) {
+				res := <-resCh
+				require.NoError(t, <-errCh)
+				require.Empty(t, res.Error)
+				require.Equal(t, cutoffEpoch, res.ForcedByCutoff)
 			}
-			return nil
 		}
 	}
 
-	flush := func(expect []abi.SectorNumber) action {
+	addSectorExpectStopped := func(sn abi.SectorNumber) action {
 		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
-			_ = expectSend(expect)(t, s, pcb)
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
 
-			r, err := pcb.Flush(ctx)
-			require.NoError(t, err)
-			require.Len(t, r, 1)
-			require.Empty(t, r[0].Error)
-			sort.Slice(r[0].Sectors, func(i, j int) bool {
-				return r[0].Sectors[i] < r[0].Sectors[j]
-			})
-			require.Equal(t, expect, r[0].Sectors)
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+					SectorNumber: sn,
+					SealedCID:    fakePieceCid(t),
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				})
+				errCh <- err
+			}()
 
-			return nil
+			return func(t *testing.T) {
+				select {
+				case err := <-errCh:
+					require.ErrorIs(t, err, pipeline.ErrBatcherStopped)
+				case <-time.After(5 * time.Second):
+					t.Fatal("waiter wasn't unblocked by Stop")
+				}
+			}
 		}
 	}
 
-	getSectors := func(n int) []abi.SectorNumber {
-		out := make([]abi.SectorNumber, n)
-		for i := range out {
-			out[i] = abi.SectorNumber(i)
+	pause := func(d time.Duration) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			pcb.PauseWithDeadlineRisk(time.Now().Add(d))
+			return nil
 		}
-		return out
 	}
 
-	tcs := map[string]struct {
-		actions []action
-	}{
-		"addSingle": {
-			actions: []action{
-				addSector(0, false),
-				waitPending(1),
-				flush([]abi.SectorNumber{0}),
-			},
-		},
-		"addTwo": {
-			actions: []action{
-				addSectors(getSectors(2), false),
-				waitPending(2),
-				flush(getSectors(2)),
-			},
-		},
-		"addMax": {
-			actions: []action{
-				expectSend(getSectors(maxBatch)),
-				addSectors(getSectors(maxBatch), true),
-			},
-		},
-		"addMax-belowBaseFee": {
-			actions: []action{
-				expectSendsSingle(getSectors(maxBatch)),
-				addSectors(getSectors(maxBatch), false),
-			},
-		},
+	resume := func() action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			pcb.PauseWithDeadlineRisk(time.Time{})
+			return nil
+		}
 	}
 
-	for name, tc := range tcs {
-		tc := tc
+	decisions := new(bytes.Buffer)
 
-		t.Run(name, func(t *testing.T) {
-			// create go mock controller here
-			mockCtrl := gomock.NewController(t)
-			// when test is done, assert expectations on all mock objects.
-			defer mockCtrl.Finish()
+	logDecisions := func() action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			decisions.Reset()
+			pcb.SetDecisionLog(decisions)
+			return nil
+		}
+	}
 
-			// create them mocks
-			pcapi := mocks.NewMockPreCommitBatcherApi(mockCtrl)
+	// expectDecisions must run after the batcher is stopped, which flushes the log
+	expectDecisions := func(paths ...string) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			dec := json.NewDecoder(decisions)
+			for _, path := range paths {
+				var d pipeline.PreCommitDecision
+				require.NoError(t, dec.Decode(&d))
+				require.Equal(t, path, d.Path)
+				require.Equal(t, network.Version14, d.NetworkVersion)
+				require.Equal(t, []abi.SectorNumber{0}, d.Sent)
+				require.Empty(t, d.Deferred)
+			}
+			require.False(t, dec.More())
+			return nil
+		}
+	}
 
-			pcb := pipeline.NewPreCommitBatcher(ctx, t0123, pcapi, as, fc, cfg)
+	// flushWhileStopping flushes concurrently with Stop, expecting the flushes to
+	// return instead of blocking on the exited run loop
+	flushWhileStopping := func(flushes int) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			errCh := make(chan error, flushes)
+			for i := 0; i < flushes; i++ {
+				go func() {
+					_, err := pcb.Flush(ctx)
+					errCh <- err
+				}()
+			}
 
-			var promises []promise
+			require.NoError(t, pcb.Stop(ctx))
 
-			for _, a := range tc.actions {
-				p := a(t, pcapi, pcb)
-				if p != nil {
-					promises = append(promises, p)
+			for i := 0; i < flushes; i++ {
+				select {
+				case err := <-errCh:
+					if err != nil {
+						require.ErrorIs(t, err, pipeline.ErrBatcherStopped)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("flush wasn't unblocked by Stop")
 				}
 			}
 
-			for _, p := range promises {
-				p(t)
-			}
+			// once stopped, flushes always fail
+			_, err := pcb.Flush(ctx)
+			require.ErrorIs(t, err, pipeline.ErrBatcherStopped)
 
-			err := pcb.Stop(ctx)
+			return nil
+		}
+	}
+
+	telemetry := &captureSink{}
+
+	exportTelemetry := func() action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			telemetry.reset()
+			pcb.SetTelemetrySink(telemetry)
+			return nil
+		}
+	}
+
+	// expectTelemetry must run after the batcher is stopped, which waits for the sink
+	expectTelemetry := func(sent ...[]abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			recs := telemetry.get()
+			require.Len(t, recs, len(sent))
+			for i, sns := range sent {
+				require.Equal(t, "batch", recs[i].d.Path)
+				require.Equal(t, sns, recs[i].d.Sent)
+				require.Len(t, recs[i].res, 1)
+				require.Equal(t, sns, recs[i].res[0].Sectors)
+				require.NotNil(t, recs[i].res[0].Msg)
+				require.NotEmpty(t, recs[i].d.TipSet.Cids())
+				require.Equal(t, recs[i].d.TipSet.Cids(), recs[i].res[0].TipSet)
+			}
+			return nil
+		}
+	}
+
+	stop := func() action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			require.NoError(t, pcb.Stop(ctx))
+			return nil
+		}
+	}
+
+	addSectorWithTicket := func(sn abi.SectorNumber, aboveBalancer bool, ticketEpoch abi.ChainEpoch) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			basefee := big.NewInt(9999)
+			if aboveBalancer {
+				basefee = big.NewInt(10001)
+			}
+
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, basefee, 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			return queueSector(sn, ticketEpoch)(t, s, pcb)
+		}
+	}
+
+	addSector := func(sn abi.SectorNumber, aboveBalancer bool) action {
+		return addSectorWithTicket(sn, aboveBalancer, 0)
+	}
+
+	// adds a sector and removes it from the queue, its caller gets a removed result
+	addRemoved := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			resCh := make(chan sealiface.PreCommitBatchRes, 1)
+			errCh := make(chan error, 1)
+			go func() {
+				res, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+					SectorNumber: sn,
+					SealedCID:    fakePieceCid(t),
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				})
+				resCh <- res
+				errCh <- err
+			}()
+
+			require.Eventually(t, func() bool {
+				return pcb.RemovePreCommit(ctx, sn) == nil
+			}, 5*time.Second, 10*time.Millisecond)
+
+			// it's no longer queued
+			require.Error(t, pcb.RemovePreCommit(ctx, sn))
+
+			res := <-resCh
+			require.NoError(t, <-errCh)
+			require.Equal(t, pipeline.ErrPreCommitRemoved.Error(), res.Error)
+			require.Equal(t, []abi.SectorNumber{sn}, res.Sectors)
+
+			return nil
+		}
+	}
+
+	// queues a sector without a sealed CID, it's left out of the batch and its
+	// caller gets an error result
+	addInvalid := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			resCh := make(chan sealiface.PreCommitBatchRes, 1)
+			errCh := make(chan error, 1)
+			go func() {
+				res, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+					SectorNumber: sn,
+					SealedCID:    cid.Undef,
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				})
+				resCh <- res
+				errCh <- err
+			}()
+
+			return func(t *testing.T) {
+				res := <-resCh
+				require.NoError(t, <-errCh)
+				require.Nil(t, res.Msg)
+				require.Equal(t, []abi.SectorNumber{sn}, res.Sectors)
+				require.Contains(t, res.Error, "sealed CID not set")
+			}
+		}
+	}
+
+	// adds a sector which is expected to fail when flushed, its caller gets an error
+	// result containing errMsg
+	// adds a sector expecting it to fail on its own with errMsg
+	addFailingInfo := func(si pipeline.SectorInfo, errMsg string) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			sn := si.SectorNumber
+			resCh := make(chan sealiface.PreCommitBatchRes, 1)
+			errCh := make(chan error, 1)
+			go func() {
+				res, err := pcb.AddPreCommit(ctx, si, big.Zero(), &minertypes.SectorPreCommitInfo{
+					SectorNumber: sn,
+					SealedCID:    fakePieceCid(t),
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				})
+				resCh <- res
+				errCh <- err
+			}()
+
+			return func(t *testing.T) {
+				res := <-resCh
+				require.NoError(t, <-errCh)
+				require.Nil(t, res.Msg)
+				require.Equal(t, []abi.SectorNumber{sn}, res.Sectors)
+				require.Contains(t, res.Error, errMsg)
+			}
+		}
+	}
+
+	addFailing := func(sn abi.SectorNumber, errMsg string) action {
+		return addFailingInfo(pipeline.SectorInfo{SectorNumber: sn}, errMsg)
+	}
+
+	// adds a sector expecting the batch it's sent in to fail with errMsg
+	addFailingInBatch := func(sn abi.SectorNumber, errMsg string) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			resCh := make(chan sealiface.PreCommitBatchRes, 1)
+			errCh := make(chan error, 1)
+			go func() {
+				res, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+					SectorNumber: sn,
+					SealedCID:    fakePieceCid(t),
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				})
+				resCh <- res
+				errCh <- err
+			}()
+
+			return func(t *testing.T) {
+				res := <-resCh
+				require.NoError(t, <-errCh)
+				require.Nil(t, res.Msg)
+				require.Contains(t, res.Sectors, sn)
+				require.Contains(t, res.Error, errMsg)
+			}
+		}
+	}
+
+	// adds a sector expecting the wait reported in its result to be within [lo, hi]
+	addSectorExpectWait := func(sn abi.SectorNumber, lo, hi time.Duration) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			resCh := make(chan sealiface.PreCommitBatchRes, 1)
+			errCh := make(chan error, 1)
+			go func() {
+				res, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+					SectorNumber: sn,
+					SealedCID:    fakePieceCid(t),
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				})
+				resCh <- res
+				errCh <- err
+			}()
+
+			return func(t *testing.T) {
+				res := <-resCh
+				require.NoError(t, <-errCh)
+				require.Empty(t, res.Error)
+				require.GreaterOrEqual(t, res.ExpectedWait, lo)
+				require.LessOrEqual(t, res.ExpectedWait, hi)
+			}
+		}
+	}
+
+	// adds a sector with a deal, and a ticket at ticketEpoch
+	addDealSectorWithTicket := func(sn abi.SectorNumber, ticketEpoch abi.ChainEpoch) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			return queueSectorInfo(pipeline.SectorInfo{
+				SectorNumber: sn,
+				TicketEpoch:  ticketEpoch,
+				Pieces: []pipeline.Piece{{
+					DealInfo: &api.PieceDealInfo{
+						DealProposal: &market.DealProposal{
+							StartEpoch:           1000,
+							EndEpoch:             2000,
+							StoragePricePerEpoch: big.NewInt(1),
+						},
+						DealSchedule: api.DealSchedule{
+							StartEpoch: 1000,
+							EndEpoch:   2000,
+						},
+					},
+				}},
+			})(t, s, pcb)
+		}
+	}
+
+	reorgCutoffEpoch := 1 + abi.ChainEpoch(4*time.Hour/(time.Duration(build.BlockDelaySecs)*time.Second))
+
+	// ticket epoch which puts the sector cutoff 10 epochs after the current head
+	urgentTicket := abi.ChainEpoch(11) - policy.MaxPreCommitRandomnessLookback
+
+	addSectorShortExpiration := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			_, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+				SectorNumber: sn,
+				SealedCID:    fakePieceCid(t),
+				Expiration:   policy.GetMinSectorExpiration(),
+			})
+			require.ErrorContains(t, err, "below the minimum sector lifetime")
+
+			return nil
+		}
+	}
+
+	addSectorLowDeposit := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			_, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.NewInt(1000), &minertypes.SectorPreCommitInfo{
+				SealProof:    abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+				SectorNumber: sn,
+				SealedCID:    fakePieceCid(t),
+				Expiration:   policy.GetMaxSectorExpirationExtension(),
+			})
+			require.ErrorContains(t, err, "below the minimum")
+
+			return nil
+		}
+	}
+
+	addSectorFutureTicket := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+
+			_, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn, TicketEpoch: 10}, big.Zero(), &minertypes.SectorPreCommitInfo{
+				SectorNumber: sn,
+				SealedCID:    fakePieceCid(t),
+				Expiration:   policy.GetMaxSectorExpirationExtension(),
+			})
+			require.ErrorContains(t, err, "ahead of the chain head")
+
+			return nil
+		}
+	}
+
+	addSectorStaleRandomness := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			var res sealiface.PreCommitBatchRes
+			var err error
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				res, err = pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+					SectorNumber:  sn,
+					SealedCID:     fakePieceCid(t),
+					SealRandEpoch: -policy.MaxPreCommitRandomnessLookback - 1,
+					Expiration:    policy.GetMaxSectorExpirationExtension(),
+				})
+			}()
+
+			return func(t *testing.T) {
+				<-done
+				require.NoError(t, err)
+				require.Nil(t, res.Msg)
+				require.Equal(t, []abi.SectorNumber{sn}, res.Sectors)
+				require.Contains(t, res.Error, "seal randomness")
+			}
+		}
+	}
+
+	addSectors := func(sectors []abi.SectorNumber, aboveBalancer bool) action {
+		as := make([]action, len(sectors))
+		for i, sector := range sectors {
+			as[i] = addSector(sector, aboveBalancer)
+		}
+		return actions(as...)
+	}
+
+	waitPending := func(n int) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			require.Eventually(t, func() bool {
+				p, err := pcb.Pending(ctx)
+				require.NoError(t, err)
+				return len(p) == n
+			}, time.Second*5, 10*time.Millisecond)
+
+			return nil
+		}
+	}
+
+	waitPendingSectors := func(sns ...abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			require.Eventually(t, func() bool {
+				p, err := pcb.Pending(ctx)
+				require.NoError(t, err)
+
+				var got []abi.SectorNumber
+				for _, sid := range p {
+					got = append(got, sid.Number)
+				}
+				sort.Slice(got, func(i, j int) bool {
+					return got[i] < got[j]
+				})
+				return reflect.DeepEqual(sns, got)
+			}, time.Second*5, 10*time.Millisecond)
+
+			return nil
+		}
+	}
+
+	expectMargin := func(sn abi.SectorNumber, margin abi.ChainEpoch) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			require.Equal(t, margin, pcb.CutoffMargin(sn))
+			return nil
+		}
+	}
+
+	// changes the PreCommitBatchWait of reconfigurableCfg, applying it right away
+	reconfigure := func(wait time.Duration) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			atomic.StoreInt64(&reconfigurableWait, int64(wait))
+			require.NoError(t, pcb.Reconfigure(ctx))
+			return nil
+		}
+	}
+
+	sleep := func(d time.Duration) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			time.Sleep(d)
+			return nil
+		}
+	}
+
+	//stm: @CHAIN_STATE_MINER_INFO_001, @CHAIN_STATE_NETWORK_VERSION_001
+	expectSend := func(expect []abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), funMatcher(func(i interface{}) bool {
+				b := i.(*types.Message)
+				var params miner6.PreCommitSectorBatchParams
+				require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(b.Params)))
+				for s, number := range expect {
+					require.Equal(t, number, params.Sectors[s].SectorNumber)
+				}
+				return true
+			}), gomock.Any()).Return(dummySmsg, nil)
+			return nil
+		}
+	}
+
+	// queues a sector with a mock clock set, the run loop waits on it, so moving it
+	// past the batch wait sends the sector
+	sendOnMockClock := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			mock := clock.NewMock()
+			pcb.SetClock(mock)
+
+			_ = expectSend([]abi.SectorNumber{sn})(t, s, pcb)
+			p := addSector(sn, true)(t, s, pcb)
+
+			require.Eventually(t, func() bool {
+				mock.Add(25 * time.Hour)
+
+				p, err := pcb.Pending(ctx)
+				require.NoError(t, err)
+				return len(p) == 0
+			}, 5*time.Second, 10*time.Millisecond)
+
+			return p
+		}
+	}
+
+	// expects batch messages of the given sizes to be sent, in order
+	expectSendSizes := func(sizes ...int) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+
+			var calls []*gomock.Call
+			for _, size := range sizes {
+				size := size
+				calls = append(calls, s.EXPECT().MpoolPushMessage(gomock.Any(), funMatcher(func(i interface{}) bool {
+					var params miner6.PreCommitSectorBatchParams
+					if err := params.UnmarshalCBOR(bytes.NewReader(i.(*types.Message).Params)); err != nil {
+						return false
+					}
+					return len(params.Sectors) == size
+				}), gomock.Any()).Return(dummySmsg, nil))
+			}
+			gomock.InOrder(calls...)
+
+			return nil
+		}
+	}
+
+	// recomputes cutoffs with the head at the given height, expecting the sectors to be sent
+	recomputeCutoffsSend := func(height abi.ChainEpoch, expect []abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), height), nil)
+			_ = expectSend(expect)(t, s, pcb)
+
+			require.NoError(t, pcb.RecomputeCutoffs(ctx))
+			return nil
+		}
+	}
+
+	//stm: @CHAIN_STATE_MINER_INFO_001, @CHAIN_STATE_NETWORK_VERSION_001
+	expectSendsSingleAt := func(expect []abi.SectorNumber, basefee abi.TokenAmount) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, basefee, 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			for _, number := range expect {
+				numClone := number
+				s.EXPECT().MpoolPushMessage(gomock.Any(), funMatcher(func(i interface{}) bool {
+					b := i.(*types.Message)
+					var params miner6.PreCommitSectorParams
+					require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(b.Params)))
+					require.Equal(t, numClone, params.SectorNumber)
+					return true
+				}), gomock.Any()).Return(dummySmsg, nil)
+			}
+			return nil
+		}
+	}
+
+	expectSendsSingle := func(expect []abi.SectorNumber) action {
+		return expectSendsSingleAt(expect, big.NewInt(9999))
+	}
+
+	flushIndividual := func(expect []abi.SectorNumber, reason string) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			_ = expectSendsSingle(expect)(t, s, pcb)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, len(expect))
+			for _, res := range r {
+				require.Empty(t, res.Error)
+				require.Len(t, res.Sectors, 1)
+				require.Equal(t, reason, res.IndividualReason)
+				require.Equal(t, t0123, res.From)
+			}
+
+			return nil
+		}
+	}
+
+	// forces individual sends at a base fee above BatchPreCommitAboveBaseFee
+	forceIndividual := func(expect []abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			_ = expectSendsSingleAt(expect, big.NewInt(10001))(t, s, pcb)
+
+			r, err := pcb.ForceIndividual(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, len(expect))
+			for _, res := range r {
+				require.Empty(t, res.Error)
+				require.Len(t, res.Sectors, 1)
+				require.Equal(t, sealiface.IndividualForced, res.IndividualReason)
+			}
+
+			return nil
+		}
+	}
+
+	//stm: @CHAIN_STATE_MINER_INFO_001, @CHAIN_STATE_NETWORK_VERSION_001
+	expectSendHybrid := func() action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			// one message for the urgent sector, one batch for the rest
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil).Times(2)
+			return nil
+		}
+	}
+
+	expectChainAnyTimes := func() action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil).AnyTimes()
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil).AnyTimes()
+			return nil
+		}
+	}
+
+	// like expectChainAnyTimes, with a head well over the lag limit of laggingCfg
+	expectLaggingChainAnyTimes := func() action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeLaggingBFTs(t, big.NewInt(10001), 1, time.Hour), nil).AnyTimes()
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil).AnyTimes()
+			return nil
+		}
+	}
+
+	expectEmergencySends := func(n int) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			require.Equal(t, n, pcb.EmergencySends())
+			return nil
+		}
+	}
+
+	expectUpgradeAt := func(height abi.ChainEpoch) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateGetNetworkParams(gomock.Any()).Return(&api.NetworkParams{
+				ForkUpgradeParams: api.ForkUpgradeParams{UpgradeSkyrHeight: height},
+			}, nil).AnyTimes()
+			return nil
+		}
+	}
+
+	drain := func(expect ...[]abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil).Times(len(expect))
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil).Times(len(expect))
+
+			dctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+
+			r, err := pcb.Drain(dctx)
+			require.NoError(t, err)
+			require.Len(t, r, len(expect))
+			for i, e := range expect {
+				require.Empty(t, r[i].Error)
+				require.Equal(t, e, r[i].Sectors)
+			}
+
+			return nil
+		}
+	}
+
+	// flushes expecting one batch with the given sectors and deadline hint
+	flushHinted := func(expect []abi.SectorNumber, dlIdx uint64) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+			require.Equal(t, expect, r[0].Sectors)
+			require.NotNil(t, r[0].DeadlineHint)
+			require.Equal(t, dlIdx, *r[0].DeadlineHint)
+
+			return nil
+		}
+	}
+
+	// flushes expecting one batch with each of the given sector sets
+	flushBatched := func(expect ...[]abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil).Times(len(expect))
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, len(expect))
+			for i, e := range expect {
+				require.Empty(t, r[i].Error)
+				require.Equal(t, e, r[i].Sectors)
+			}
+
+			return nil
+		}
+	}
+
+	// expects n batch messages to be sent by one send attempt, reading the miner
+	// info once for all of them
+	expectBatches := func(n int) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil).Times(n)
+			return nil
+		}
+	}
+
+	// expects batches sent from the given addresses in order, the control
+	// addresses of the miner all being funded
+	// expects batches sent from the given addresses in turn, with the keys of the
+	// inWallet addresses in the wallet
+	expectBatchSendersFrom := func(worker address.Address, controls, inWallet []address.Address, expect ...address.Address) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: worker, ControlAddresses: controls}, nil)
+			s.EXPECT().WalletBalance(gomock.Any(), gomock.Any()).Return(types.FromFil(100), nil).AnyTimes()
+			s.EXPECT().StateAccountKey(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+					// looked up at the tipset of the batch
+					if tsk == types.EmptyTSK {
+						return address.Undef, xerrors.New("account key looked up at the empty tipset")
+					}
+					return addr, nil
+				}).AnyTimes()
+			s.EXPECT().WalletHas(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, addr address.Address) (bool, error) {
+					for _, a := range inWallet {
+						if a == addr {
+							return true, nil
+						}
+					}
+					return false, nil
+				}).AnyTimes()
+
+			senders := make(chan address.Address, len(expect))
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, msg *types.Message, _ *api.MessageSendSpec) (*types.SignedMessage, error) {
+					senders <- msg.From
+					return dummySmsg, nil
+				}).Times(len(expect))
+
+			return func(t *testing.T) {
+				for _, e := range expect {
+					select {
+					case from := <-senders:
+						require.Equal(t, e, from)
+					case <-time.After(5 * time.Second):
+						t.Fatal("batch wasn't sent")
+					}
+				}
+			}
+		}
+	}
+
+	expectBatchSenders := func(controls []address.Address, expect ...address.Address) action {
+		return expectBatchSendersFrom(t0123, controls, controls, expect...)
+	}
+
+	// flushes the queued sectors in two batches, the second failing to send with a
+	// permanent error. The results of the first batch are kept.
+	flushSecondBatchFails := func(sectors []abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			type addRes struct {
+				res sealiface.PreCommitBatchRes
+				err error
+			}
+
+			results := map[abi.SectorNumber]chan addRes{}
+			for _, sn := range sectors {
+				results[sn] = make(chan addRes, 1)
+				go func(sn abi.SectorNumber, out chan addRes) {
+					r, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+						SectorNumber: sn,
+						SealedCID:    fakePieceCid(t),
+						Expiration:   policy.GetMaxSectorExpirationExtension(),
+					})
+					out <- addRes{res: r, err: err}
+				}(sn, results[sn])
+			}
+
+			_ = waitPending(len(sectors))(t, s, pcb)
+
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			gomock.InOrder(
+				s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil),
+				s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, xerrors.New("message nonce too low")),
+			)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 2)
+			require.Empty(t, r[0].Error)
+			require.NotNil(t, r[0].Msg)
+			require.NotEmpty(t, r[1].Error)
+			require.Nil(t, r[1].Msg)
+
+			for _, br := range r {
+				for _, sn := range br.Sectors {
+					ar := <-results[sn]
+					require.NoError(t, ar.err)
+					require.Equal(t, br.Error, ar.res.Error)
+					require.Equal(t, br.Msg, ar.res.Msg)
+				}
+			}
+
+			return nil
+		}
+	}
+
+	// batch messages are estimated to use the given amount of gas per sector
+	expectGasPerSector := func(gas int64) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().GasEstimateMessageGas(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, msg *types.Message, _ *api.MessageSendSpec, _ types.TipSetKey) (*types.Message, error) {
+					var params miner6.PreCommitSectorBatchParams
+					if err := params.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+						return nil, err
+					}
+
+					est := *msg
+					est.GasLimit = gas * int64(len(params.Sectors))
+					return &est, nil
+				}).AnyTimes()
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil).AnyTimes()
+			return nil
+		}
+	}
+
+	// flushes expecting one batch, with the given number of sectors left queued
+	flushSplit := func(expect []abi.SectorNumber, deferred int) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+			require.Equal(t, expect, r[0].Sectors)
+			require.Equal(t, deferred, r[0].DeferredFull)
+
+			return nil
+		}
+	}
+
+	// flushes expecting one full batch, with the given number of sectors left queued
+	flushFull := func(expect []abi.SectorNumber, deferred int) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+			require.Equal(t, expect, r[0].Sectors)
+			require.Equal(t, deferred, r[0].DeferredFull)
+
+			return nil
+		}
+	}
+
+	// the test address selector reports no funds, so the whole deposit is a shortfall
+	checkFunds := func(shortfall abi.TokenAmount) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+
+			sf, err := pcb.CheckQueuedFunds(ctx)
+			require.NoError(t, err)
+			require.True(t, shortfall.Equals(sf), "expected shortfall %s, got %s", shortfall, sf)
+
+			return nil
+		}
+	}
+
+	// flushDropping flushes the queue expecting the dropped sectors to fail without being sent
+	flushDropping := func(expect []abi.SectorNumber, dropped ...abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			_ = expectSend(expect)(t, s, pcb)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1+len(dropped))
+			require.Empty(t, r[0].Error)
+			require.Equal(t, expect, r[0].Sectors)
+			for i, sn := range dropped {
+				require.Equal(t, []abi.SectorNumber{sn}, r[1+i].Sectors)
+				require.NotEmpty(t, r[1+i].Error)
+			}
+
+			return nil
+		}
+	}
+
+	// flushUnderfunded flushes the queue with the sending address holding less than
+	// the batch needs, expecting the batch to fail without being pushed
+	flushUnderfunded := func(expect []abi.SectorNumber, balance abi.TokenAmount) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().WalletBalance(gomock.Any(), t0123).Return(balance, nil)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Equal(t, expect, r[0].Sectors)
+			require.Nil(t, r[0].Msg)
+			require.Contains(t, r[0].Error, fmt.Sprintf("address %s has %s, needs", t0123, types.FIL(balance)))
+
+			return nil
+		}
+	}
+
+	// flushNonce flushes the queue with the mpool assigning nonce to the batch
+	// message, expecting it to be reported in the result
+	flushNonce := func(expect []abi.SectorNumber, nonce uint64) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, msg *types.Message, _ *api.MessageSendSpec) (*types.SignedMessage, error) {
+					smsg := &types.SignedMessage{Message: *msg, Signature: dummySmsg.Signature}
+					smsg.Message.Nonce = nonce
+					return smsg, nil
+				})
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+			require.NotNil(t, r[0].Msg)
+			require.Equal(t, nonce, r[0].Nonce)
+			require.Equal(t, expect, r[0].Sectors)
+
+			return nil
+		}
+	}
+
+	// flushTipSet flushes the queue, expecting the result to carry the key of the
+	// head the send was based on
+	flushTipSet := func(expect []abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			head := makeBFTs(t, big.NewInt(10001), 1)
+			s.EXPECT().ChainHead(gomock.Any()).Return(head, nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), head.Key()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), head.Key()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+			require.Equal(t, expect, r[0].Sectors)
+			require.Equal(t, head.Cids(), r[0].TipSet)
+
+			return nil
+		}
+	}
+
+	// flushHeld flushes the queue expecting nothing to be sent, with n sectors left queued
+	flushHeld := func(n int) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Empty(t, r)
+
+			p, err := pcb.Pending(ctx)
+			require.NoError(t, err)
+			require.Len(t, p, n)
+
+			return nil
+		}
+	}
+
+	// previews the sending address of the queued sectors, then flushes them,
+	// expecting the message to be sent from the previewed address
+	previewSender := func(expect address.Address) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil).AnyTimes()
+			s.EXPECT().WalletBalance(gomock.Any(), gomock.Any()).Return(types.FromFil(100), nil).AnyTimes()
+
+			var from address.Address
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, msg *types.Message, _ *api.MessageSendSpec) (*types.SignedMessage, error) {
+					from = msg.From
+					return dummySmsg, nil
+				})
+
+			addr, err := pcb.PreviewSendAddress(ctx)
+			require.NoError(t, err)
+			require.Equal(t, expect, addr)
+
+			// nothing was sent by the preview
+			p, err := pcb.Pending(ctx)
+			require.NoError(t, err)
+			require.NotEmpty(t, p)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+			require.Equal(t, addr, from)
+
+			return nil
+		}
+	}
+
+	// adds validators after the default ones
+	addValidators := func(vs ...pipeline.BatchValidator) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			pcb.SetBatchValidators(append(pipeline.DefaultBatchValidators(), vs...)...)
+			return nil
+		}
+	}
+
+	// flushes with a custom batch method strategy, expecting one message with its method
+	flushMethod := func(expect []abi.SectorNumber, method abi.MethodNum) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			pcb.SetBatchMethodStrategy(customMethod(method))
+
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+
+			var sent *types.Message
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, msg *types.Message, spec *api.MessageSendSpec) (*types.SignedMessage, error) {
+					sent = msg
+					return dummySmsg, nil
+				})
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+			require.Equal(t, expect, r[0].Sectors)
+
+			require.NotNil(t, sent)
+			require.Equal(t, method, sent.Method)
+			var params miner6.PreCommitSectorBatchParams
+			require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(sent.Params)))
+			require.Len(t, params.Sectors, len(expect))
+
+			return nil
+		}
+	}
+
+	// flushes with a send func which blocks, checking that the run state shows the
+	// run loop inside the send attempt until the send returns
+	flushBlockedRunState := func(expect []abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			release := make(chan struct{})
+			pcb.SetSendFunc(func(ctx context.Context, msg *types.Message, maxFee abi.TokenAmount) (cid.Cid, error) {
+				<-release
+				return msg.Cid(), nil
+			})
+
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+
+			require.False(t, pcb.DebugRunState().InSendAttempt)
+
+			resCh := make(chan []sealiface.PreCommitBatchRes, 1)
+			errCh := make(chan error, 1)
+			go func() {
+				r, err := pcb.Flush(ctx)
+				resCh <- r
+				errCh <- err
+			}()
+
+			require.Eventually(t, func() bool {
+				return pcb.DebugRunState().InSendAttempt
+			}, 5*time.Second, 10*time.Millisecond)
+
+			st := pcb.DebugRunState()
+			require.False(t, st.SendAttemptStart.IsZero())
+			require.False(t, st.Stopping)
+			require.False(t, st.Stopped)
+
+			close(release)
+			r := <-resCh
+			require.NoError(t, <-errCh)
+			require.Len(t, r, 1)
+			require.Equal(t, expect, r[0].Sectors)
+
+			// results are delivered after the run loop leaves the send attempt
+			require.False(t, pcb.DebugRunState().InSendAttempt)
+
+			require.NoError(t, pcb.Stop(ctx))
+			st = pcb.DebugRunState()
+			require.True(t, st.Stopping)
+			require.True(t, st.Stopped)
+
+			return nil
+		}
+	}
+
+	// flushDuringSend fills a batch, and flushes while the send attempt this triggers
+	// is blocked reading the chain head. A merged flush makes the attempt send despite
+	// the chain lag deferral, otherwise the attempt is deferred, and the flush sends in
+	// an attempt of its own, reading the head again.
+	flushDuringSend := func(merge bool) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			head := makeLaggingBFTs(t, big.NewInt(10001), 1, time.Hour)
+			release := make(chan struct{})
+
+			// AddPreCommit reads the head once per sector, the send attempt after that
+			var heads int32
+			s.EXPECT().ChainHead(gomock.Any()).DoAndReturn(func(context.Context) (*types.TipSet, error) {
+				if atomic.AddInt32(&heads, 1) == 3 {
+					<-release
+				}
+				return head, nil
+			}).AnyTimes()
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil).AnyTimes()
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil)
+
+			first := queueSector(0, 0)(t, s, pcb)
+
+			// the notification for a single sector is handled without reading the head
+			require.Eventually(t, func() bool {
+				p, err := pcb.Pending(ctx)
+				require.NoError(t, err)
+				st := pcb.DebugRunState()
+				return len(p) == 1 && st.NotifyPending == 0 && !st.InSendAttempt
+			}, 5*time.Second, 10*time.Millisecond)
+
+			second := queueSector(1, 0)(t, s, pcb)
+			require.Eventually(t, func() bool {
+				return atomic.LoadInt32(&heads) == 3
+			}, 5*time.Second, 10*time.Millisecond)
+
+			resCh := make(chan []sealiface.PreCommitBatchRes, 1)
+			errCh := make(chan error, 1)
+			go func() {
+				r, err := pcb.Flush(ctx)
+				resCh <- r
+				errCh <- err
+			}()
+
+			if merge {
+				require.Eventually(t, func() bool {
+					return pcb.DebugRunState().JoinedFlushes == 1
+				}, 5*time.Second, 10*time.Millisecond)
+			}
+
+			close(release)
+			r := <-resCh
+			require.NoError(t, <-errCh)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+			sort.Slice(r[0].Sectors, func(i, j int) bool {
+				return r[0].Sectors[i] < r[0].Sectors[j]
+			})
+			require.Equal(t, []abi.SectorNumber{0, 1}, r[0].Sectors)
+
+			if merge {
+				require.EqualValues(t, 3, atomic.LoadInt32(&heads))
+			} else {
+				require.Greater(t, atomic.LoadInt32(&heads), int32(3))
+			}
+
+			return func(t *testing.T) {
+				first(t)
+				second(t)
+			}
+		}
+	}
+
+	// flushes with a send func which captures messages instead of pushing them to the mpool
+	flushIntercepted := func(expect []abi.SectorNumber, individual bool) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			var sent []*types.Message
+			pcb.SetSendFunc(func(ctx context.Context, msg *types.Message, maxFee abi.TokenAmount) (cid.Cid, error) {
+				sent = append(sent, msg)
+				return msg.Cid(), nil
+			})
+
+			basefee := big.NewInt(10001)
+			if individual {
+				basefee = big.NewInt(9999)
+			}
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, basefee, 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+
+			method := builtin.MethodsMiner.PreCommitSectorBatch
+			if individual {
+				method = builtin.MethodsMiner.PreCommitSector
+			}
+
+			require.Len(t, sent, len(r))
+			var got []abi.SectorNumber
+			for i, re := range r {
+				require.Empty(t, re.Error)
+				require.Equal(t, method, sent[i].Method)
+				require.Equal(t, sent[i].Cid(), *re.Msg)
+				got = append(got, re.Sectors...)
+			}
+			sort.Slice(got, func(i, j int) bool {
+				return got[i] < got[j]
+			})
+			require.Equal(t, expect, got)
+
+			return nil
+		}
+	}
+
+	expectStored := func(st *memStore, sns ...abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			require.Equal(t, sns, st.sectors())
+			return nil
+		}
+	}
+
+	// checks the reported timing of the next send at the given base fee
+	expectTiming := func(queued int, basefee int64, individual bool) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(basefee), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+			bt, err := pcb.BatchTiming(ctx)
+			require.NoError(t, err)
+			require.Equal(t, queued, bt.Queued)
+			require.Equal(t, individual, bt.Individual)
+			require.Equal(t, big.NewInt(basefee), bt.BaseFee)
+			require.Equal(t, policy.MaxPreCommitRandomnessLookback, bt.EarliestCutoff)
+
+			require.Greater(t, bt.NextSend, time.Duration(0))
+			require.LessOrEqual(t, bt.NextSend, 24*time.Hour)
+
+			return nil
+		}
+	}
+
+	// simulates the precommit of a sector at the given base fee, nothing is queued
+	// or sent
+	simulate := func(sn abi.SectorNumber, basefee int64, individual bool) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(basefee), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+
+			sim, err := pcb.SimulatePreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.NewInt(100), &minertypes.SectorPreCommitInfo{
+				SectorNumber: sn,
+				SealedCID:    fakePieceCid(t),
+				Expiration:   policy.GetMaxSectorExpirationExtension(),
+			})
+			require.NoError(t, err)
+			require.Equal(t, t0123, sim.From)
+			require.Equal(t, big.NewInt(100), sim.Deposit)
+			if individual {
+				require.Equal(t, big.NewInt(100), sim.Value)
+				require.Equal(t, builtin.MethodsMiner.PreCommitSector, sim.Method)
+				require.Equal(t, sealiface.IndividualLowBaseFee, sim.IndividualReason)
+			} else {
+				// the aggregate fee is sent along with the deposit
+				require.Equal(t, big.Add(big.NewInt(100), sim.AggregateFee), sim.Value)
+				require.Equal(t, builtin.MethodsMiner.PreCommitSectorBatch, sim.Method)
+				require.Empty(t, sim.IndividualReason)
+			}
+
+			p, err := pcb.Pending(ctx)
+			require.NoError(t, err)
+			require.Empty(t, p)
+
+			return nil
+		}
+	}
+
+	// checks the sectors of each result passed to the submit callback
+	expectSubmitted := func(l *submitLog, sectors ...[]abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			res := l.results()
+			got := make([][]abi.SectorNumber, len(res))
+			for i, r := range res {
+				require.NotNil(t, r.Msg)
+				require.Empty(t, r.Error)
+				got[i] = r.Sectors
+			}
+			require.ElementsMatch(t, sectors, got)
+			return nil
+		}
+	}
+
+	expectStoredInFlight := func(st *memStore, msgs ...cid.Cid) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			require.ElementsMatch(t, msgs, st.inFlightMsgs())
+			return nil
+		}
+	}
+
+	// the in-flight messages land right away, or don't land while the test runs
+	expectLanding := func(s *mocks.MockPreCommitBatcherApi, landed bool) {
+		s.EXPECT().StateWaitMsg(gomock.Any(), gomock.Any(), build.MessageConfidence, gomock.Any(), true).DoAndReturn(
+			func(ctx context.Context, msg cid.Cid, confidence uint64, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error) {
+				if landed {
+					return &api.MsgLookup{Message: msg, Height: 10}, nil
+				}
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}).AnyTimes()
+	}
+
+	landInFlight := func(landed bool) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			expectLanding(s, landed)
+			return nil
+		}
+	}
+
+	expectInFlightDeleted := func(st *memStore) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			require.Eventually(t, func() bool {
+				return len(st.inFlightMsgs()) == 0
+			}, 5*time.Second, 10*time.Millisecond)
+			return nil
+		}
+	}
+
+	// adds a sector which was sent in msg before a restart, it isn't queued again
+	addInFlight := func(sn abi.SectorNumber, msg cid.Cid) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+
+			res, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+				SectorNumber: sn,
+				SealedCID:    fakePieceCid(t),
+				Expiration:   policy.GetMaxSectorExpirationExtension(),
+			})
+			require.NoError(t, err)
+			require.Empty(t, res.Error)
+			require.Equal(t, []abi.SectorNumber{sn}, res.Sectors)
+			require.NotNil(t, res.Msg)
+			require.Equal(t, msg, *res.Msg)
+
+			p, err := pcb.Pending(ctx)
+			require.NoError(t, err)
+			for _, pc := range p {
+				require.NotEqual(t, sn, pc.Number)
+			}
+
+			return nil
+		}
+	}
+
+	// adds a sector again after its message was sent, while the message is still
+	// in the mpool; it isn't queued again
+	addSentPending := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateSearchMsg(gomock.Any(), gomock.Any(), dummySmsg.Cid(), gomock.Any(), gomock.Any()).Return(nil, nil)
+			s.EXPECT().MpoolPending(gomock.Any(), gomock.Any()).Return([]*types.SignedMessage{dummySmsg}, nil)
+
+			return addInFlight(sn, dummySmsg.Cid())(t, s, pcb)
+		}
+	}
+
+	removeRestored := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			require.NoError(t, pcb.RemovePreCommit(ctx, sn))
+			require.Error(t, pcb.RemovePreCommit(ctx, sn))
+			return nil
+		}
+	}
+
+	// the given sector numbers are reported as allocated on chain
+	expectAllocated := func(allocated ...abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateMinerSectorAllocated(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, maddr address.Address, sn abi.SectorNumber, tsk types.TipSetKey) (bool, error) {
+					for _, a := range allocated {
+						if a == sn {
+							return true, nil
+						}
+					}
+					return false, nil
+				}).AnyTimes()
+
+			return nil
+		}
+	}
+
+	assembleUnsigned := func(expect []abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().GasEstimateMessageGas(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, msg *types.Message, _ *api.MessageSendSpec, _ types.TipSetKey) (*types.Message, error) {
+					est := *msg
+					est.GasLimit = 1_000_000
+					est.GasFeeCap = big.NewInt(100)
+					est.GasPremium = big.NewInt(10)
+					return &est, nil
+				})
+			s.EXPECT().MpoolGetNonce(gomock.Any(), t0123).Return(uint64(7), nil)
+
+			ub, err := pcb.AssembleUnsigned(ctx)
+			require.NoError(t, err)
+			require.Len(t, ub, 1)
+			require.Equal(t, expect, ub[0].Sectors)
+
+			msg := ub[0].Message
+			require.Equal(t, t0123, msg.From)
+			require.Equal(t, uint64(7), msg.Nonce)
+			require.Equal(t, int64(1_000_000), msg.GasLimit)
+			require.Equal(t, builtin.MethodsMiner.PreCommitSectorBatch, msg.Method)
+
+			var params miner6.PreCommitSectorBatchParams
+			require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(msg.Params)))
+			require.Len(t, params.Sectors, len(expect))
+
+			return nil
+		}
+	}
+
+	// flushPendingInMpool flushes the queue while the mpool has a batch message for
+	// the pending sectors; with sendFails the message of the other sectors fails to
+	// send
+	flushPendingInMpool := func(expect, pending []abi.SectorNumber, sendFails bool) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			var params minertypes.PreCommitSectorBatchParams
+			for _, sn := range pending {
+				params.Sectors = append(params.Sectors, minertypes.SectorPreCommitInfo{SectorNumber: sn, SealedCID: fakePieceCid(t)})
+			}
+			enc := new(bytes.Buffer)
+			require.NoError(t, params.MarshalCBOR(enc))
+
+			pendingMsg := &types.SignedMessage{
+				Message: types.Message{
+					From:   t0123,
+					To:     t0123,
+					Method: builtin.MethodsMiner.PreCommitSectorBatch,
+					Params: enc.Bytes(),
+					Nonce:  3,
+				},
+				Signature: crypto.Signature{Type: crypto.SigTypeBLS},
+			}
+			otherMsg := &types.SignedMessage{
+				Message: types.Message{
+					From:   t0123,
+					To:     t0123,
+					Method: builtin.MethodsMiner.ProveCommitAggregate,
+				},
+				Signature: crypto.Signature{Type: crypto.SigTypeBLS},
+			}
+			s.EXPECT().MpoolPending(gomock.Any(), gomock.Any()).Return([]*types.SignedMessage{otherMsg, pendingMsg}, nil)
+
+			if sendFails {
+				s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+				s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+				s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+				s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, xerrors.New("estimating gas used: message execution failed: exit SysErrInsufficientFunds(6)"))
+			} else {
+				_ = expectSend(expect)(t, s, pcb)
+			}
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 2)
+			require.Equal(t, expect, r[0].Sectors)
+			if sendFails {
+				require.NotEmpty(t, r[0].Error)
+			} else {
+				require.Empty(t, r[0].Error)
+			}
+
+			// the pending message isn't failed along with the send
+			require.Empty(t, r[1].Error)
+			require.Equal(t, pending, r[1].Sectors)
+			require.Equal(t, pendingMsg.Cid(), *r[1].Msg)
+
+			return nil
+		}
+	}
+
+	flush := func(expect []abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			_ = expectSend(expect)(t, s, pcb)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+			require.Equal(t, network.Version14, r[0].NetworkVersion)
+			require.Equal(t, t0123, r[0].From)
+			require.Len(t, r[0].SealProofs, 1)
+			sort.Slice(r[0].Sectors, func(i, j int) bool {
+				return r[0].Sectors[i] < r[0].Sectors[j]
+			})
+			require.Equal(t, expect, r[0].Sectors)
+
+			return nil
+		}
+	}
+
+	// flushes with an approver which denies the send, or fails with approveErr, the
+	// sectors stay queued
+	flushDenied := func(expect []abi.SectorNumber, approveErr error) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			var asked []abi.SectorNumber
+			pcb.SetApproveSend(func(ctx context.Context, sectors []abi.SectorNumber, deposit, maxFee abi.TokenAmount) (bool, error) {
+				asked = append(asked, sectors...)
+				return false, approveErr
+			})
+
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Empty(t, r)
+
+			sort.Slice(asked, func(i, j int) bool {
+				return asked[i] < asked[j]
+			})
+			require.Equal(t, expect, asked)
+
+			pending, err := pcb.Pending(ctx)
+			require.NoError(t, err)
+			require.Len(t, pending, len(expect))
+
+			pcb.SetApproveSend(nil)
+			return nil
+		}
+	}
+
+	// flushes with the first failures pushes failing with a transient error, the
+	// batch of the expected sectors is sent by a retry
+	flushRetried := func(expect []abi.SectorNumber, failures int) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, xerrors.New("too many pending messages for actor")).Times(failures)
+			return flush(expect)(t, s, pcb)
+		}
+	}
+
+	// queues sn and flushes, removing sn while the send waits to be retried; the
+	// lock isn't held while waiting, and the message isn't sent again
+	flushRetryRemoved := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil).Times(2)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil).Times(2)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+
+			failed := make(chan struct{})
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, *types.Message, *api.MessageSendSpec) (*types.SignedMessage, error) {
+				close(failed)
+				return nil, xerrors.New("too many pending messages for actor")
+			})
+
+			resCh := make(chan sealiface.PreCommitBatchRes, 1)
+			go func() {
+				res, _ := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+					SectorNumber: sn,
+					SealedCID:    fakePieceCid(t),
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				})
+				resCh <- res
+			}()
+			_ = waitPendingSectors(sn)(t, s, pcb)
+
+			flushed := make(chan error, 1)
+			go func() {
+				_, err := pcb.Flush(ctx)
+				flushed <- err
+			}()
+
+			<-failed
+			require.NoError(t, pcb.RemovePreCommit(ctx, sn))
+			require.Equal(t, pipeline.ErrPreCommitRemoved.Error(), (<-resCh).Error)
+
+			require.NoError(t, <-flushed)
+
+			return nil
+		}
+	}
+
+	// queues the sectors and flushes, the push failing with an error which isn't
+	// known to be transient; the sectors fail without the send being retried
+	flushSendError := func(sectors []abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil).Times(len(sectors) + 1)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil).Times(len(sectors) + 1)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, xerrors.New("estimating gas used: message execution failed: exit SysErrInsufficientFunds(6)"))
+
+			results := make([]chan sealiface.PreCommitBatchRes, len(sectors))
+			for i, sn := range sectors {
+				results[i] = make(chan sealiface.PreCommitBatchRes, 1)
+				go func(sn abi.SectorNumber, out chan sealiface.PreCommitBatchRes) {
+					r, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+						SectorNumber: sn,
+						SealedCID:    fakePieceCid(t),
+						Expiration:   policy.GetMaxSectorExpirationExtension(),
+					})
+					require.NoError(t, err)
+					out <- r
+				}(sn, results[i])
+			}
+			_ = waitPending(len(sectors))(t, s, pcb)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Contains(t, r[0].Error, "SysErrInsufficientFunds")
+			require.ElementsMatch(t, sectors, r[0].Sectors)
+
+			for _, out := range results {
+				require.Contains(t, (<-out).Error, "SysErrInsufficientFunds")
+			}
+
+			return nil
+		}
+	}
+
+	// flushes with all tries pushes failing with a transient error, the n queued
+	// sectors stay queued
+	flushSendFails := func(n, tries int) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, xerrors.New("too many pending messages for actor")).Times(tries)
+
+			return flushHeld(n)(t, s, pcb)
+		}
+	}
+
+	// flushConsistent queues sectors, flushes them, and checks that the Flush caller
+	// and each AddPreCommit caller see the same result
+	flushConsistent := func(sectors []abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			type addRes struct {
+				res sealiface.PreCommitBatchRes
+				err error
+			}
+
+			results := make([]chan addRes, len(sectors))
+			for i, sn := range sectors {
+				s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+				s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil)
+
+				results[i] = make(chan addRes, 1)
+				go func(sn abi.SectorNumber, out chan addRes) {
+					r, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, big.Zero(), &minertypes.SectorPreCommitInfo{
+						SectorNumber: sn,
+						SealedCID:    fakePieceCid(t),
+						Expiration:   policy.GetMaxSectorExpirationExtension(),
+					})
+					out <- addRes{res: r, err: err}
+				}(sn, results[i])
+			}
+
+			_ = waitPending(len(sectors))(t, s, pcb)
+			_ = expectSend(sectors)(t, s, pcb)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+
+			for _, out := range results {
+				ar := <-out
+				require.NoError(t, ar.err)
+				// the expected wait is only reported to the waiter
+				ar.res.ExpectedWait = 0
+				require.Equal(t, r[0], ar.res)
+			}
+
+			return nil
+		}
+	}
+
+	// flushDeposit queues a sector with the given deposit and flushes it, checking that
+	// a zero or nil deposit results in the message only carrying the network fee
+	// flushPinned checks that miner info and balance are read at the current head
+	flushPinned := func(sn abi.SectorNumber, individual bool) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			basefee := big.NewInt(10001)
+			if individual {
+				basefee = big.NewInt(9999)
+			}
+
+			head := makeBFTs(t, basefee, 1)
+			s.EXPECT().ChainHead(gomock.Any()).Return(head, nil).Times(2)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), head.Key()).Return(network.Version14, nil).Times(2)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), head.Key()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().StateMinerAvailableBalance(gomock.Any(), gomock.Any(), head.Key()).Return(big.Zero(), nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), gomock.Any(), gomock.Any()).Return(dummySmsg, nil)
+
+			p := queueSector(sn, 0)(t, s, pcb)
+			_ = waitPending(1)(t, s, pcb)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+
+			return p
+		}
+	}
+
+	flushDeposit := func(sn abi.SectorNumber, deposit abi.TokenAmount, individual bool) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			basefee := big.NewInt(10001)
+			if individual {
+				basefee = big.NewInt(9999)
+			}
+
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, basefee, 1), nil).Times(2)
+			s.EXPECT().StateNetworkVersion(gomock.Any(), gomock.Any()).Return(network.Version14, nil).Times(2)
+			s.EXPECT().StateMinerInfo(gomock.Any(), gomock.Any(), gomock.Any()).Return(api.MinerInfo{Owner: t0123, Worker: t0123}, nil)
+			s.EXPECT().MpoolPushMessage(gomock.Any(), funMatcher(func(i interface{}) bool {
+				m := i.(*types.Message)
+				if individual {
+					want := big.Zero()
+					if !deposit.Nil() {
+						want = deposit
+					}
+					require.Equal(t, builtin.MethodsMiner.PreCommitSector, m.Method)
+					require.True(t, want.Equals(m.Value))
+				} else {
+					require.Equal(t, builtin.MethodsMiner.PreCommitSectorBatch, m.Method)
+					require.False(t, m.Value.LessThan(big.Zero()))
+				}
+				return true
+			}), gomock.Any()).Return(dummySmsg, nil)
+
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := pcb.AddPreCommit(ctx, pipeline.SectorInfo{SectorNumber: sn}, deposit, &minertypes.SectorPreCommitInfo{
+					SectorNumber: sn,
+					SealedCID:    fakePieceCid(t),
+					Expiration:   policy.GetMaxSectorExpirationExtension(),
+				})
+				errCh <- err
+			}()
+
+			_ = waitPending(1)(t, s, pcb)
+
+			r, err := pcb.Flush(ctx)
+			require.NoError(t, err)
+			require.Len(t, r, 1)
+			require.Empty(t, r[0].Error)
+			require.NoError(t, <-errCh)
+
+			return nil
+		}
+	}
+
+	// expectAddrFunds checks the funds requested from the address selector for the last send
+	expectAddrFunds := func(deposit abi.TokenAmount, feeCoverage bool) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			selLk.Lock()
+			defer selLk.Unlock()
+
+			require.True(t, selGoodFunds.GreaterThan(deposit))
+			if feeCoverage {
+				require.Equal(t, selGoodFunds, selMinFunds)
+			} else {
+				require.Equal(t, deposit, selMinFunds)
+			}
+
+			return nil
+		}
+	}
+
+	// the message never lands, the head moves well past the confirmation timeout
+	waitConfirmTimeout := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateSearchMsg(gomock.Any(), gomock.Any(), dummySmsg.Cid(), gomock.Any(), gomock.Any()).Return(nil, nil)
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 100), nil)
+
+			_, err := pcb.WaitConfirmed(ctx, sn)
+			require.ErrorIs(t, err, pipeline.ErrConfirmTimeout)
+
+			// the sector can be queued again
+			_, err = pcb.WaitConfirmed(ctx, sn)
+			require.Error(t, err)
+			require.NotErrorIs(t, err, pipeline.ErrConfirmTimeout)
+
+			return nil
+		}
+	}
+
+	checkStats := func(messages, sent, total uint64) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			st := pcb.BatchStats()
+			require.Equal(t, messages, st.SinceReset.Messages)
+			require.Equal(t, sent, st.SinceReset.SectorsSent)
+			require.Equal(t, total, st.Total.SectorsSent)
+			require.Zero(t, st.SinceReset.SectorsFailed)
+			return nil
+		}
+	}
+
+	resetStats := func() action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			before := pcb.BatchStats()
+			pcb.ResetStats()
+
+			st := pcb.BatchStats()
+			require.Equal(t, before.Total, st.Total)
+			require.Equal(t, sealiface.PreCommitBatchCounters{MaxFees: big.Zero()}, st.SinceReset)
+			require.False(t, st.LastReset.Before(before.LastReset))
+			return nil
+		}
+	}
+
+	// with the wait strategy the lookup comes from StateWaitMsg
+	waitConfirmedWait := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			found := &api.MsgLookup{Message: dummySmsg.Cid(), Height: 2}
+			confirmed := &api.MsgLookup{Message: dummySmsg.Cid(), Height: 2, TipSet: types.NewTipSetKey(fakePieceCid(t))}
+			s.EXPECT().StateSearchMsg(gomock.Any(), gomock.Any(), dummySmsg.Cid(), gomock.Any(), gomock.Any()).Return(found, nil)
+			s.EXPECT().StateWaitMsg(gomock.Any(), dummySmsg.Cid(), build.MessageConfidence, gomock.Any(), true).Return(confirmed, nil)
+
+			ml, err := pcb.WaitConfirmed(ctx, sn)
+			require.NoError(t, err)
+			require.Equal(t, confirmed, ml)
+
+			return nil
+		}
+	}
+
+	waitConfirmed := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			landed := &api.MsgLookup{Message: dummySmsg.Cid(), Height: 2}
+			s.EXPECT().StateSearchMsg(gomock.Any(), gomock.Any(), dummySmsg.Cid(), gomock.Any(), gomock.Any()).Return(landed, nil)
+
+			ml, err := pcb.WaitConfirmed(ctx, sn)
+			require.NoError(t, err)
+			require.Equal(t, landed, ml)
+
+			// confirmed sectors are forgotten
+			_, err = pcb.WaitConfirmed(ctx, sn)
+			require.Error(t, err)
+
+			return nil
+		}
+	}
+
+	// the message lands with the given exit code, the outcome is classified accordingly
+	waitConfirmation := func(sn abi.SectorNumber, code exitcode.ExitCode, status pipeline.PreCommitConfirmStatus) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			landed := &api.MsgLookup{Message: dummySmsg.Cid(), Height: 2, Receipt: types.MessageReceipt{ExitCode: code}}
+			s.EXPECT().StateSearchMsg(gomock.Any(), gomock.Any(), dummySmsg.Cid(), gomock.Any(), gomock.Any()).Return(landed, nil)
+
+			c, err := pcb.WaitConfirmation(ctx, sn)
+			require.NoError(t, err)
+			require.Equal(t, status, c.Status)
+			require.Equal(t, code, c.ExitCode)
+			require.Equal(t, dummySmsg.Cid(), c.Msg)
+			require.EqualValues(t, 2, c.Height)
+			require.False(t, c.RetryWithFeeBump)
+
+			return nil
+		}
+	}
+
+	// a reverted message isn't reported as confirmed
+	waitConfirmedReverted := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			landed := &api.MsgLookup{Message: dummySmsg.Cid(), Height: 2, Receipt: types.MessageReceipt{ExitCode: exitcode.ErrInsufficientFunds}}
+			s.EXPECT().StateSearchMsg(gomock.Any(), gomock.Any(), dummySmsg.Cid(), gomock.Any(), gomock.Any()).Return(landed, nil)
+
+			_, err := pcb.WaitConfirmed(ctx, sn)
+			require.ErrorIs(t, err, pipeline.ErrPreCommitReverted)
+
+			return nil
+		}
+	}
+
+	// the message never lands, it should be sent again with a higher fee
+	waitConfirmationNotIncluded := func(sn abi.SectorNumber) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			s.EXPECT().StateSearchMsg(gomock.Any(), gomock.Any(), dummySmsg.Cid(), gomock.Any(), gomock.Any()).Return(nil, nil)
+			s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 100), nil)
+
+			c, err := pcb.WaitConfirmation(ctx, sn)
+			require.NoError(t, err)
+			require.Equal(t, pipeline.PreCommitNotIncluded, c.Status)
+			require.Equal(t, dummySmsg.Cid(), c.Msg)
+			require.True(t, c.RetryWithFeeBump)
+
+			return nil
+		}
+	}
+
+	waitLanded := func(sn abi.SectorNumber, preCommitEpoch abi.ChainEpoch) action {
+		return func(t *testing.T, s *mocks.MockPreCommitBatcherApi, pcb *pipeline.PreCommitBatcher) promise {
+			tsk := types.NewTipSetKey(fakePieceCid(t))
+			landed := &api.MsgLookup{Message: dummySmsg.Cid(), Height: 2, TipSet: tsk}
+			s.EXPECT().StateSearchMsg(gomock.Any(), gomock.Any(), dummySmsg.Cid(), gomock.Any(), gomock.Any()).Return(landed, nil)
+			s.EXPECT().StateSectorPreCommitInfo(gomock.Any(), t0123, sn, tsk).Return(&minertypes.SectorPreCommitOnChainInfo{PreCommitEpoch: preCommitEpoch}, nil)
+
+			pl, err := pcb.WaitPreCommitLanded(ctx, sn)
+			require.NoError(t, err)
+			require.Equal(t, sn, pl.Sector)
+			require.Equal(t, dummySmsg.Cid(), pl.Msg)
+			require.Equal(t, tsk, pl.TipSet)
+			require.Equal(t, abi.ChainEpoch(2), pl.Height)
+			require.Equal(t, preCommitEpoch, pl.PreCommitEpoch)
+
+			return nil
+		}
+	}
+
+	getSectors := func(n int) []abi.SectorNumber {
+		out := make([]abi.SectorNumber, n)
+		for i := range out {
+			out[i] = abi.SectorNumber(i)
+		}
+		return out
+	}
+
+	savedStore, restoredStore, removedStore, sentStore, landedStore := newMemStore(), newMemStore(), newMemStore(), newMemStore(), newMemStore()
+	batchSubmitted, singleSubmitted := &submitLog{}, &submitLog{}
+
+	// messages pushed before a restart: one still in the mpool, one which landed,
+	// and one which was dropped
+	inFlightStore := newMemStore()
+	pendingMsg := &types.SignedMessage{Message: types.Message{To: t0123, From: t0123, Nonce: 1}, Signature: crypto.Signature{Type: crypto.SigTypeBLS}}
+	landedMsg := types.Message{To: t0123, From: t0123, Nonce: 2}
+	droppedMsg := types.Message{To: t0123, From: t0123, Nonce: 3}
+	for i, msg := range []cid.Cid{pendingMsg.Cid(), landedMsg.Cid(), droppedMsg.Cid()} {
+		require.NoError(t, inFlightStore.SaveInFlight(ctx, pipeline.InFlightPreCommit{
+			Msg:     msg,
+			Sectors: []abi.SectorNumber{abi.SectorNumber(i + 1)},
+			Sent:    cbg.CborTime(time.Now()),
+		}))
+	}
+
+	tcs := map[string]struct {
+		cfg     func() (sealiface.Config, error)
+		cutoffs pipeline.CutoffStrategy
+		actions []action
+
+		// sectors in the store when the batcher starts
+		store  *memStore
+		stored []abi.SectorNumber
+
+		// sets expectations for the calls made while the store is restored
+		restore func(t *testing.T, s *mocks.MockPreCommitBatcherApi)
+
+		// collects the results passed to the submit callback
+		submitted *submitLog
+	}{
+		"addSingle": {
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+			},
+		},
+		"addTwo-onSubmit": {
+			submitted: batchSubmitted,
+			actions: []action{
+				addSectors(getSectors(2), true),
+				waitPending(2),
+				expectSubmitted(batchSubmitted),
+				flush(getSectors(2)),
+				expectSubmitted(batchSubmitted, getSectors(2)),
+			},
+		},
+		"addTwo-onSubmit-individual": {
+			submitted: singleSubmitted,
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				flushIndividual(getSectors(2), sealiface.IndividualLowBaseFee),
+				expectSubmitted(singleSubmitted, []abi.SectorNumber{0}, []abi.SectorNumber{1}),
+			},
+		},
+		"addSingle-individualReason": {
+			actions: []action{
+				addSector(0, false),
+				addSector(1, false),
+				waitPending(2),
+				flushIndividual([]abi.SectorNumber{0, 1}, sealiface.IndividualLowBaseFee),
+			},
+		},
+		"addTwo-economicLowFee": {
+			cfg: economicCfg,
+			actions: []action{
+				addSector(0, false),
+				addSector(1, false),
+				waitPending(2),
+				flushIndividual([]abi.SectorNumber{0, 1}, sealiface.IndividualNoSavings),
+			},
+		},
+		"addSingle-debugRunState": {
+			actions: []action{
+				addSector(0, true),
+				waitPending(1),
+				flushBlockedRunState([]abi.SectorNumber{0}),
+			},
+		},
+		"addTwo-sendFunc-batch": {
+			actions: []action{
+				addSector(0, true),
+				addSector(1, true),
+				waitPending(2),
+				flushIntercepted([]abi.SectorNumber{0, 1}, false),
+			},
+		},
+		"addTwo-sendFunc-individual": {
+			actions: []action{
+				addSector(0, false),
+				addSector(1, false),
+				waitPending(2),
+				flushIntercepted([]abi.SectorNumber{0, 1}, true),
+			},
+		},
+		"store-saveDelete": {
+			store: savedStore,
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				expectStored(savedStore, 0),
+				landInFlight(false),
+				flush([]abi.SectorNumber{0}),
+				expectStored(savedStore),
+			},
+		},
+		"store-restore": {
+			store:  restoredStore,
+			stored: []abi.SectorNumber{3},
+			restore: func(t *testing.T, s *mocks.MockPreCommitBatcherApi) {
+				s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			},
+			actions: []action{
+				// restored sectors are queued right away
+				waitPendingSectors(3),
+				expectStored(restoredStore, 3),
+				// adding the sector again doesn't queue a second copy
+				addSector(3, true),
+				waitPendingSectors(3),
+				landInFlight(false),
+				flush([]abi.SectorNumber{3}),
+				expectStored(restoredStore),
+			},
+		},
+		"store-restoreRemoved": {
+			store:  removedStore,
+			stored: []abi.SectorNumber{3, 4},
+			restore: func(t *testing.T, s *mocks.MockPreCommitBatcherApi) {
+				s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+			},
+			actions: []action{
+				removeRestored(4),
+				waitPendingSectors(3),
+				expectStored(removedStore, 3),
+			},
+		},
+		"store-addSent": {
+			store: sentStore,
+			actions: []action{
+				addSector(0, true),
+				waitPending(1),
+				landInFlight(false),
+				flush([]abi.SectorNumber{0}),
+				expectStoredInFlight(sentStore, dummySmsg.Cid()),
+				addSentPending(0),
+			},
+		},
+		"store-inFlightLanded": {
+			store: landedStore,
+			actions: []action{
+				addSector(0, true),
+				waitPending(1),
+				landInFlight(true),
+				flush([]abi.SectorNumber{0}),
+				expectInFlightDeleted(landedStore),
+				expectStored(landedStore),
+			},
+		},
+		"store-inFlight": {
+			store: inFlightStore,
+			restore: func(t *testing.T, s *mocks.MockPreCommitBatcherApi) {
+				s.EXPECT().ChainHead(gomock.Any()).Return(makeBFTs(t, big.NewInt(10001), 1), nil)
+				s.EXPECT().MpoolPending(gomock.Any(), gomock.Any()).Return([]*types.SignedMessage{pendingMsg}, nil)
+				s.EXPECT().StateSearchMsg(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+					func(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error) {
+						if msg == landedMsg.Cid() {
+							return &api.MsgLookup{Message: msg, Height: 10}, nil
+						}
+						return nil, nil
+					}).Times(2)
+				expectLanding(s, false)
+			},
+			actions: []action{
+				// only the message still in the mpool is kept
+				expectStoredInFlight(inFlightStore, pendingMsg.Cid()),
+				addInFlight(1, pendingMsg.Cid()),
+				addInFlight(2, landedMsg.Cid()),
+				// the sector of the dropped message is sent again
+				addSector(3, false),
+				waitPendingSectors(3),
+				flush([]abi.SectorNumber{3}),
+			},
+		},
+		"addSingle-customMethod": {
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flushMethod([]abi.SectorNumber{0}, 1234),
+			},
+		},
+		"addSingle-cutoffMargin": {
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				// ticket at epoch 0, head at epoch 1
+				expectMargin(0, policy.MaxPreCommitRandomnessLookback-1),
+				flush([]abi.SectorNumber{0}),
+				// still known after the sector was sent
+				expectMargin(0, policy.MaxPreCommitRandomnessLookback-1),
+				expectMargin(1, 0),
+			},
+		},
+		"addTwo": {
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				flush(getSectors(2)),
+			},
+		},
+		"simulate": {
+			actions: []action{
+				simulate(0, 10001, false),
+				simulate(0, 9999, true),
+			},
+		},
+		"addTwo-timing": {
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				expectTiming(2, 10001, false),
+				expectTiming(2, 9999, true),
+				flush(getSectors(2)),
+			},
+		},
+		"addTwo-timingDiagnoseReverts": {
+			cfg: diagnoseCfg,
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				// above the base fee threshold, but sent one by one
+				expectTiming(2, 10001, true),
+				forceIndividual(getSectors(2)),
+			},
+		},
+		"addMax": {
+			actions: []action{
+				expectSend(getSectors(maxBatch)),
+				addSectors(getSectors(maxBatch), true),
+			},
+		},
+		"addAboveActorLimit": {
+			cfg: aboveActorLimitCfg,
+			actions: []action{
+				// the batch is split at the actor limit
+				expectSendSizes(maxBatch, 1),
+				addSectors(getSectors(maxBatch+1), true),
+			},
+		},
+		"addMax-belowBaseFee": {
+			actions: []action{
+				expectSendsSingle(getSectors(maxBatch)),
+				addSectors(getSectors(maxBatch), false),
+			},
+		},
+		"addMax-diagnoseReverts": {
+			// above the base fee threshold, but still sent one by one
+			cfg: diagnoseCfg,
+			actions: []action{
+				expectSendsSingleAt(getSectors(maxBatch), big.NewInt(10001)),
+				addSectors(getSectors(maxBatch), true),
+			},
+		},
+		"zeroDeposit-batch": {
+			actions: []action{
+				flushDeposit(0, big.Zero(), false),
+			},
+		},
+		"zeroDeposit-individual": {
+			actions: []action{
+				flushDeposit(0, big.Zero(), true),
+			},
+		},
+		"pinnedTipset-batch": {
+			cfg: collateralCfg,
+			actions: []action{
+				flushPinned(0, false),
+			},
+		},
+		"pinnedTipset-individual": {
+			cfg: collateralCfg,
+			actions: []action{
+				flushPinned(0, true),
+			},
+		},
+		"nilDeposit-batch": {
+			actions: []action{
+				flushDeposit(0, abi.TokenAmount{}, false),
+			},
+		},
+		"addrFunds-deposit": {
+			actions: []action{
+				flushDeposit(0, big.NewInt(1000), false),
+				expectAddrFunds(big.NewInt(1000), false),
+			},
+		},
+		"addrFunds-feeCoverage": {
+			cfg: feeCoverageCfg,
+			actions: []action{
+				flushDeposit(0, big.NewInt(1000), false),
+				expectAddrFunds(big.NewInt(1000), true),
+			},
+		},
+		"addSingle-underfundedSender": {
+			cfg: verifyBalanceCfg,
+			actions: []action{
+				addFailing(0, "needs"),
+				waitPending(1),
+				flushUnderfunded([]abi.SectorNumber{0}, big.NewInt(10)),
+			},
+		},
+		"addSingle-confirmed": {
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				waitConfirmed(0),
+			},
+		},
+		"addSingle-landed": {
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				waitLanded(0, 2),
+			},
+		},
+		"addSingle-confirmedSearch": {
+			cfg: confirmSearchCfg,
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				waitConfirmed(0),
+			},
+		},
+		"addSingle-confirmedWait": {
+			cfg: confirmWaitCfg,
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				waitConfirmedWait(0),
+			},
+		},
+		"addTwo-approvalDenied": {
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				flushDenied(getSectors(2), nil),
+				flush(getSectors(2)),
+			},
+		},
+		"addTwo-approvalFailed": {
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				flushDenied(getSectors(2), xerrors.New("approval service unavailable")),
+				flush(getSectors(2)),
+			},
+		},
+		"addTwo-sendRetried": {
+			cfg: sendRetryCfg,
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				flushRetried(getSectors(2), 2),
+			},
+		},
+		"addSingle-sendRetryRemoved": {
+			cfg: slowRetryCfg,
+			actions: []action{
+				flushRetryRemoved(0),
+				waitPending(0),
+			},
+		},
+		"addTwo-sendErrorNotRetried": {
+			cfg: sendRetryCfg,
+			actions: []action{
+				flushSendError(getSectors(2)),
+				waitPending(0),
+			},
+		},
+		"addTwo-sendRetriesExhausted": {
+			cfg: sendRetryCfg,
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				// the sectors stay queued, and are sent by the next attempt
+				flushSendFails(2, 3),
+				flush(getSectors(2)),
+			},
+		},
+		"addSingle-mockClock": {
+			actions: []action{
+				sendOnMockClock(0),
+			},
+		},
+		"addSingle-reconfigure": {
+			cfg: reconfigurableCfg,
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				// a shorter wait sends the sector without a flush
+				expectSend([]abi.SectorNumber{0}),
+				reconfigure(100 * time.Millisecond),
+				waitPendingSectors(),
+			},
+		},
+		"addTwo-forceIndividual": {
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				forceIndividual(getSectors(2)),
+				waitPendingSectors(),
+			},
+		},
+		"addTwo-removeOne": {
+			actions: []action{
+				addSector(0, false),
+				addRemoved(1),
+				waitPendingSectors(0),
+				// the removed sector isn't sent
+				flush([]abi.SectorNumber{0}),
+			},
+		},
+		"addThree-oneInvalid": {
+			actions: []action{
+				addSector(0, false),
+				addInvalid(1),
+				addSector(2, false),
+				waitPendingSectors(0, 1, 2),
+				// the valid sectors are still sent
+				flush([]abi.SectorNumber{0, 2}),
+			},
+		},
+		"addSingle-confirmationSucceeded": {
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				waitConfirmation(0, exitcode.Ok, pipeline.PreCommitSucceeded),
+			},
+		},
+		"addSingle-confirmationReverted": {
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				waitConfirmation(0, exitcode.ErrIllegalArgument, pipeline.PreCommitReverted),
+			},
+		},
+		"addSingle-confirmedReverted": {
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				waitConfirmedReverted(0),
+			},
+		},
+		"addSingle-confirmationNotIncluded": {
+			cfg: confirmTimeoutCfg,
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				waitConfirmationNotIncluded(0),
+			},
+		},
+		"addSingle-confirmTimeout": {
+			cfg: confirmTimeoutCfg,
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				waitConfirmTimeout(0),
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+			},
+		},
+		"addTwo-stats": {
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				flush(getSectors(2)),
+				checkStats(1, 2, 2),
+				resetStats(),
+				addSector(2, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{2}),
+				checkStats(1, 1, 3),
+			},
+		},
+		"addSingle-configRetry": {
+			cfg: flakyCfg,
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+			},
+		},
+		"addTwo-assembleUnsigned": {
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				assembleUnsigned(getSectors(2)),
+				// the queue is left as it was
+				waitPending(2),
+				flush(getSectors(2)),
+			},
+		},
+		"addSingle-customCutoff": {
+			// the strategy puts the cutoff within PreCommitBatchSlack, so the sector is sent right away
+			cutoffs: fixedCutoff(time.Hour),
+			actions: []action{
+				expectSend([]abi.SectorNumber{0}),
+				addSector(0, true),
+				waitPending(0),
+			},
+		},
+		"emergencySends": {
+			// every sector is queued within PreCommitBatchSlack of its cutoff
+			cfg:     emergencyCfg,
+			cutoffs: fixedCutoff(time.Hour),
+			actions: []action{
+				expectSend([]abi.SectorNumber{0}),
+				addSector(0, true),
+				waitPending(0),
+				expectSend([]abi.SectorNumber{1}),
+				addSector(1, true),
+				waitPending(0),
+				expectSend([]abi.SectorNumber{2}),
+				addSector(2, true),
+				waitPending(0),
+				expectSend([]abi.SectorNumber{3}),
+				addSector(3, true),
+				waitPending(0),
+				expectEmergencySends(4),
+			},
+		},
+		"addSingle-forcedByCutoff": {
+			cutoffs: fixedCutoff(time.Hour),
+			actions: []action{
+				expectSend([]abi.SectorNumber{0}),
+				addSectorCutoffForced(0, 1+abi.ChainEpoch(time.Hour/(time.Duration(build.BlockDelaySecs)*time.Second))),
+				waitPending(0),
+			},
+		},
+		"addSingle-recomputeCutoffs": {
+			// the cutoff is four hours out, past the slack, when the sector is added
+			cutoffs: epochCutoff(reorgCutoffEpoch),
+			actions: []action{
+				addSector(0, true),
+				waitPending(1),
+				// after a reorg the head is much closer to the cutoff, the sector becomes urgent
+				recomputeCutoffsSend(reorgCutoffEpoch-10, []abi.SectorNumber{0}),
+				waitPending(0),
+			},
+		},
+		"addTwo-recomputeCutoffsFails": {
+			cutoffs: recomputeFailCutoff{epoch: reorgCutoffEpoch, sector: 1},
+			actions: []action{
+				addSector(0, true),
+				addSector(1, true),
+				waitPending(2),
+				// the sector whose cutoff can't be recomputed is sent right away
+				recomputeCutoffsSend(2, []abi.SectorNumber{0, 1}),
+				waitPending(0),
+			},
+		},
+		"deadlineHints-grouped": {
+			cfg: deadlineGroupCfg,
+			actions: []action{
+				expectChainAnyTimes(),
+				queueSectorHint(0, 1),
+				waitPending(1),
+				queueSectorHint(1, 2),
+				waitPending(2),
+				queueSectorHint(2, 1),
+				waitPending(3),
+				// the sector for the other deadline stays queued
+				flushHinted([]abi.SectorNumber{0, 2}, 1),
+				waitPending(1),
+				flushHinted([]abi.SectorNumber{1}, 2),
+				waitPending(0),
+			},
+		},
+		"queuedDeposit-shortfall": {
+			actions: []action{
+				expectChainAnyTimes(),
+				queueSectorDeposit(0, types.FromFil(1)),
+				waitPending(1),
+				queueSectorDeposit(1, types.FromFil(2)),
+				waitPending(2),
+				checkFunds(types.FromFil(3)),
+				drain(getSectors(2)),
+			},
+		},
+		"sendWindow-inside": {
+			// the test head is at height 1
+			cfg: sendWindowCfg(95),
+			actions: []action{
+				expectSend([]abi.SectorNumber{0}),
+				addSector(0, true),
+				waitPending(0),
+			},
+		},
+		"sendWindow-outside": {
+			cfg: sendWindowCfg(50),
+			actions: []action{
+				expectChainAnyTimes(),
+				queueSector(0, 0),
+				waitPending(1),
+				// the batch is full, but the window is closed
+				sleep(100 * time.Millisecond),
+				waitPending(1),
+				// explicit flushes ignore the window
+				drain([]abi.SectorNumber{0}),
+			},
+		},
+		"upgradeGuard-distant": {
+			// the test head is at height 1
+			cfg: upgradeGuardCfg,
+			actions: []action{
+				expectUpgradeAt(50),
+				expectSend([]abi.SectorNumber{0}),
+				addSector(0, true),
+				waitPending(0),
+			},
+		},
+		"upgradeGuard-imminent": {
+			cfg: upgradeGuardCfg,
+			actions: []action{
+				expectUpgradeAt(5),
+				expectChainAnyTimes(),
+				queueSector(0, 0),
+				waitPending(1),
+				// the batch is full, but the upgrade is close
+				sleep(100 * time.Millisecond),
+				waitPending(1),
+				// explicit flushes ignore the upgrade
+				drain([]abi.SectorNumber{0}),
+			},
+		},
+		"addTwo-manual": {
+			cfg: manualCfg,
+			actions: []action{
+				addSectors(getSectors(2), false),
+				waitPending(2),
+				// PreCommitBatchWait elapses many times over, nothing should get sent
+				sleep(200 * time.Millisecond),
+				waitPending(2),
+				flush(getSectors(2)),
+			},
+		},
+		"addShortExpiration": {
+			actions: []action{
+				addSectorShortExpiration(0),
+				waitPending(0),
+				addSector(1, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{1}),
+			},
+		},
+		"addStaleRandomness": {
+			actions: []action{
+				addSector(0, false),
+				addSectorStaleRandomness(1),
+				waitPending(2),
+				flushDropping([]abi.SectorNumber{0}, 1),
+			},
+		},
+		"addTwo-allocated": {
+			cfg: allocationCfg,
+			actions: []action{
+				addSector(0, false),
+				addFailing(1, "already allocated on chain"),
+				waitPending(2),
+				expectAllocated(1),
+				// the colliding sector fails without breaking the batch
+				flushDropping([]abi.SectorNumber{0}, 1),
+			},
+		},
+		"addThree-pendingInMpool": {
+			cfg: mpoolCfg,
+			actions: []action{
+				addSectors(getSectors(3), true),
+				waitPending(3),
+				flushPendingInMpool([]abi.SectorNumber{0, 2}, []abi.SectorNumber{1}, false),
+			},
+		},
+		"addThree-pendingInMpoolSendFails": {
+			cfg: mpoolCfg,
+			actions: []action{
+				addFailingInBatch(0, "SysErrInsufficientFunds"),
+				addSector(1, true),
+				addFailingInBatch(2, "SysErrInsufficientFunds"),
+				waitPending(3),
+				flushPendingInMpool([]abi.SectorNumber{0, 2}, []abi.SectorNumber{1}, true),
+			},
+		},
+		"addLowDeposit": {
+			cfg: minDepositCfg,
+			actions: []action{
+				addSectorLowDeposit(0),
+				waitPending(0),
+			},
+		},
+		"addFutureTicket": {
+			actions: []action{
+				addSectorFutureTicket(0),
+				waitPending(0),
+			},
+		},
+		"drain-mixedUrgency": {
+			cfg: laggingCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				queueSector(0, 0),
+				waitPending(1),
+				queueSector(1, -500),
+				waitPending(2),
+				queueSector(2, -1000),
+				waitPending(3),
+				// most urgent sectors go in the first message
+				drain([]abi.SectorNumber{2, 1}, []abi.SectorNumber{0}),
+			},
+		},
+		"drain-agedSectorNotStarved": {
+			cfg: agingCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				queueSector(0, 0),
+				waitPending(1),
+				sleep(200 * time.Millisecond),
+				// sectors with earlier cutoffs keep arriving
+				queueSector(1, -500),
+				waitPending(2),
+				queueSector(2, -1000),
+				waitPending(3),
+				// sector 0 waited past PreCommitBatchMaxSectorWait, so it goes in the first message
+				drain([]abi.SectorNumber{0, 2}, []abi.SectorNumber{1}),
+			},
+		},
+		"flush-batchFull": {
+			cfg: laggingCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				queueSector(0, 0),
+				waitPending(1),
+				queueSector(1, -500),
+				waitPending(2),
+				queueSector(2, -1000),
+				waitPending(3),
+				// the least urgent sector doesn't fit and stays queued
+				flushFull([]abi.SectorNumber{2, 1}, 1),
+				waitPendingSectors(0),
+				flushFull([]abi.SectorNumber{0}, 0),
+			},
+		},
+		"flush-batchFullTie": {
+			cfg: laggingCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				queueSector(3, 0),
+				waitPending(1),
+				queueSector(1, 0),
+				waitPending(2),
+				queueSector(2, 0),
+				waitPending(3),
+				// with equal cutoff epochs the lowest sector numbers go first, regardless of
+				// the order they were queued in
+				flushFull([]abi.SectorNumber{1, 2}, 1),
+				waitPendingSectors(3),
+				flushFull([]abi.SectorNumber{3}, 0),
+			},
+		},
+		"flush-gasCeiling": {
+			cfg: gasCeilingCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				expectGasPerSector(build.BlockGasLimit / 5),
+				queueSector(0, 0),
+				waitPending(1),
+				queueSector(1, 0),
+				waitPending(2),
+				queueSector(2, 0),
+				waitPending(3),
+				queueSector(3, 0),
+				waitPending(4),
+				// four sectors take 80% of the block gas limit, two fit under the ceiling
+				flushSplit([]abi.SectorNumber{0, 1}, 2),
+				waitPendingSectors(2, 3),
+				flushSplit([]abi.SectorNumber{2, 3}, 0),
+			},
+		},
+		"flush-maxBatches": {
+			cfg: flushBatchesCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				queueSector(0, 0),
+				waitPending(1),
+				queueSector(1, -500),
+				waitPending(2),
+				queueSector(2, -1000),
+				waitPending(3),
+				queueSector(3, -1500),
+				waitPending(4),
+				queueSector(4, -2000),
+				waitPending(5),
+				// two batches of the most urgent sectors, the least urgent one waits for the next flush
+				flushBatched([]abi.SectorNumber{4, 3}, []abi.SectorNumber{2, 1}),
+				waitPendingSectors(0),
+				flushFull([]abi.SectorNumber{0}, 0),
+			},
+		},
+		"minBatch-wait": {
+			cfg: minBatchCfg,
+			actions: []action{
+				addSectors(getSectors(2), true),
+				waitPending(2),
+				// the timer fired a few times, but there are too few sectors to send
+				sleep(200 * time.Millisecond),
+				waitPending(2),
+				expectSend(getSectors(3)),
+				addSector(2, true),
+				waitPending(0),
+			},
+		},
+		"minBatch-urgent": {
+			cfg: minBatchCfg,
+			actions: []action{
+				expectSend([]abi.SectorNumber{0}),
+				// the cutoff overrides the minimum
+				addSectorWithTicket(0, true, urgentTicket),
+				waitPending(0),
+			},
+		},
+		"minBatch-flush": {
+			cfg: minBatchCfg,
+			actions: []action{
+				addSector(0, true),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+			},
+		},
+		"auto-multipleBatches": {
+			cfg: smallBatchCfg,
+			actions: []action{
+				expectChainAnyTimes(),
+				pause(time.Hour),
+				queueSector(0, 0),
+				queueSector(1, -500),
+				queueSector(2, -1000),
+				queueSector(3, -1500),
+				queueSector(4, -2000),
+				waitPending(5),
+				// resuming sends the whole queue, in three messages
+				expectBatches(3),
+				resume(),
+				waitPendingSectors(),
+			},
+		},
+		"auto-roundRobinAddresses": {
+			cfg: roundRobinCfg,
+			actions: []action{
+				expectChainAnyTimes(),
+				pause(time.Hour),
+				queueSector(0, 0),
+				queueSector(1, -500),
+				queueSector(2, -1000),
+				queueSector(3, -1500),
+				queueSector(4, -2000),
+				waitPending(5),
+				// the first batch goes from the selected address, the next ones from
+				// the control addresses in turn
+				expectBatchSenders([]address.Address{t01001, t01002}, t0123, t01001, t01002),
+				resume(),
+				waitPendingSectors(),
+			},
+		},
+		"auto-roundRobinWorkerFallback": {
+			cfg: roundRobinCfg,
+			actions: []action{
+				expectChainAnyTimes(),
+				pause(time.Hour),
+				queueSector(0, 0),
+				queueSector(1, -500),
+				queueSector(2, -1000),
+				queueSector(3, -1500),
+				queueSector(4, -2000),
+				waitPending(5),
+				// the control address key isn't in the wallet, the batches after the
+				// first go from the worker
+				expectBatchSendersFrom(t01002, []address.Address{t01001}, []address.Address{t01002}, t0123, t01002, t01002),
+				resume(),
+				waitPendingSectors(),
+			},
+		},
+		"flush-secondBatchFails": {
+			cfg: flushBatchesCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				flushSecondBatchFails(getSectors(4)),
+				waitPendingSectors(),
+			},
+		},
+		"drain-dealValue": {
+			cfg: laggingCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				queueDealSector(0, 1),
+				waitPending(1),
+				queueDealSector(1, 3),
+				waitPending(2),
+				queueDealSector(2, 2),
+				waitPending(3),
+				// with equal cutoffs the most valuable deals go in the first message
+				drain([]abi.SectorNumber{1, 2}, []abi.SectorNumber{0}),
+			},
+		},
+		"boundedQueue-evict": {
+			cfg: boundedQueueCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				queueSectorEvicted(0, 0),
+				waitPending(1),
+				queueSector(1, -500),
+				waitPending(2),
+				// earlier cutoff than sector 0, which is evicted
+				queueSector(2, -1000),
+				waitPendingSectors(1, 2),
+				// later cutoff than everything queued, refused
+				addSectorQueueFull(3, 0),
+				waitPendingSectors(1, 2),
+				drain([]abi.SectorNumber{2, 1}),
+			},
+		},
+		"boundedQueue-block": {
+			cfg: blockingQueueCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				queueSector(0, 0),
+				waitPending(1),
+				// waits for sector 0 to leave the queue
+				queueSector(1, -500),
+				sleep(100 * time.Millisecond),
+				waitPendingSectors(0),
+				flushBatched([]abi.SectorNumber{0}),
+				waitPendingSectors(1),
+				flushBatched([]abi.SectorNumber{1}),
+			},
+		},
+		"addSingle-waiterCap": {
+			cfg: waiterCapCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				addSectorWaiters(0, 10, 3),
+				waitPending(1),
+				drain([]abi.SectorNumber{0}),
+			},
+		},
+		"addSingle-expectedWait": {
+			// cutoff in 5h, minus 3h of slack
+			cutoffs: fixedCutoff(5 * time.Hour),
+			actions: []action{
+				addSectorExpectWait(0, 2*time.Hour-time.Minute, 2*time.Hour),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+			},
+		},
+		"addSingle-expectedWaitMax": {
+			// cutoff well after the 24h batch wait
+			cutoffs: fixedCutoff(48 * time.Hour),
+			actions: []action{
+				addSectorExpectWait(0, 24*time.Hour, 24*time.Hour),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+			},
+		},
+		"addUrgent-paused": {
+			actions: []action{
+				pause(time.Second),
+				addSectorWithTicket(0, true, urgentTicket),
+				waitPending(1),
+				// the sector is past its cutoff slack, but sends are paused
+				sleep(300 * time.Millisecond),
+				waitPending(1),
+				expectSend([]abi.SectorNumber{0}),
+				waitPending(0),
+			},
+		},
+		"addSingle-decisionLog": {
+			actions: []action{
+				logDecisions(),
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				stop(),
+				expectDecisions("batch"),
+			},
+		},
+		"addSingle-telemetry": {
+			actions: []action{
+				exportTelemetry(),
+				addSector(0, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{0}),
+				addSector(1, false),
+				waitPending(1),
+				flush([]abi.SectorNumber{1}),
+				stop(),
+				expectTelemetry([]abi.SectorNumber{0}, []abi.SectorNumber{1}),
+			},
+		},
+		"stop-unblocksWaiters": {
+			actions: []action{
+				addSectorExpectStopped(0),
+				waitPending(1),
+				stop(),
+			},
+		},
+		"stop-unblocksFlush": {
+			actions: []action{
+				flushWhileStopping(10),
+			},
+		},
+		"validator-dropsSector": {
+			actions: []action{
+				addValidators(dropSector(1)),
+				addSector(0, false),
+				addFailing(1, "dropped by test validator"),
+				waitPending(2),
+				flushDropping([]abi.SectorNumber{0}, 1),
+			},
+		},
+		"validator-defaultSealProof": {
+			actions: []action{
+				addSector(0, false),
+				addFailingInfo(pipeline.SectorInfo{
+					SectorNumber: 1,
+					SectorType:   abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+				}, "doesn't match sector seal proof"),
+				waitPending(2),
+				flushDropping([]abi.SectorNumber{0}, 1),
+			},
+		},
+		"flush-tipset": {
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flushTipSet(getSectors(1)),
+			},
+		},
+		"flush-overridesGrace": {
+			cfg: graceCfg,
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flush(getSectors(1)),
+			},
+		},
+		"flush-respectsGrace": {
+			cfg: graceRespectedCfg,
+			actions: []action{
+				addSectorExpectStopped(0),
+				waitPending(1),
+				flushHeld(1),
+				stop(),
+			},
+		},
+		"flush-duringSendWaits": {
+			cfg: laggingCfg,
+			actions: []action{
+				flushDuringSend(false),
+			},
+		},
+		"flush-duringSendMerges": {
+			cfg: mergeFlushCfg,
+			actions: []action{
+				flushDuringSend(true),
+			},
+		},
+		"preview-collateralSource": {
+			cfg: collateralSourceCfg,
+			actions: []action{
+				expectLaggingChainAnyTimes(),
+				queueSector(0, 0),
+				waitPending(1),
+				// the configured collateral source sends, not the selected address
+				previewSender(t01001),
+				waitPendingSectors(),
+			},
+		},
+		"flush-nonce": {
+			actions: []action{
+				addSector(0, false),
+				waitPending(1),
+				flushNonce(getSectors(1), 42),
+			},
+		},
+		"flush-consistentResults": {
+			actions: []action{
+				flushConsistent(getSectors(3)),
+			},
+		},
+		"addUrgentDeal-alone": {
+			cfg: urgentDealAloneCfg,
+			actions: []action{
+				addSectors(getSectors(4), true),
+				waitPending(4),
+				expectSendsSingleAt([]abi.SectorNumber{4}, big.NewInt(10001)),
+				addDealSectorWithTicket(4, urgentTicket),
+				// the committed capacity sectors wait for a fuller batch
+				waitPendingSectors(0, 1, 2, 3),
+				flush(getSectors(4)),
+			},
+		},
+		"addUrgent-hybrid": {
+			cfg: hybridCfg,
+			actions: []action{
+				addSector(0, true),
+				waitPending(1),
+				expectSendHybrid(),
+				addSectorWithTicket(1, true, urgentTicket),
+			},
+		},
+	}
+
+	for name, tc := range tcs {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			// create go mock controller here
+			mockCtrl := gomock.NewController(t)
+			// when test is done, assert expectations on all mock objects.
+			defer mockCtrl.Finish()
+
+			// create them mocks
+			pcapi := mocks.NewMockPreCommitBatcherApi(mockCtrl)
+
+			tcfg := tc.cfg
+			if tcfg == nil {
+				tcfg = cfg
+			}
+
+			var store pipeline.BatcherStore
+			if tc.store != nil {
+				for _, sn := range tc.stored {
+					require.NoError(t, tc.store.Save(ctx, pipeline.QueuedPreCommit{
+						SectorInfo: pipeline.SectorInfo{SectorNumber: sn},
+						Deposit:    big.Zero(),
+						Info: minertypes.SectorPreCommitInfo{
+							SectorNumber: sn,
+							SealedCID:    fakePieceCid(t),
+							Expiration:   policy.GetMaxSectorExpirationExtension(),
+						},
+						Queued: cbg.CborTime(time.Now()),
+					}))
+				}
+				store = tc.store
+			}
+			if tc.restore != nil {
+				tc.restore(t, pcapi)
+			}
+
+			var onSubmit pipeline.SubmittedFunc
+			if tc.submitted != nil {
+				onSubmit = tc.submitted.add
+			}
+
+			pcb, err := pipeline.NewPreCommitBatcher(ctx, t0123, pcapi, as, fc, tcfg, tc.cutoffs, store, onSubmit)
+			require.NoError(t, err)
+
+			var promises []promise
+
+			for _, a := range tc.actions {
+				p := a(t, pcapi, pcb)
+				if p != nil {
+					promises = append(promises, p)
+				}
+			}
+
+			for _, p := range promises {
+				p(t)
+			}
+
+			err = pcb.Stop(ctx)
 			require.NoError(t, err)
 		})
 	}
 }
 
+// makeLaggingBFTs returns a tipset whose timestamp is behind wall-clock time by the
+// given duration
+func makeLaggingBFTs(t *testing.T, basefee abi.TokenAmount, h abi.ChainEpoch, behind time.Duration) *types.TipSet {
+	dummyCid, _ := cid.Parse("bafkqaaa")
+
+	ts, err := types.NewTipSet([]*types.BlockHeader{
+		{
+			Height:    h,
+			Miner:     dummyAddr,
+			Timestamp: uint64(time.Now().Add(-behind).Unix()),
+
+			Parents: []cid.Cid{},
+
+			Ticket: &types.Ticket{VRFProof: []byte{byte(h % 2)}},
+
+			ParentStateRoot:       dummyCid,
+			Messages:              dummyCid,
+			ParentMessageReceipts: dummyCid,
+
+			BlockSig:     &crypto.Signature{Type: crypto.SigTypeBLS},
+			BLSAggregate: &crypto.Signature{Type: crypto.SigTypeBLS},
+
+			ParentBaseFee: basefee,
+		},
+	})
+	require.NoError(t, err)
+
+	return ts
+}
+
+// fixedCutoff is a cutoff strategy which puts the cutoff of every sector at a fixed distance from now
+type fixedCutoff time.Duration
+
+func (c fixedCutoff) PreCommitCutoff(curEpoch abi.ChainEpoch, si pipeline.SectorInfo) (abi.ChainEpoch, error) {
+	return curEpoch + abi.ChainEpoch(time.Duration(c)/(time.Duration(build.BlockDelaySecs)*time.Second)), nil
+}
+
+// epochCutoff is a cutoff strategy which puts the cutoff of every sector at a fixed epoch
+type epochCutoff abi.ChainEpoch
+
+func (c epochCutoff) PreCommitCutoff(curEpoch abi.ChainEpoch, si pipeline.SectorInfo) (abi.ChainEpoch, error) {
+	return abi.ChainEpoch(c), nil
+}
+
+// recomputeFailCutoff is a fixed cutoff, which fails for the given sector once the
+// head moved past the first epoch
+type recomputeFailCutoff struct {
+	epoch  abi.ChainEpoch
+	sector abi.SectorNumber
+}
+
+func (c recomputeFailCutoff) PreCommitCutoff(curEpoch abi.ChainEpoch, si pipeline.SectorInfo) (abi.ChainEpoch, error) {
+	if si.SectorNumber == c.sector && curEpoch > 1 {
+		return 0, xerrors.New("cutoff unavailable")
+	}
+	return c.epoch, nil
+}
+
+// memStore is an in-memory BatcherStore
+type memStore struct {
+	lk  sync.Mutex
+	qps map[abi.SectorNumber]pipeline.QueuedPreCommit
+	ips map[cid.Cid]pipeline.InFlightPreCommit
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		qps: map[abi.SectorNumber]pipeline.QueuedPreCommit{},
+		ips: map[cid.Cid]pipeline.InFlightPreCommit{},
+	}
+}
+
+func (m *memStore) Save(ctx context.Context, qp pipeline.QueuedPreCommit) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.qps[qp.SectorInfo.SectorNumber] = qp
+	return nil
+}
+
+func (m *memStore) Load(ctx context.Context) ([]pipeline.QueuedPreCommit, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	var out []pipeline.QueuedPreCommit
+	for _, qp := range m.qps {
+		out = append(out, qp)
+	}
+	return out, nil
+}
+
+func (m *memStore) Delete(ctx context.Context, sn abi.SectorNumber) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	delete(m.qps, sn)
+	return nil
+}
+
+func (m *memStore) SaveInFlight(ctx context.Context, ip pipeline.InFlightPreCommit) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.ips[ip.Msg] = ip
+	return nil
+}
+
+func (m *memStore) LoadInFlight(ctx context.Context) ([]pipeline.InFlightPreCommit, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	var out []pipeline.InFlightPreCommit
+	for _, ip := range m.ips {
+		out = append(out, ip)
+	}
+	return out, nil
+}
+
+func (m *memStore) DeleteInFlight(ctx context.Context, msg cid.Cid) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	delete(m.ips, msg)
+	return nil
+}
+
+// inFlightMsgs returns the stored in-flight messages
+func (m *memStore) inFlightMsgs() []cid.Cid {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	var msgs []cid.Cid
+	for msg := range m.ips {
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// sectors returns the stored sector numbers in order
+func (m *memStore) sectors() []abi.SectorNumber {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	var sns []abi.SectorNumber
+	for sn := range m.qps {
+		sns = append(sns, sn)
+	}
+	sort.Slice(sns, func(i, j int) bool {
+		return sns[i] < sns[j]
+	})
+	return sns
+}
+
+// submitLog collects the results passed to the submit callback
+type submitLog struct {
+	lk  sync.Mutex
+	res []sealiface.PreCommitBatchRes
+}
+
+func (l *submitLog) add(r sealiface.PreCommitBatchRes) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	l.res = append(l.res, r)
+}
+
+func (l *submitLog) results() []sealiface.PreCommitBatchRes {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	return append([]sealiface.PreCommitBatchRes{}, l.res...)
+}
+
+type sendRecord struct {
+	d   pipeline.PreCommitDecision
+	res []sealiface.PreCommitBatchRes
+}
+
+// captureSink is a telemetry sink which keeps the records it receives
+type captureSink struct {
+	lk   sync.Mutex
+	recs []sendRecord
+}
+
+func (c *captureSink) RecordSend(d pipeline.PreCommitDecision, res []sealiface.PreCommitBatchRes) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.recs = append(c.recs, sendRecord{d: d, res: res})
+}
+
+func (c *captureSink) reset() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.recs = nil
+}
+
+func (c *captureSink) get() []sendRecord {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	return append([]sendRecord(nil), c.recs...)
+}
+
+// customMethod is a batch method strategy which sends the usual batch params with
+// a different method number
+type customMethod abi.MethodNum
+
+func (m customMethod) BatchMethod(cfg sealiface.Config, nv network.Version, sectors []minertypes.SectorPreCommitInfo) (abi.MethodNum, []byte, error) {
+	params := minertypes.PreCommitSectorBatchParams{Sectors: sectors}
+
+	enc := new(bytes.Buffer)
+	if err := params.MarshalCBOR(enc); err != nil {
+		return 0, nil, err
+	}
+
+	return abi.MethodNum(m), enc.Bytes(), nil
+}
+
+// dropSector is a batch validator which drops one sector
+type dropSector abi.SectorNumber
+
+func (d dropSector) ValidateBatch(ctx context.Context, v pipeline.BatchValidation, sectors []pipeline.QueuedPreCommit) (map[abi.SectorNumber]string, error) {
+	drop := map[abi.SectorNumber]string{}
+	for _, qp := range sectors {
+		if qp.Info.SectorNumber == abi.SectorNumber(d) {
+			drop[qp.Info.SectorNumber] = "dropped by test validator"
+		}
+	}
+	return drop, nil
+}
+
 type funMatcher func(interface{}) bool
 
 func (funMatcher) Matches(interface{}) bool {
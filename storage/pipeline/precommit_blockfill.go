@@ -0,0 +1,108 @@
+package sealing
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+type blockMessagesAPI interface {
+	ChainGetBlockMessages(ctx context.Context, blockCid cid.Cid) (*api.BlockMessages, error)
+}
+
+// blockGasUsed returns the average gas limit of messages included in the blocks
+// of the tipset, which is how much of the block gas limit they took up
+func blockGasUsed(ctx context.Context, a blockMessagesAPI, ts *types.TipSet) (int64, error) {
+	blks := ts.Cids()
+	if len(blks) == 0 {
+		return 0, nil
+	}
+
+	var total int64
+	for _, blk := range blks {
+		msgs, err := a.ChainGetBlockMessages(ctx, blk)
+		if err != nil {
+			return 0, xerrors.Errorf("getting messages of block %s: %w", blk, err)
+		}
+
+		for _, m := range msgs.BlsMessages {
+			total += m.GasLimit
+		}
+		for _, m := range msgs.SecpkMessages {
+			total += m.Message.GasLimit
+		}
+	}
+
+	return total / int64(len(blks)), nil
+}
+
+// fitBlockGas estimates the gas of an assembled batch and, while it's above
+// PreCommitBatchMaxGasFraction of the block gas limit, reassembles it with fewer
+// sectors, assuming gas scales with the number of sectors. Sectors taken out of
+// the batch are deferred, so they stay queued for a later batch.
+func (b *PreCommitBatcher) fitBlockGas(cfg sealiface.Config, entries map[abi.SectorNumber]*preCommitEntry, res sealiface.PreCommitBatchRes, bm *preCommitBatchMsg, tsk types.TipSetKey, bf abi.TokenAmount, nv network.Version) (sealiface.PreCommitBatchRes, *preCommitBatchMsg, error) {
+	if cfg.PreCommitBatchMaxGasFraction <= 0 {
+		return res, bm, nil
+	}
+
+	ceiling := int64(float64(build.BlockGasLimit) * cfg.PreCommitBatchMaxGasFraction)
+	deferred := bm.deferred
+	failed := res.FailedSectors
+
+	var split []abi.SectorNumber
+	for {
+		msg, err := b.api.GasEstimateMessageGas(b.mctx, &bm.msg, &api.MessageSendSpec{MaxFee: bm.maxFee}, tsk)
+		if err != nil {
+			return res, nil, xerrors.Errorf("estimating batch message gas: %w", err)
+		}
+
+		n := len(res.Sectors)
+		if msg.GasLimit <= ceiling {
+			break
+		}
+		if n <= 1 {
+			log.Warnw("precommit message of a single sector is above the batch gas ceiling, sending it anyway", "sectors", res.Sectors, "gas", msg.GasLimit, "ceiling", ceiling)
+			break
+		}
+
+		keep := int(int64(n) * ceiling / msg.GasLimit)
+		if keep >= n {
+			keep = n - 1
+		}
+		if keep < 1 {
+			keep = 1
+		}
+
+		log.Infow("precommit batch above the gas ceiling, splitting it", "sectors", n, "keep", keep, "gas", msg.GasLimit, "ceiling", ceiling)
+
+		// the least urgent sectors are at the end
+		split = append(append([]abi.SectorNumber{}, res.Sectors[keep:]...), split...)
+
+		kept := make(map[abi.SectorNumber]*preCommitEntry, keep)
+		for _, sn := range res.Sectors[:keep] {
+			kept[sn] = entries[sn]
+		}
+
+		res, bm, err = b.assembleBatch(cfg, kept, tsk, bf, nv)
+		res.FailedSectors = failed
+		if err != nil {
+			return res, nil, err
+		}
+	}
+
+	if len(split) > 0 {
+		bm.deferred = append(split, deferred...)
+		res.DeferredFull = len(bm.deferred)
+	}
+
+	return res, bm, nil
+}
@@ -0,0 +1,67 @@
+package sealing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type fakeBlockMessages map[cid.Cid]*api.BlockMessages
+
+func (f fakeBlockMessages) ChainGetBlockMessages(ctx context.Context, blockCid cid.Cid) (*api.BlockMessages, error) {
+	return f[blockCid], nil
+}
+
+func TestBlockGasUsed(t *testing.T) {
+	dummyCid, _ := cid.Parse("bafkqaaa")
+
+	var blks []*types.BlockHeader
+	for i := 0; i < 2; i++ {
+		maddr, err := address.NewIDAddress(uint64(1000 + i))
+		require.NoError(t, err)
+
+		blks = append(blks, &types.BlockHeader{
+			Height: 10,
+			Miner:  maddr,
+
+			Parents: []cid.Cid{},
+
+			Ticket: &types.Ticket{VRFProof: []byte{byte(i)}},
+
+			ParentStateRoot:       dummyCid,
+			Messages:              dummyCid,
+			ParentMessageReceipts: dummyCid,
+
+			BlockSig:     &crypto.Signature{Type: crypto.SigTypeBLS},
+			BLSAggregate: &crypto.Signature{Type: crypto.SigTypeBLS},
+
+			ParentBaseFee: big.NewInt(100),
+		})
+	}
+
+	ts, err := types.NewTipSet(blks)
+	require.NoError(t, err)
+
+	fill := fakeBlockMessages{
+		ts.Cids()[0]: {
+			BlsMessages:   []*types.Message{{GasLimit: 3_000_000_000}, {GasLimit: 2_000_000_000}},
+			SecpkMessages: []*types.SignedMessage{{Message: types.Message{GasLimit: 1_000_000_000}}},
+		},
+		ts.Cids()[1]: {
+			BlsMessages: []*types.Message{{GasLimit: 2_000_000_000}},
+		},
+	}
+
+	used, err := blockGasUsed(context.Background(), fill, ts)
+	require.NoError(t, err)
+	require.Equal(t, int64(4_000_000_000), used)
+}
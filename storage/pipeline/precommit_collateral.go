@@ -0,0 +1,148 @@
+package sealing
+
+import (
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// collateral source name of the miner available balance in PreCommitCollateralSources
+const minerCollateralSource = "miner"
+
+// collateralSource is one entry of PreCommitCollateralSources, the miner available
+// balance when miner is set, an address otherwise
+type collateralSource struct {
+	miner bool
+	addr  address.Address
+}
+
+func parseCollateralSources(srcs []string) ([]collateralSource, error) {
+	out := make([]collateralSource, 0, len(srcs))
+	for _, s := range srcs {
+		if s == minerCollateralSource {
+			out = append(out, collateralSource{miner: true})
+			continue
+		}
+
+		a, err := address.NewFromString(s)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing collateral source %q: %w", s, err)
+		}
+		out = append(out, collateralSource{addr: a})
+	}
+
+	return out, nil
+}
+
+// collateralPlan is how the funds needed by a precommit message are split between
+// the miner available balance and the sending address
+type collateralPlan struct {
+	// sending address, undefined when no address source is listed
+	from address.Address
+
+	// sent with the message from the sending address
+	value abi.TokenAmount
+	// drawn from the miner available balance
+	fromMiner abi.TokenAmount
+}
+
+// planCollateral walks the sources in order, looking for the first address which
+// can cover need, with the miner balance covering the rest when it's listed. The
+// miner balance is drawn first when it's listed before the address, otherwise the
+// address pays what it can after keeping fee for gas. minerAvail is only used when
+// the miner balance is listed.
+func planCollateral(srcs []collateralSource, need, fee, minerAvail abi.TokenAmount, balance func(address.Address) (abi.TokenAmount, error)) (collateralPlan, error) {
+	minerAt := -1
+	for i, src := range srcs {
+		if src.miner {
+			minerAt = i
+			break
+		}
+	}
+	if minerAt < 0 {
+		minerAvail = big.Zero()
+	}
+
+	// the miner balance first, anything left from the address
+	minerFirst := func(from address.Address) collateralPlan {
+		fromMiner := big.Min(minerAvail, need)
+		return collateralPlan{from: from, value: big.Sub(need, fromMiner), fromMiner: fromMiner}
+	}
+
+	hasAddr := false
+	for i, src := range srcs {
+		if src.miner {
+			continue
+		}
+		hasAddr = true
+
+		bal, err := balance(src.addr)
+		if err != nil {
+			return collateralPlan{}, xerrors.Errorf("getting balance of collateral source %s: %w", src.addr, err)
+		}
+
+		var plan collateralPlan
+		if minerAt >= 0 && minerAt < i {
+			plan = minerFirst(src.addr)
+		} else {
+			value := big.Max(big.Min(big.Sub(bal, fee), need), big.Zero())
+			plan = collateralPlan{from: src.addr, value: value, fromMiner: big.Sub(need, value)}
+		}
+
+		if plan.fromMiner.LessThanEqual(minerAvail) && big.Add(plan.value, fee).LessThanEqual(bal) {
+			return plan, nil
+		}
+
+		log.Debugw("collateral source can't cover precommit", "address", src.addr, "balance", types.FIL(bal), "need", types.FIL(need), "fromMiner", types.FIL(plan.fromMiner))
+	}
+
+	if !hasAddr {
+		return minerFirst(address.Undef), nil
+	}
+
+	return collateralPlan{}, xerrors.Errorf("collateral sources can't cover %s, with %s available in the miner balance", types.FIL(need), types.FIL(minerAvail))
+}
+
+// usesMinerCollateral returns whether the miner available balance is a listed
+// collateral source
+func usesMinerCollateral(cfg sealiface.Config) bool {
+	for _, s := range cfg.PreCommitCollateralSources {
+		if s == minerCollateralSource {
+			return true
+		}
+	}
+	return false
+}
+
+// minerAvailable returns the miner available balance, less AvailableBalanceBuffer
+func (b *PreCommitBatcher) minerAvailable(cfg sealiface.Config, tsk types.TipSetKey) (abi.TokenAmount, error) {
+	avail, err := b.api.StateMinerAvailableBalance(b.mctx, b.maddr, tsk)
+	if err != nil {
+		return big.Zero(), xerrors.Errorf("getting available miner balance: %w", err)
+	}
+
+	avail = big.Sub(avail, cfg.AvailableBalanceBuffer)
+	if avail.LessThan(big.Zero()) {
+		avail = big.Zero()
+	}
+
+	return avail, nil
+}
+
+// planSourcedCollateral plans paying need from PreCommitCollateralSources, with
+// minerAvail left in the miner balance
+func (b *PreCommitBatcher) planSourcedCollateral(cfg sealiface.Config, need, fee, minerAvail abi.TokenAmount) (collateralPlan, error) {
+	srcs, err := parseCollateralSources(cfg.PreCommitCollateralSources)
+	if err != nil {
+		return collateralPlan{}, err
+	}
+
+	return planCollateral(srcs, need, fee, minerAvail, func(a address.Address) (abi.TokenAmount, error) {
+		return b.api.WalletBalance(b.mctx, a)
+	})
+}
@@ -0,0 +1,87 @@
+package sealing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+)
+
+func TestPlanCollateral(t *testing.T) {
+	addrA, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	addrB, err := address.NewIDAddress(1002)
+	require.NoError(t, err)
+
+	balances := map[address.Address]abi.TokenAmount{
+		addrA: big.NewInt(50),
+		addrB: big.NewInt(100),
+	}
+	balance := func(a address.Address) (abi.TokenAmount, error) {
+		b, ok := balances[a]
+		if !ok {
+			return big.Zero(), xerrors.Errorf("unknown address %s", a)
+		}
+		return b, nil
+	}
+
+	need, fee := big.NewInt(100), big.NewInt(5)
+
+	srcs, err := parseCollateralSources([]string{"miner", addrA.String(), addrB.String()})
+	require.NoError(t, err)
+
+	// the miner balance is partially depleted, A can't cover the remaining 70 plus
+	// fees, so B sends
+	plan, err := planCollateral(srcs, need, fee, big.NewInt(30), balance)
+	require.NoError(t, err)
+	require.Equal(t, addrB, plan.from)
+	require.True(t, big.NewInt(70).Equals(plan.value), plan.value)
+	require.True(t, big.NewInt(30).Equals(plan.fromMiner), plan.fromMiner)
+
+	// with more in the miner balance A is enough
+	plan, err = planCollateral(srcs, need, fee, big.NewInt(60), balance)
+	require.NoError(t, err)
+	require.Equal(t, addrA, plan.from)
+	require.True(t, big.NewInt(40).Equals(plan.value), plan.value)
+
+	// A pays what it can first, the miner balance covers the rest
+	srcs, err = parseCollateralSources([]string{addrA.String(), "miner"})
+	require.NoError(t, err)
+
+	plan, err = planCollateral(srcs, need, fee, big.NewInt(60), balance)
+	require.NoError(t, err)
+	require.Equal(t, addrA, plan.from)
+	require.True(t, big.NewInt(45).Equals(plan.value), plan.value)
+	require.True(t, big.NewInt(55).Equals(plan.fromMiner), plan.fromMiner)
+
+	_, err = planCollateral(srcs, need, fee, big.NewInt(30), balance)
+	require.Error(t, err)
+
+	// without the miner balance the address has to cover everything
+	srcs, err = parseCollateralSources([]string{addrA.String(), addrB.String()})
+	require.NoError(t, err)
+
+	_, err = planCollateral(srcs, big.NewInt(100), fee, big.NewInt(1000), balance)
+	require.Error(t, err)
+
+	plan, err = planCollateral(srcs, big.NewInt(95), fee, big.NewInt(1000), balance)
+	require.NoError(t, err)
+	require.Equal(t, addrB, plan.from)
+	require.True(t, big.Zero().Equals(plan.fromMiner), plan.fromMiner)
+
+	// only the miner balance, the sending address is selected as usual
+	srcs, err = parseCollateralSources([]string{"miner"})
+	require.NoError(t, err)
+
+	plan, err = planCollateral(srcs, need, fee, big.NewInt(30), balance)
+	require.NoError(t, err)
+	require.Equal(t, address.Undef, plan.from)
+	require.True(t, big.NewInt(70).Equals(plan.value), plan.value)
+
+	_, err = parseCollateralSources([]string{"treasury"})
+	require.Error(t, err)
+}
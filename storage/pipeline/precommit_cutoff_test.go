@@ -0,0 +1,125 @@
+package sealing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/raulk/clock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+func TestGetPreCommitCutoff(t *testing.T) {
+	si := SectorInfo{TicketEpoch: 100}
+
+	cutoff, err := getPreCommitCutoff(200, si)
+	require.NoError(t, err)
+	require.Equal(t, 100+policy.MaxPreCommitRandomnessLookback, cutoff)
+
+	// an earlier deal start moves the cutoff in
+	si.Pieces = []Piece{{DealInfo: &api.PieceDealInfo{
+		DealSchedule: api.DealSchedule{StartEpoch: 300},
+	}}}
+
+	cutoff, err = getPreCommitCutoff(200, si)
+	require.NoError(t, err)
+	require.Equal(t, abi.ChainEpoch(300), cutoff)
+
+	_, err = getPreCommitCutoff(300, si)
+	require.Error(t, err)
+}
+
+func TestBatchWaitClock(t *testing.T) {
+	mock := clock.NewMock()
+	epoch := time.Duration(build.BlockDelaySecs) * time.Second
+
+	b := &PreCommitBatcher{
+		mctx:    context.Background(),
+		clock:   mock,
+		cutoffs: map[abi.SectorNumber]time.Time{},
+		todo:    map[abi.SectorNumber]*preCommitEntry{},
+	}
+
+	cfg := sealiface.Config{
+		PreCommitBatchWait:  24 * time.Hour,
+		PreCommitBatchSlack: 30 * time.Minute,
+	}
+
+	require.Equal(t, 24*time.Hour, b.batchWait(cfg))
+
+	// the cutoff is two hours of epochs away, the batch goes out slack before it
+	b.todo[1] = &preCommitEntry{}
+	b.cutoffs[1] = b.cutoffTime(10, 10+abi.ChainEpoch(2*time.Hour/epoch))
+	require.Equal(t, 90*time.Minute, b.batchWait(cfg))
+
+	mock.Add(time.Hour)
+	require.Equal(t, 30*time.Minute, b.batchWait(cfg))
+
+	// past the slack the batch is sent right away
+	mock.Add(time.Hour)
+	require.Equal(t, time.Nanosecond, b.batchWait(cfg))
+}
+
+func TestPendingDetailed(t *testing.T) {
+	mock := clock.NewMock()
+	epoch := time.Duration(build.BlockDelaySecs) * time.Second
+
+	cfg := sealiface.Config{PreCommitBatchSlack: 30 * time.Minute}
+	b := &PreCommitBatcher{
+		mctx:  context.Background(),
+		clock: mock,
+		getConfig: func() (sealiface.Config, error) {
+			return cfg, nil
+		},
+		cutoffs: map[abi.SectorNumber]time.Time{},
+		todo:    map[abi.SectorNumber]*preCommitEntry{},
+	}
+
+	pending, err := b.PendingDetailed(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	// the cutoff of sector 2 is two hours of epochs away, the one of sector 1 past the slack
+	b.todo[2] = &preCommitEntry{deposit: big.NewInt(20), cutoffEpoch: 10 + abi.ChainEpoch(2*time.Hour/epoch)}
+	b.cutoffs[2] = b.cutoffTime(10, b.todo[2].cutoffEpoch)
+	b.todo[1] = &preCommitEntry{deposit: big.NewInt(10), cutoffEpoch: 10 + abi.ChainEpoch(10*time.Minute/epoch)}
+	b.cutoffs[1] = b.cutoffTime(10, b.todo[1].cutoffEpoch)
+
+	pending, err = b.PendingDetailed(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []sealiface.PreCommitPending{
+		{Sector: 1, Deposit: big.NewInt(10), CutoffEpoch: b.todo[1].cutoffEpoch, UntilCutoff: -20 * time.Minute},
+		{Sector: 2, Deposit: big.NewInt(20), CutoffEpoch: b.todo[2].cutoffEpoch, UntilCutoff: 90 * time.Minute},
+	}, pending)
+}
+
+func TestOverdueSectors(t *testing.T) {
+	mock := clock.NewMock()
+
+	b := &PreCommitBatcher{
+		clock:   mock,
+		cutoffs: map[abi.SectorNumber]time.Time{},
+		todo:    map[abi.SectorNumber]*preCommitEntry{},
+	}
+
+	for sn, cutoff := range []time.Duration{time.Hour, 0, -time.Minute, time.Second} {
+		b.todo[abi.SectorNumber(sn)] = &preCommitEntry{}
+		b.cutoffs[abi.SectorNumber(sn)] = mock.Now().Add(cutoff)
+	}
+
+	// sectors without a known cutoff are never overdue
+	b.todo[4] = &preCommitEntry{}
+
+	require.Equal(t, []abi.SectorNumber{1, 2}, b.overdueLocked(mock.Now()))
+
+	mock.Add(time.Second)
+	require.Equal(t, []abi.SectorNumber{1, 2, 3}, b.overdueLocked(mock.Now()))
+}
@@ -0,0 +1,46 @@
+package sealing
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// DebugRunState returns a snapshot of the run loop state, to tell whether a stalled
+// batcher is idle, or blocked inside a send attempt, e.g. on a node call. It doesn't
+// take the batcher lock, so it can be called while the run loop holds it.
+func (b *PreCommitBatcher) DebugRunState() sealiface.PreCommitBatcherRunState {
+	st := sealiface.PreCommitBatcherRunState{
+		NotifyPending: len(b.notify),
+		JoinedFlushes: b.joinedFlushes(),
+	}
+
+	select {
+	case <-b.stop:
+		st.Stopping = true
+	default:
+	}
+
+	select {
+	case <-b.stopped:
+		st.Stopped = true
+	default:
+	}
+
+	if start := atomic.LoadInt64(&b.attemptStart); start != 0 {
+		st.InSendAttempt = true
+		st.SendAttemptStart = time.Unix(0, start)
+	}
+
+	return st
+}
+
+// markAttempt records that the run loop entered or left a send attempt
+func (b *PreCommitBatcher) markAttempt(inside bool) {
+	var start int64
+	if inside {
+		start = b.clk().Now().UnixNano()
+	}
+	atomic.StoreInt64(&b.attemptStart, start)
+}
@@ -0,0 +1,98 @@
+package sealing
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// number of decision records which can wait to be written before new records are dropped
+const decisionLogBuffer = 64
+
+// PreCommitDecision describes what the precommit batcher did in one send attempt
+type PreCommitDecision struct {
+	Time           time.Time
+	Height         abi.ChainEpoch
+	TipSet         types.TipSetKey
+	Queued         int
+	BaseFee        abi.TokenAmount
+	NetworkVersion network.Version
+
+	// one of "batch", "individual", "hybrid", or "deferred"
+	Path     string
+	Sent     []abi.SectorNumber
+	Failed   []abi.SectorNumber
+	Deferred []abi.SectorNumber
+	MaxFee   abi.TokenAmount
+	Reason   string `json:",omitempty"`
+
+	// sectors which were ready to send, but didn't fit in the batch
+	DeferredFull []abi.SectorNumber `json:",omitempty"`
+}
+
+// decisionLog writes decision records as newline-delimited JSON without
+// blocking the batcher run loop
+type decisionLog struct {
+	records chan PreCommitDecision
+	done    chan struct{}
+}
+
+func newDecisionLog(w io.Writer, c io.Closer) *decisionLog {
+	l := &decisionLog{
+		records: make(chan PreCommitDecision, decisionLogBuffer),
+		done:    make(chan struct{}),
+	}
+
+	go l.run(w, c)
+
+	return l
+}
+
+func (l *decisionLog) run(w io.Writer, c io.Closer) {
+	defer close(l.done)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	for d := range l.records {
+		if err := enc.Encode(d); err != nil {
+			log.Warnw("writing precommit decision record", "error", err)
+		}
+
+		if len(l.records) == 0 {
+			if err := bw.Flush(); err != nil {
+				log.Warnw("flushing precommit decision records", "error", err)
+			}
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		log.Warnw("flushing precommit decision records", "error", err)
+	}
+
+	if c != nil {
+		if err := c.Close(); err != nil {
+			log.Warnw("closing precommit decision log", "error", err)
+		}
+	}
+}
+
+func (l *decisionLog) record(d PreCommitDecision) {
+	select {
+	case l.records <- d:
+	default:
+		log.Warnw("precommit decision log is behind, dropping record", "height", d.Height, "path", d.Path)
+	}
+}
+
+// close writes out buffered records and waits for the writer to finish
+func (l *decisionLog) close() {
+	close(l.records)
+	<-l.done
+}
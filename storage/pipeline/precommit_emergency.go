@@ -0,0 +1,104 @@
+package sealing
+
+import (
+	"time"
+
+	"go.opencensus.io/stats"
+
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// window over which emergency sends are counted, also the least time between
+// batch wait adjustments
+const emergencyWindow = time.Hour
+
+// lowest batch wait which auto-tuning goes down to
+const minTunedBatchWait = 10 * time.Minute
+
+// emergencySends keeps track of automatic sends which happened because a sector
+// was within PreCommitBatchSlack of its cutoff
+type emergencySends struct {
+	times []time.Time
+
+	// lowered PreCommitBatchWait, 0 if it wasn't adjusted
+	tunedWait time.Duration
+	lastTune  time.Time
+}
+
+// record adds a send at now, and returns the number of sends within the window
+func (e *emergencySends) record(now time.Time) int {
+	e.times = append(e.times, now)
+	return e.count(now)
+}
+
+// count drops sends which fell out of the window, and returns the number of the
+// remaining ones
+func (e *emergencySends) count(now time.Time) int {
+	start := now.Add(-emergencyWindow)
+
+	i := 0
+	for i < len(e.times) && !e.times[i].After(start) {
+		i++
+	}
+	e.times = e.times[i:]
+
+	return len(e.times)
+}
+
+// tune halves the batch wait, unless it was adjusted within the window. Returns
+// the new wait, or 0 if it wasn't changed.
+func (e *emergencySends) tune(now time.Time, maxWait time.Duration) time.Duration {
+	if !e.lastTune.IsZero() && now.Sub(e.lastTune) < emergencyWindow {
+		return 0
+	}
+
+	cur := maxWait
+	if e.tunedWait > 0 && e.tunedWait < cur {
+		cur = e.tunedWait
+	}
+
+	wait := cur / 2
+	if wait < minTunedBatchWait {
+		wait = minTunedBatchWait
+	}
+	if wait >= cur {
+		return 0
+	}
+
+	e.tunedWait = wait
+	e.lastTune = now
+
+	return wait
+}
+
+// recordEmergencySendLocked counts an emergency send, alerting when there were too
+// many of them recently. Must be called with b.lk held.
+func (b *PreCommitBatcher) recordEmergencySendLocked(cfg sealiface.Config, now time.Time) {
+	n := b.emergency.record(now)
+	stats.Record(b.mctx, metrics.PreCommitEmergencySends.M(1))
+
+	if cfg.PreCommitBatchEmergencySendAlert <= 0 || n <= cfg.PreCommitBatchEmergencySendAlert {
+		return
+	}
+
+	log.Errorw("CRITICAL: many precommits were sent close to their cutoff, sectors are at risk of expiring",
+		"lastHour", n, "threshold", cfg.PreCommitBatchEmergencySendAlert, "wait", cfg.PreCommitBatchWait, "slack", cfg.PreCommitBatchSlack)
+
+	if !cfg.PreCommitBatchAutoTuneWait {
+		return
+	}
+
+	if wait := b.emergency.tune(now, cfg.PreCommitBatchWait); wait > 0 {
+		log.Warnw("lowering precommit batch wait to give sectors more time before their cutoff", "wait", wait, "configured", cfg.PreCommitBatchWait)
+	}
+}
+
+// EmergencySends returns the number of automatic sends in the last hour which
+// happened because a sector was close to its cutoff
+func (b *PreCommitBatcher) EmergencySends() int {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	return b.emergency.count(b.clock.Now())
+}
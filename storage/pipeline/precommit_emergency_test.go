@@ -0,0 +1,30 @@
+package sealing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmergencySends(t *testing.T) {
+	var e emergencySends
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		e.record(start.Add(time.Duration(i) * 10 * time.Minute))
+	}
+	require.Equal(t, 5, e.count(start.Add(40*time.Minute)))
+
+	// the first two sends fall out of the window
+	require.Equal(t, 3, e.count(start.Add(70*time.Minute)))
+
+	// halved, at most once per window
+	require.Equal(t, 2*time.Hour, e.tune(start, 4*time.Hour))
+	require.Zero(t, e.tune(start.Add(30*time.Minute), 4*time.Hour))
+	require.Equal(t, time.Hour, e.tune(start.Add(time.Hour), 4*time.Hour))
+
+	// never below the minimum
+	require.Equal(t, minTunedBatchWait, e.tune(start.Add(10*time.Hour), 15*time.Minute))
+	require.Zero(t, e.tune(start.Add(20*time.Hour), 15*time.Minute))
+}
@@ -0,0 +1,55 @@
+package sealing
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// DrainEstimate estimates how long automatic sends will take to send all
+// currently queued precommits at the current settings. Automatic send attempts
+// send as many batches as needed to empty the queue, so it drains with the next
+// attempt: right away when a full batch is queued, otherwise once the batch timer
+// fires.
+//
+// The estimate is an upper bound on the configured cadence: sectors close to
+// their cutoff are sent sooner, while deferrals which depend on the chain, such
+// as the send window or the base fee, aren't accounted for. Sectors added later
+// aren't either.
+func (b *PreCommitBatcher) DrainEstimate(ctx context.Context) (time.Duration, error) {
+	cfg, err := b.getConfig()
+	if err != nil {
+		return 0, xerrors.Errorf("getting config: %w", err)
+	}
+
+	if cfg.PreCommitBatchManualSendMode {
+		return 0, xerrors.New("in manual send mode queued precommits are only sent by flushes")
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	return b.drainEstimateLocked(cfg, b.clock.Now()), nil
+}
+
+func (b *PreCommitBatcher) drainEstimateLocked(cfg sealiface.Config, now time.Time) time.Duration {
+	if len(b.todo) == 0 {
+		return 0
+	}
+
+	var wait time.Duration
+	if len(b.todo) < cfg.MaxPreCommitBatch {
+		wait = b.batchWaitLocked(cfg, now)
+		if grace := b.startupGraceRemaining(cfg, now); grace > wait {
+			wait = grace
+		}
+	}
+	if paused := b.pausedUntil.Sub(now); paused > wait {
+		wait = paused
+	}
+
+	return wait
+}
@@ -0,0 +1,63 @@
+package sealing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/raulk/clock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+func TestDrainEstimate(t *testing.T) {
+	cfg := sealiface.Config{
+		MaxPreCommitBatch:  4,
+		PreCommitBatchWait: time.Hour,
+	}
+	mock := clock.NewMock()
+	b := &PreCommitBatcher{
+		mctx:  context.Background(),
+		clock: mock,
+		getConfig: func() (sealiface.Config, error) {
+			return cfg, nil
+		},
+		todo: map[abi.SectorNumber]*preCommitEntry{},
+	}
+
+	est, err := b.DrainEstimate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), est)
+
+	// 10 sectors, the attempt started by the full batch sends all three batches
+	for sn := abi.SectorNumber(0); sn < 10; sn++ {
+		b.todo[sn] = &preCommitEntry{}
+	}
+
+	est, err = b.DrainEstimate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), est)
+
+	// with a partial batch queued the attempt waits for the timer
+	cfg.MaxPreCommitBatch = 20
+
+	est, err = b.DrainEstimate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, est)
+
+	// nothing is sent before sends are resumed
+	cfg.MaxPreCommitBatch = 4
+	b.pausedUntil = mock.Now().Add(2 * time.Hour)
+
+	est, err = b.DrainEstimate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Hour, est)
+
+	cfg.PreCommitBatchManualSendMode = true
+
+	_, err = b.DrainEstimate(context.Background())
+	require.Error(t, err)
+}
@@ -0,0 +1,83 @@
+package sealing
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// baseFeeCap is a PreCommitBatchBaseFeeCaps entry
+type baseFeeCap struct {
+	baseFee abi.TokenAmount
+	sectors int
+}
+
+// parseBaseFeeCaps parses "<base fee>:<sectors>" entries, returning them ordered
+// by base fee
+func parseBaseFeeCaps(entries []string) ([]baseFeeCap, error) {
+	caps := make([]baseFeeCap, 0, len(entries))
+	for _, e := range entries {
+		i := strings.LastIndex(e, ":")
+		if i < 0 {
+			return nil, xerrors.Errorf("base fee cap %q: expected <base fee>:<sectors>", e)
+		}
+
+		bf, err := types.ParseFIL(strings.TrimSpace(e[:i]))
+		if err != nil {
+			return nil, xerrors.Errorf("base fee cap %q: parsing base fee: %w", e, err)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(e[i+1:]))
+		if err != nil {
+			return nil, xerrors.Errorf("base fee cap %q: parsing sectors: %w", e, err)
+		}
+		if n < 1 {
+			return nil, xerrors.Errorf("base fee cap %q: at least one sector required", e)
+		}
+
+		caps = append(caps, baseFeeCap{baseFee: abi.TokenAmount(bf), sectors: n})
+	}
+
+	sort.SliceStable(caps, func(i, j int) bool {
+		return caps[i].baseFee.LessThan(caps[j].baseFee)
+	})
+	return caps, nil
+}
+
+// maxBatchAt returns the maximum number of sectors in a batch at the given base
+// fee: the cap of the highest PreCommitBatchBaseFeeCaps base fee at or below it,
+// clamped between MinPreCommitBatch and MaxPreCommitBatch
+func maxBatchAt(cfg sealiface.Config, bf abi.TokenAmount) (int, error) {
+	max := cfg.MaxPreCommitBatch
+	if len(cfg.PreCommitBatchBaseFeeCaps) == 0 {
+		return max, nil
+	}
+
+	caps, err := parseBaseFeeCaps(cfg.PreCommitBatchBaseFeeCaps)
+	if err != nil {
+		return max, err
+	}
+
+	n := max
+	for _, c := range caps {
+		if bf.LessThan(c.baseFee) {
+			break
+		}
+		n = c.sectors
+	}
+
+	if n < cfg.MinPreCommitBatch {
+		n = cfg.MinPreCommitBatch
+	}
+	if n > max {
+		n = max
+	}
+	return n, nil
+}
@@ -0,0 +1,53 @@
+package sealing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+func TestMaxBatchAt(t *testing.T) {
+	nano := func(n int64) big.Int {
+		return big.NewInt(n * 1_000_000_000)
+	}
+
+	cfg := sealiface.Config{
+		MaxPreCommitBatch: 256,
+		MinPreCommitBatch: 4,
+	}
+
+	n, err := maxBatchAt(cfg, nano(5))
+	require.NoError(t, err)
+	require.Equal(t, 256, n)
+
+	cfg.PreCommitBatchBaseFeeCaps = []string{"2000000000 attoFIL:32", "1000000000 attoFIL:128", "0.00000001:1"}
+
+	for _, tc := range []struct {
+		bf  big.Int
+		max int
+	}{
+		{big.NewInt(100), 256},
+		{nano(1), 128},
+		{nano(3), 32},
+		{nano(20), 4}, // clamped to the minimum
+	} {
+		n, err := maxBatchAt(cfg, tc.bf)
+		require.NoError(t, err)
+		require.Equal(t, tc.max, n, "basefee %s", tc.bf)
+	}
+
+	cfg.PreCommitBatchBaseFeeCaps = []string{"1000000000 attoFIL:500"}
+	n, err = maxBatchAt(cfg, nano(2))
+	require.NoError(t, err)
+	require.Equal(t, 256, n)
+
+	for _, bad := range []string{"1000000000 attoFIL", "abc:10", "1 nanoFIL:10", "1000000000 attoFIL:x", "1000000000 attoFIL:0"} {
+		cfg.PreCommitBatchBaseFeeCaps = []string{bad}
+		_, err := maxBatchAt(cfg, nano(2))
+		require.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,73 @@
+package sealing
+
+import (
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+)
+
+// base fee changes smaller than feeTrendThresholdPct percent are considered flat
+const feeTrendThresholdPct = 5
+
+// minimum number of samples needed to tell the direction of a trend
+const feeTrendMinSamples = 4
+
+// baseFeeTrend keeps a window of recent base fee samples, one per epoch
+type baseFeeTrend struct {
+	size    int
+	heights []abi.ChainEpoch
+	samples []abi.TokenAmount
+}
+
+func newBaseFeeTrend(size int) *baseFeeTrend {
+	return &baseFeeTrend{
+		size: size,
+	}
+}
+
+func (t *baseFeeTrend) add(h abi.ChainEpoch, bf abi.TokenAmount) {
+	if n := len(t.heights); n > 0 && t.heights[n-1] >= h {
+		return // already sampled this epoch, or a reorg to a lower height
+	}
+
+	t.heights = append(t.heights, h)
+	t.samples = append(t.samples, bf)
+
+	if len(t.samples) > t.size {
+		t.heights = t.heights[len(t.heights)-t.size:]
+		t.samples = t.samples[len(t.samples)-t.size:]
+	}
+}
+
+// direction compares the average of the older half of the window with the
+// average of the newer half, returning 1 when the base fee is rising, -1 when it
+// is falling, and 0 when it is flat or there aren't enough samples
+func (t *baseFeeTrend) direction() int {
+	if len(t.samples) < feeTrendMinSamples {
+		return 0
+	}
+
+	half := len(t.samples) / 2
+	older := avgFee(t.samples[:half])
+	newer := avgFee(t.samples[len(t.samples)-half:])
+
+	threshold := big.Div(big.Mul(older, big.NewInt(feeTrendThresholdPct)), big.NewInt(100))
+	diff := big.Sub(newer, older)
+
+	switch {
+	case diff.GreaterThan(threshold):
+		return 1
+	case diff.LessThan(big.Mul(threshold, big.NewInt(-1))):
+		return -1
+	default:
+		return 0
+	}
+}
+
+func avgFee(fees []abi.TokenAmount) abi.TokenAmount {
+	sum := big.Zero()
+	for _, f := range fees {
+		sum = big.Add(sum, f)
+	}
+
+	return big.Div(sum, big.NewInt(int64(len(fees))))
+}
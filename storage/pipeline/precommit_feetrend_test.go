@@ -0,0 +1,34 @@
+package sealing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+)
+
+func TestBaseFeeTrend(t *testing.T) {
+	feed := func(fees ...int64) *baseFeeTrend {
+		tr := newBaseFeeTrend(8)
+		for i, f := range fees {
+			tr.add(abi.ChainEpoch(i+1), big.NewInt(f))
+		}
+		return tr
+	}
+
+	require.Equal(t, 0, feed(100, 200, 300).direction(), "not enough samples")
+
+	require.Equal(t, 1, feed(100, 110, 120, 130, 140, 150).direction())
+	require.Equal(t, -1, feed(150, 140, 130, 120, 110, 100).direction())
+	require.Equal(t, 0, feed(100, 101, 99, 100, 102, 100).direction())
+
+	// only the most recent samples are considered
+	require.Equal(t, -1, feed(100, 110, 120, 130, 500, 400, 300, 200, 100, 90, 80, 70).direction())
+
+	// samples at the same height are ignored
+	tr := feed(100, 110, 120, 130)
+	tr.add(4, big.NewInt(10))
+	require.Equal(t, 1, tr.direction())
+}
@@ -0,0 +1,48 @@
+package sealing
+
+// gas limit margin over the highest observed per-sector gas use, in percent
+const batchGasMarginPct = 125
+
+// minimum number of landed batches before the model produces estimates
+const batchGasMinSamples = 2
+
+// batchGasModel keeps per-sector gas used by recently landed batch messages, and
+// uses it to estimate the gas limit for future batches
+type batchGasModel struct {
+	size      int
+	perSector []int64
+}
+
+func newBatchGasModel(size int) *batchGasModel {
+	return &batchGasModel{
+		size: size,
+	}
+}
+
+func (m *batchGasModel) observe(sectors int, gasUsed int64) {
+	if sectors <= 0 || gasUsed <= 0 {
+		return
+	}
+
+	m.perSector = append(m.perSector, (gasUsed+int64(sectors)-1)/int64(sectors))
+	if len(m.perSector) > m.size {
+		m.perSector = m.perSector[len(m.perSector)-m.size:]
+	}
+}
+
+// estimate returns the gas limit to use for a batch with the given number of
+// sectors, or false if there isn't enough data yet
+func (m *batchGasModel) estimate(sectors int) (int64, bool) {
+	if len(m.perSector) < batchGasMinSamples || sectors <= 0 {
+		return 0, false
+	}
+
+	var highest int64
+	for _, g := range m.perSector {
+		if g > highest {
+			highest = g
+		}
+	}
+
+	return highest * int64(sectors) * batchGasMarginPct / 100, true
+}
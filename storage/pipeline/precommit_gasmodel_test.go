@@ -0,0 +1,38 @@
+package sealing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchGasModel(t *testing.T) {
+	m := newBatchGasModel(3)
+
+	_, ok := m.estimate(10)
+	require.False(t, ok)
+
+	m.observe(10, 1_000_000)
+	_, ok = m.estimate(10)
+	require.False(t, ok, "not enough samples")
+
+	m.observe(4, 480_000)
+
+	// highest per-sector use is 120k, plus the margin
+	est, ok := m.estimate(10)
+	require.True(t, ok)
+	require.Equal(t, int64(1_500_000), est)
+
+	// bad receipts are ignored
+	m.observe(0, 100)
+	m.observe(5, 0)
+	est, _ = m.estimate(1)
+	require.Equal(t, int64(150_000), est)
+
+	// old samples fall out of the window
+	m.observe(2, 100_000)
+	m.observe(2, 100_000)
+	m.observe(2, 100_000)
+	est, _ = m.estimate(10)
+	require.Equal(t, int64(625_000), est)
+}
@@ -0,0 +1,107 @@
+package sealing
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// PreCommitBatchFlushDuringSend policies
+const (
+	flushDuringSendWait  = "wait"
+	flushDuringSendMerge = "merge"
+)
+
+// attemptJoin tracks Flush callers which joined the send attempt in progress.
+// It has its own lock, as the run loop holds b.lk for the whole attempt.
+type attemptJoin struct {
+	lk     sync.Mutex
+	open   bool
+	joined []chan []sealiface.PreCommitBatchRes
+
+	// set once a flush joined the attempt, so that the attempt can pick it up
+	// while holding b.lk; accessed atomically
+	forced int32
+}
+
+// openJoin lets flushes join the send attempt which is about to start
+func (b *PreCommitBatcher) openJoin() {
+	b.join.lk.Lock()
+	defer b.join.lk.Unlock()
+
+	b.join.open = true
+}
+
+// closeJoin ends the send attempt, returning the flushes which joined it
+func (b *PreCommitBatcher) closeJoin() []chan []sealiface.PreCommitBatchRes {
+	b.join.lk.Lock()
+	defer b.join.lk.Unlock()
+
+	joined := b.join.joined
+	b.join.open, b.join.joined = false, nil
+	atomic.StoreInt32(&b.join.forced, 0)
+
+	return joined
+}
+
+// joinAttempt makes a flush join the send attempt in progress, if there is one and
+// PreCommitBatchFlushDuringSend is set to "merge". The results are sent on resCh
+// once the attempt is over.
+func (b *PreCommitBatcher) joinAttempt(resCh chan []sealiface.PreCommitBatchRes) bool {
+	cfg, err := b.getConfig()
+	if err != nil {
+		log.Warnw("getting config, not joining the precommit send attempt in progress", "error", err)
+		return false
+	}
+	switch cfg.PreCommitBatchFlushDuringSend {
+	case flushDuringSendMerge:
+	case "", flushDuringSendWait:
+		return false
+	default:
+		log.Warnw("unknown PreCommitBatchFlushDuringSend policy, waiting for the send attempt in progress", "policy", cfg.PreCommitBatchFlushDuringSend)
+		return false
+	}
+
+	b.join.lk.Lock()
+	defer b.join.lk.Unlock()
+
+	if !b.join.open {
+		return false
+	}
+
+	b.join.joined = append(b.join.joined, resCh)
+	atomic.StoreInt32(&b.join.forced, 1)
+
+	return true
+}
+
+// flushJoined returns whether a flush joined the send attempt in progress
+func (b *PreCommitBatcher) flushJoined() bool {
+	return atomic.LoadInt32(&b.join.forced) != 0
+}
+
+// joinedFlushes returns the number of flushes waiting on the send attempt in progress
+func (b *PreCommitBatcher) joinedFlushes() int {
+	b.join.lk.Lock()
+	defer b.join.lk.Unlock()
+
+	return len(b.join.joined)
+}
+
+// serveJoined sends the sectors which the attempt with results res left queued,
+// and passes the results of both to the flushes which joined it. Returns the
+// combined results.
+func (b *PreCommitBatcher) serveJoined(cfg sealiface.Config, joined []chan []sealiface.PreCommitBatchRes, res []sealiface.PreCommitBatchRes) []sealiface.PreCommitBatchRes {
+	more, err := b.maybeStartBatch(cfg, false, true, false)
+	if err != nil {
+		log.Warnw("sending precommits left queued by a joined send attempt", "error", err)
+	}
+
+	res = append(res[:len(res):len(res)], more...)
+	for _, ch := range joined {
+		ch <- res // buffered
+	}
+
+	return res
+}
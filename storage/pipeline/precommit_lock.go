@@ -0,0 +1,40 @@
+package sealing
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+// lockTimed takes b.lk, returning a function which releases it and records how
+// long it was held by holder, so that contention stalling AddPreCommit callers or
+// the run loop shows up in metrics
+func (b *PreCommitBatcher) lockTimed(holder string) func() {
+	b.lk.Lock()
+	b.lockHolder, b.lockTaken = holder, b.clock.Now()
+
+	return b.unlockTimed
+}
+
+// unlockTimed releases b.lk taken by lockTimed, recording how long it was held
+func (b *PreCommitBatcher) unlockTimed() {
+	holder, held := b.lockHolder, b.clock.Since(b.lockTaken)
+	b.lk.Unlock()
+
+	_ = stats.RecordWithTags(b.mctx, []tag.Mutator{tag.Upsert(metrics.LockHolder, holder)},
+		metrics.PreCommitBatcherLockHeld.M(float64(held.Nanoseconds())/1e6))
+}
+
+// unlockedDuring runs f with b.lk released, taking it back for the same holder
+// afterwards. The time spent in f isn't recorded as held. Must be called with b.lk
+// taken by lockTimed.
+func (b *PreCommitBatcher) unlockedDuring(f func()) {
+	holder := b.lockHolder
+	b.unlockTimed()
+
+	f()
+
+	b.lk.Lock()
+	b.lockHolder, b.lockTaken = holder, b.clock.Now()
+}
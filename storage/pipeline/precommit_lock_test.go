@@ -0,0 +1,70 @@
+package sealing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/raulk/clock"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+func TestLockTimed(t *testing.T) {
+	require.NoError(t, view.Register(metrics.PreCommitBatcherLockHeldView))
+	defer view.Unregister(metrics.PreCommitBatcherLockHeldView)
+
+	mock := clock.NewMock()
+	b := &PreCommitBatcher{mctx: context.Background(), clock: mock}
+
+	unlock := b.lockTimed("test")
+	mock.Add(5 * time.Millisecond)
+	unlock()
+
+	rows, err := view.RetrieveData(metrics.PreCommitBatcherLockHeldView.Name)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, "test", rows[0].Tags[0].Value)
+
+	d, ok := rows[0].Data.(*view.DistributionData)
+	require.True(t, ok)
+	require.EqualValues(t, 1, d.Count)
+	require.GreaterOrEqual(t, d.Min, 5.0)
+
+	// the lock was released
+	require.True(t, b.lk.TryLock())
+}
+
+func TestLockTimedUnlockedDuring(t *testing.T) {
+	require.NoError(t, view.Register(metrics.PreCommitBatcherLockHeldView))
+	defer view.Unregister(metrics.PreCommitBatcherLockHeldView)
+
+	mock := clock.NewMock()
+	b := &PreCommitBatcher{mctx: context.Background(), clock: mock}
+
+	unlock := b.lockTimed("test")
+	mock.Add(5 * time.Millisecond)
+	b.unlockedDuring(func() {
+		// others can take the lock meanwhile
+		require.True(t, b.lk.TryLock())
+		b.lk.Unlock()
+
+		mock.Add(time.Second)
+	})
+	mock.Add(5 * time.Millisecond)
+	unlock()
+
+	rows, err := view.RetrieveData(metrics.PreCommitBatcherLockHeldView.Name)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+
+	// the time spent unlocked isn't counted as held
+	d, ok := rows[0].Data.(*view.DistributionData)
+	require.True(t, ok)
+	require.EqualValues(t, 2, d.Count)
+	require.Less(t, d.Max, 1000.0)
+
+	require.True(t, b.lk.TryLock())
+}
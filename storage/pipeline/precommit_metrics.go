@@ -0,0 +1,44 @@
+package sealing
+
+import (
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// recordQueuedLocked records the number of queued precommits. Must be called
+// with b.lk held.
+func (b *PreCommitBatcher) recordQueuedLocked() {
+	_ = stats.RecordWithTags(b.mctx, []tag.Mutator{tag.Upsert(metrics.MinerID, b.maddr.String())},
+		metrics.PreCommitQueued.M(int64(len(b.todo))))
+}
+
+// recordSentLocked records a sent precommit message, with the time its sectors
+// spent queued. Must be called with b.lk held, before the sectors are dequeued.
+func (b *PreCommitBatcher) recordSentLocked(r sealiface.PreCommitBatchRes, now time.Time) {
+	sendType := "batch"
+	if r.IndividualReason != "" {
+		sendType = "individual"
+	}
+
+	miner := tag.Upsert(metrics.MinerID, b.maddr.String())
+	_ = stats.RecordWithTags(b.mctx, []tag.Mutator{miner, tag.Upsert(metrics.SendType, sendType)}, metrics.PreCommitSends.M(1))
+
+	if r.IndividualReason == "" {
+		_ = stats.RecordWithTags(b.mctx, []tag.Mutator{miner}, metrics.PreCommitBatchSize.M(int64(len(r.Sectors))))
+	}
+
+	for _, sn := range r.Sectors {
+		p, ok := b.todo[sn]
+		if !ok || p.queued.IsZero() {
+			continue
+		}
+
+		_ = stats.RecordWithTags(b.mctx, []tag.Mutator{miner},
+			metrics.PreCommitQueueTime.M(float64(now.Sub(p.queued).Nanoseconds())/1e6))
+	}
+}
@@ -0,0 +1,36 @@
+package sealing
+
+import (
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// minerInfoCache holds the miner info read during a single send attempt, so that
+// sending several batches doesn't fetch it again for each of them
+type minerInfoCache struct {
+	tsk types.TipSetKey
+	mi  api.MinerInfo
+	set bool
+}
+
+// minerInfo returns the miner info at tsk. Within a send attempt it's fetched
+// once per tipset, outside of one it's always fetched. Must be called with b.lk
+// held.
+func (b *PreCommitBatcher) minerInfo(tsk types.TipSetKey) (api.MinerInfo, error) {
+	if c := b.miCache; c != nil && c.set && c.tsk == tsk {
+		return c.mi, nil
+	}
+
+	mi, err := b.api.StateMinerInfo(b.mctx, b.maddr, tsk)
+	if err != nil {
+		return api.MinerInfo{}, xerrors.Errorf("couldn't get miner info: %w", err)
+	}
+
+	if c := b.miCache; c != nil {
+		*c = minerInfoCache{tsk: tsk, mi: mi, set: true}
+	}
+
+	return mi, nil
+}
@@ -0,0 +1,52 @@
+package sealing
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// Reconfigure makes the batcher re-read its config right away and reset the
+// timer of the next send attempt, so that a changed PreCommitBatchWait or
+// PreCommitBatchSlack applies without waiting for the current timer. Nothing is
+// sent. Without it the config is re-read each time the batcher wakes up, and by
+// each send attempt, so MaxPreCommitBatch changes always apply to the next batch.
+func (b *PreCommitBatcher) Reconfigure(ctx context.Context) error {
+	done := make(chan error, 1)
+
+	select {
+	case b.reconfigure <- done:
+	case <-b.stop:
+		return ErrBatcherStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-b.stopped:
+		return ErrBatcherStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reloadConfig replaces cfg with the current config, keeping it when the config
+// can't be read
+func (b *PreCommitBatcher) reloadConfig(cfg *sealiface.Config) error {
+	ncfg, err := b.getConfig()
+	if err != nil {
+		log.Warnw("PreCommitBatcher re-reading config, keeping the previous one", "error", err)
+		return xerrors.Errorf("getting config: %w", err)
+	}
+
+	if ncfg.MaxPreCommitBatch != cfg.MaxPreCommitBatch || ncfg.PreCommitBatchWait != cfg.PreCommitBatchWait || ncfg.PreCommitBatchSlack != cfg.PreCommitBatchSlack {
+		log.Infow("PreCommitBatcher config changed", "maxBatch", ncfg.MaxPreCommitBatch, "wait", ncfg.PreCommitBatchWait, "slack", ncfg.PreCommitBatchSlack)
+	}
+
+	*cfg = ncfg
+	return nil
+}
@@ -0,0 +1,138 @@
+package sealing
+
+import (
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/raulk/clock"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// errSendRetriesExhausted is returned for messages which kept failing to send
+// with transient errors. Their sectors stay queued for the next send attempt.
+var errSendRetriesExhausted = xerrors.New("precommit send retries exhausted")
+
+// transientSendErrors are parts of errors which sending the same message again may
+// fix: a full mpool, and connection errors or timeouts talking to the node. Errors
+// may come over RPC, so they're matched by message.
+var transientSendErrors = []string{
+	"too many pending messages",
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"i/o timeout",
+	"websocket connection closed",
+	"context deadline exceeded",
+}
+
+// retryableSendError returns whether a send which failed with err may succeed
+// when tried again. Other errors, such as gas estimation or actor errors, fail the
+// sectors like before retries were added.
+func retryableSendError(err error) bool {
+	var rpcErr *jsonrpc.RPCConnectionError
+	if xerrors.As(err, &rpcErr) {
+		return true
+	}
+
+	msg := err.Error()
+	for _, p := range transientSendErrors {
+		if strings.Contains(msg, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendRetrying sends msg, carrying the precommits of the given entries, sending it
+// again up to PreCommitBatchSendRetries times when it fails with a transient error,
+// waiting PreCommitBatchSendRetryBackoff before the first retry and twice as long
+// before each next one. When all tries fail, or an entry left the queue or was
+// replaced while waiting, the error wraps errSendRetriesExhausted. Must be called
+// with b.lk taken by lockTimed; it's released while retrying, so that AddPreCommit
+// and readers aren't blocked for the whole backoff.
+func (b *PreCommitBatcher) sendRetrying(cfg sealiface.Config, msg *types.Message, maxFee abi.TokenAmount, entries map[abi.SectorNumber]*preCommitEntry) (cid.Cid, error) {
+	mcid, err := b.send(b.mctx, msg, maxFee)
+	if err == nil {
+		return mcid, nil
+	}
+	if !retryableSendError(err) {
+		return cid.Undef, err
+	}
+	if cfg.PreCommitBatchSendRetries <= 0 {
+		return cid.Undef, xerrors.Errorf("1 tries, last error: %s: %w", err, errSendRetriesExhausted)
+	}
+
+	send, clk := b.send, b.clock
+	b.unlockedDuring(func() {
+		mcid, err = b.retrySend(cfg, send, clk, msg, maxFee, entries, err)
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	// the queue may have changed between the last check and the send
+	if sn, changed := b.changedEntryLocked(entries); changed {
+		log.Warnw("precommit changed while its message was being sent, the message carries the previous one", "sector", sn, "cid", mcid)
+	}
+
+	return mcid, nil
+}
+
+// retrySend sends msg again after the first try failed with sendErr, until it
+// succeeds, fails with an error which isn't transient, or runs out of retries.
+// Must be called with b.lk released.
+func (b *PreCommitBatcher) retrySend(cfg sealiface.Config, send SendFunc, clk clock.Clock, msg *types.Message, maxFee abi.TokenAmount, entries map[abi.SectorNumber]*preCommitEntry, sendErr error) (cid.Cid, error) {
+	backoff := cfg.PreCommitBatchSendRetryBackoff
+
+	for try := 1; ; try++ {
+		log.Warnw("sending precommit message failed, retrying", "try", try, "retries", cfg.PreCommitBatchSendRetries, "backoff", backoff, "error", sendErr)
+
+		if backoff > 0 {
+			select {
+			case <-clk.After(backoff):
+			case <-b.mctx.Done():
+				return cid.Undef, xerrors.Errorf("waiting to retry send: %w", b.mctx.Err())
+			}
+		}
+		backoff *= 2
+
+		// the message would carry a precommit which was removed or replaced
+		b.lk.Lock()
+		sn, changed := b.changedEntryLocked(entries)
+		b.lk.Unlock()
+		if changed {
+			return cid.Undef, xerrors.Errorf("sector %d changed while waiting to retry: %w", sn, errSendRetriesExhausted)
+		}
+
+		mcid, err := send(b.mctx, msg, maxFee)
+		if err == nil {
+			return mcid, nil
+		}
+		if !retryableSendError(err) {
+			return cid.Undef, err
+		}
+		if try >= cfg.PreCommitBatchSendRetries {
+			return cid.Undef, xerrors.Errorf("%d tries, last error: %s: %w", try+1, err, errSendRetriesExhausted)
+		}
+
+		sendErr = err
+	}
+}
+
+// changedEntryLocked returns a sector whose queued entry isn't the given one
+// anymore, because it left the queue or was replaced. Must be called with b.lk
+// held.
+func (b *PreCommitBatcher) changedEntryLocked(entries map[abi.SectorNumber]*preCommitEntry) (abi.SectorNumber, bool) {
+	for sn, p := range entries {
+		if b.todo[sn] != p {
+			return sn, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,99 @@
+package sealing
+
+import (
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// attemptSender tracks how a sending address was used in the current send
+// attempt
+type attemptSender struct {
+	batches int
+	funds   abi.TokenAmount // committed by the batches sent from it
+}
+
+// noteSenderLocked records that a batch needing funds is sent from addr in the
+// current send attempt. Must be called with b.lk held.
+func (b *PreCommitBatcher) noteSenderLocked(addr address.Address, funds abi.TokenAmount) {
+	if b.senders == nil {
+		return
+	}
+
+	s, ok := b.senders[addr]
+	if !ok {
+		s = &attemptSender{funds: big.Zero()}
+		b.senders[addr] = s
+	}
+	s.batches++
+	s.funds = big.Add(s.funds, funds)
+}
+
+// roundRobinSender picks the address for a batch which isn't the first of the
+// send attempt: the control address which sent the fewest batches so far among
+// those holding goodFunds on top of what they already committed in the attempt,
+// and whose key is in the wallet. Like with the address selector, the worker is
+// the fallback when no control address qualifies. It returns address.Undef for
+// the first batch, or when no address qualifies, leaving the choice to the
+// address selector. Must be called with b.lk held.
+func (b *PreCommitBatcher) roundRobinSender(mi api.MinerInfo, goodFunds abi.TokenAmount, tsk types.TipSetKey) address.Address {
+	if len(b.senders) == 0 {
+		return address.Undef
+	}
+
+	best, bestBatches := address.Undef, 0
+	for _, addr := range mi.ControlAddresses {
+		used := 0
+		if s, ok := b.senders[addr]; ok {
+			used = s.batches
+		}
+		if best != address.Undef && used >= bestBatches {
+			continue
+		}
+
+		if b.canSendLocked(addr, goodFunds, tsk) {
+			best, bestBatches = addr, used
+		}
+	}
+
+	if best == address.Undef && b.canSendLocked(mi.Worker, goodFunds, tsk) {
+		best = mi.Worker
+	}
+
+	return best
+}
+
+// canSendLocked returns whether addr holds goodFunds on top of what it already
+// committed in the send attempt, and its key is in the wallet. Must be called with
+// b.lk held.
+func (b *PreCommitBatcher) canSendLocked(addr address.Address, goodFunds abi.TokenAmount, tsk types.TipSetKey) bool {
+	need := goodFunds
+	if s, ok := b.senders[addr]; ok {
+		need = big.Add(need, s.funds)
+	}
+
+	bal, err := b.api.WalletBalance(b.mctx, addr)
+	if err != nil {
+		log.Errorw("checking sender balance", "addr", addr, "error", err)
+		return false
+	}
+	if bal.LessThan(need) {
+		return false
+	}
+
+	k, err := b.api.StateAccountKey(b.mctx, addr, tsk)
+	if err != nil {
+		log.Errorw("getting account key", "addr", addr, "error", err)
+		return false
+	}
+	have, err := b.api.WalletHas(b.mctx, k)
+	if err != nil {
+		log.Errorw("failed to check sender address", "addr", addr, "error", err)
+		return false
+	}
+
+	return have
+}
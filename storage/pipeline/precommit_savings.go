@@ -0,0 +1,87 @@
+package sealing
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// gas used by a single PreCommitSector message, this is the estimate the miner
+// actor uses when computing the aggregate network fee
+const singlePreCommitGas = 16_433_324
+
+// rough gas used per sector in a PreCommitSectorBatch message, used until landed
+// batches give a better estimate
+const batchPreCommitGasPerSector = 6_000_000
+
+// batchSavings returns how much cheaper it is to precommit n sectors in one batch
+// using batchGas, than with n individual messages at base fee bf. The result is
+// negative when individual messages are cheaper.
+func batchSavings(nv network.Version, n int, bf abi.TokenAmount, batchGas int64) (abi.TokenAmount, error) {
+	aggFee, err := policy.AggregatePreCommitNetworkFee(nv, n, bf)
+	if err != nil {
+		return big.Zero(), xerrors.Errorf("getting aggregate precommit network fee: %w", err)
+	}
+
+	individual := big.Mul(bf, big.NewInt(singlePreCommitGas*int64(n)))
+	batch := big.Add(big.Mul(bf, big.NewInt(batchGas)), aggFee)
+
+	return big.Sub(individual, batch), nil
+}
+
+// batchPays returns whether batching n sectors is estimated to save more than
+// PreCommitBatchMinSavings over individual messages, along with the savings
+func batchPays(cfg sealiface.Config, nv network.Version, n int, bf abi.TokenAmount, batchGas int64) (bool, abi.TokenAmount, error) {
+	savings, err := batchSavings(nv, n, bf, batchGas)
+	if err != nil {
+		return false, big.Zero(), err
+	}
+
+	minSavings := cfg.PreCommitBatchMinSavings
+	if minSavings.Nil() || minSavings.LessThan(big.Zero()) {
+		minSavings = big.Zero()
+	}
+
+	return savings.GreaterThan(minSavings), savings, nil
+}
+
+// batchGasLocked returns the expected gas used by a batch of n sectors. Must be
+// called with b.lk held.
+func (b *PreCommitBatcher) batchGasLocked(n int) int64 {
+	if gas, ok := b.gasModel.estimate(n); ok {
+		return gas
+	}
+
+	return batchPreCommitGasPerSector * int64(n)
+}
+
+// EstimateBatchSavings returns how much cheaper it would be to precommit n sectors
+// in one batch than individually, at the current base fee and network version
+func (b *PreCommitBatcher) EstimateBatchSavings(ctx context.Context, n int) (abi.TokenAmount, error) {
+	if n <= 0 {
+		return big.Zero(), xerrors.Errorf("number of sectors must be positive, got %d", n)
+	}
+
+	ts, err := b.api.ChainHead(ctx)
+	if err != nil {
+		return big.Zero(), xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	nv, err := b.api.StateNetworkVersion(ctx, ts.Key())
+	if err != nil {
+		return big.Zero(), xerrors.Errorf("couldn't get network version: %w", err)
+	}
+
+	b.lk.Lock()
+	gas := b.batchGasLocked(n)
+	b.lk.Unlock()
+
+	return batchSavings(nv, n, ts.MinTicketBlock().ParentBaseFee, gas)
+}
@@ -0,0 +1,84 @@
+package sealing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+func TestBatchSavings(t *testing.T) {
+	const n = 10
+	batchGas := int64(batchPreCommitGasPerSector * n)
+
+	expect := func(nv network.Version, bf abi.TokenAmount) abi.TokenAmount {
+		aggFee, err := policy.AggregatePreCommitNetworkFee(nv, n, bf)
+		require.NoError(t, err)
+
+		individual := big.Mul(bf, big.NewInt(singlePreCommitGas*n))
+		batch := big.Add(big.Mul(bf, big.NewInt(batchGas)), aggFee)
+		return big.Sub(individual, batch)
+	}
+
+	// before nv14 there is no aggregate fee, batches only save gas
+	bf := big.NewInt(100)
+	s, err := batchSavings(network.Version13, n, bf, batchGas)
+	require.NoError(t, err)
+	require.Equal(t, big.Mul(bf, big.NewInt((singlePreCommitGas-batchPreCommitGasPerSector)*n)), s)
+
+	// at a low base fee the aggregate fee is set by the batch balancer, and costs
+	// more than the gas saved by batching
+	s, err = batchSavings(network.Version16, n, bf, batchGas)
+	require.NoError(t, err)
+	require.Equal(t, expect(network.Version16, bf), s)
+	require.True(t, s.LessThan(big.Zero()), "expected batching to cost more, savings %s", s)
+
+	// at a high base fee batching is cheaper
+	bf = big.NewInt(100_000_000_000)
+	s, err = batchSavings(network.Version16, n, bf, batchGas)
+	require.NoError(t, err)
+	require.Equal(t, expect(network.Version16, bf), s)
+	require.True(t, s.GreaterThan(big.Zero()), "expected batching to be cheaper, savings %s", s)
+}
+
+func TestBatchPays(t *testing.T) {
+	const n = 10
+	batchGas := int64(batchPreCommitGasPerSector * n)
+
+	lowFee := big.NewInt(100)
+	highFee := big.NewInt(100_000_000_000)
+
+	tcs := map[string]struct {
+		nv         network.Version
+		bf         abi.TokenAmount
+		minSavings abi.TokenAmount
+		batch      bool
+	}{
+		"noAggFee":          {nv: network.Version13, bf: lowFee, batch: true},
+		"lowFee":            {nv: network.Version16, bf: lowFee, batch: false},
+		"highFee":           {nv: network.Version16, bf: highFee, batch: true},
+		"highFeeMinSavings": {nv: network.Version16, bf: highFee, minSavings: big.Mul(big.NewInt(100), big.NewInt(1_000_000_000_000_000_000)), batch: false},
+		"lowFeeNegativeMin": {nv: network.Version16, bf: lowFee, minSavings: big.NewInt(-1_000_000_000_000_000_000), batch: false},
+	}
+
+	for name, tc := range tcs {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			cfg := sealiface.Config{PreCommitBatchMinSavings: tc.minSavings}
+
+			batch, savings, err := batchPays(cfg, tc.nv, n, tc.bf, batchGas)
+			require.NoError(t, err)
+			require.Equal(t, tc.batch, batch, "savings %s", savings)
+
+			expect, err := batchSavings(tc.nv, n, tc.bf, batchGas)
+			require.NoError(t, err)
+			require.True(t, expect.Equals(savings))
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package sealing
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v8/miner"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// SimulatePreCommit computes the message the precommit of the sector would be
+// sent in on its own at the current head, going through the same address
+// selection and fund computation as a send, without queuing or sending it. It's
+// meant for checking fee and collateral settings; the batcher state isn't
+// changed.
+func (b *PreCommitBatcher) SimulatePreCommit(ctx context.Context, s SectorInfo, deposit abi.TokenAmount, in *miner.SectorPreCommitInfo) (sealiface.PreCommitSimulation, error) {
+	cfg, err := b.getConfig()
+	if err != nil {
+		return sealiface.PreCommitSimulation{}, xerrors.Errorf("getting config: %w", err)
+	}
+
+	if deposit.Nil() {
+		deposit = big.Zero()
+	}
+
+	p := &preCommitEntry{
+		deposit: deposit,
+		pci:     in,
+		si:      s,
+	}
+	if err := checkPreCommitEntry(p); err != nil {
+		return sealiface.PreCommitSimulation{}, xerrors.Errorf("sector %d: %w", s.SectorNumber, err)
+	}
+
+	ts, err := b.api.ChainHead(ctx)
+	if err != nil {
+		return sealiface.PreCommitSimulation{}, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	nv, err := b.api.StateNetworkVersion(ctx, ts.Key())
+	if err != nil {
+		return sealiface.PreCommitSimulation{}, xerrors.Errorf("couldn't get network version: %w", err)
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	bf := ts.MinTicketBlock().ParentBaseFee
+	if belowBatchBaseFee(cfg, bf, nv) {
+		mi, err := b.api.StateMinerInfo(ctx, b.maddr, ts.Key())
+		if err != nil {
+			return sealiface.PreCommitSimulation{}, xerrors.Errorf("couldn't get miner info: %w", err)
+		}
+
+		avail, err := b.individualAvailable(cfg, ts.Key())
+		if err != nil {
+			return sealiface.PreCommitSimulation{}, err
+		}
+
+		msg, _, err := b.singleMsg(cfg, mi, &avail, p)
+		if err != nil {
+			return sealiface.PreCommitSimulation{}, err
+		}
+
+		return sealiface.PreCommitSimulation{
+			From:         msg.From,
+			Method:       msg.Method,
+			Deposit:      deposit,
+			Value:        msg.Value,
+			MaxFee:       big.Int(b.feeCfg.MaxPreCommitGasFee),
+			AggregateFee: big.Zero(),
+
+			IndividualReason: sealiface.IndividualLowBaseFee,
+		}, nil
+	}
+
+	res, bm, err := b.assembleBatch(cfg, map[abi.SectorNumber]*preCommitEntry{s.SectorNumber: p}, ts.Key(), bf, nv)
+	if err != nil {
+		return sealiface.PreCommitSimulation{}, err
+	}
+
+	return sealiface.PreCommitSimulation{
+		From:         bm.msg.From,
+		Method:       bm.msg.Method,
+		Deposit:      res.Deposit,
+		Value:        bm.msg.Value,
+		MaxFee:       res.MaxFee,
+		AggregateFee: res.AggregateFee,
+	}, nil
+}
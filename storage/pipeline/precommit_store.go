@@ -0,0 +1,456 @@
+package sealing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v8/miner"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+const (
+	PreCommitQueuePrefix    = "/precommit-queue"
+	PreCommitInFlightPrefix = "/precommit-inflight"
+)
+
+// QueuedPreCommit is the stored form of a precommit waiting in the batcher queue
+type QueuedPreCommit struct {
+	SectorInfo   SectorInfo
+	Deposit      abi.TokenAmount
+	Info         miner.SectorPreCommitInfo
+	DeadlineHint *uint64
+	Queued       cbg.CborTime
+}
+
+// InFlightPreCommit is the stored form of a precommit message which was pushed,
+// but not seen on chain yet
+type InFlightPreCommit struct {
+	Msg     cid.Cid
+	Sectors []abi.SectorNumber
+	Sent    cbg.CborTime
+}
+
+// BatcherStore persists the precommit batcher queue, so that it can be restored
+// after a restart, or inspected by other tools. Sectors leave the queue once their
+// message is pushed; the message is kept as in flight until it's seen on chain, so
+// that the sectors aren't sent again after a restart.
+type BatcherStore interface {
+	Save(ctx context.Context, qp QueuedPreCommit) error
+	Load(ctx context.Context) ([]QueuedPreCommit, error)
+	Delete(ctx context.Context, sn abi.SectorNumber) error
+
+	SaveInFlight(ctx context.Context, ip InFlightPreCommit) error
+	LoadInFlight(ctx context.Context) ([]InFlightPreCommit, error)
+	DeleteInFlight(ctx context.Context, msg cid.Cid) error
+}
+
+type datastoreBatcherStore struct {
+	ds       datastore.Batching
+	inFlight datastore.Batching
+}
+
+// NewDatastoreBatcherStore returns a BatcherStore which keeps queued precommits in
+// the given datastore, under PreCommitQueuePrefix, and in-flight messages under
+// PreCommitInFlightPrefix
+func NewDatastoreBatcherStore(ds datastore.Batching) BatcherStore {
+	return &datastoreBatcherStore{
+		ds:       namespace.Wrap(ds, datastore.NewKey(PreCommitQueuePrefix)),
+		inFlight: namespace.Wrap(ds, datastore.NewKey(PreCommitInFlightPrefix)),
+	}
+}
+
+func queueKey(sn abi.SectorNumber) datastore.Key {
+	return datastore.NewKey(fmt.Sprint(sn))
+}
+
+func inFlightKey(msg cid.Cid) datastore.Key {
+	return datastore.NewKey(msg.String())
+}
+
+func (s *datastoreBatcherStore) Save(ctx context.Context, qp QueuedPreCommit) error {
+	var b bytes.Buffer
+	if err := qp.MarshalCBOR(&b); err != nil {
+		return xerrors.Errorf("marshaling queued precommit: %w", err)
+	}
+
+	return s.ds.Put(ctx, queueKey(qp.SectorInfo.SectorNumber), b.Bytes())
+}
+
+func (s *datastoreBatcherStore) Load(ctx context.Context) ([]QueuedPreCommit, error) {
+	res, err := s.ds.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, xerrors.Errorf("querying queued precommits: %w", err)
+	}
+	defer res.Close() //nolint:errcheck
+
+	var out []QueuedPreCommit
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, xerrors.Errorf("reading queued precommit: %w", r.Error)
+		}
+
+		var qp QueuedPreCommit
+		if err := qp.UnmarshalCBOR(bytes.NewReader(r.Value)); err != nil {
+			return nil, xerrors.Errorf("unmarshaling queued precommit %s: %w", r.Key, err)
+		}
+		out = append(out, qp)
+	}
+
+	return out, nil
+}
+
+func (s *datastoreBatcherStore) Delete(ctx context.Context, sn abi.SectorNumber) error {
+	return s.ds.Delete(ctx, queueKey(sn))
+}
+
+func (s *datastoreBatcherStore) SaveInFlight(ctx context.Context, ip InFlightPreCommit) error {
+	var b bytes.Buffer
+	if err := ip.MarshalCBOR(&b); err != nil {
+		return xerrors.Errorf("marshaling in-flight precommit: %w", err)
+	}
+
+	return s.inFlight.Put(ctx, inFlightKey(ip.Msg), b.Bytes())
+}
+
+func (s *datastoreBatcherStore) LoadInFlight(ctx context.Context) ([]InFlightPreCommit, error) {
+	res, err := s.inFlight.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, xerrors.Errorf("querying in-flight precommits: %w", err)
+	}
+	defer res.Close() //nolint:errcheck
+
+	var out []InFlightPreCommit
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, xerrors.Errorf("reading in-flight precommit: %w", r.Error)
+		}
+
+		var ip InFlightPreCommit
+		if err := ip.UnmarshalCBOR(bytes.NewReader(r.Value)); err != nil {
+			return nil, xerrors.Errorf("unmarshaling in-flight precommit %s: %w", r.Key, err)
+		}
+		out = append(out, ip)
+	}
+
+	return out, nil
+}
+
+func (s *datastoreBatcherStore) DeleteInFlight(ctx context.Context, msg cid.Cid) error {
+	return s.inFlight.Delete(ctx, inFlightKey(msg))
+}
+
+var _ BatcherStore = &datastoreBatcherStore{}
+
+// inFlightLookback bounds how far back in the chain in-flight messages are searched
+// for. A precommit can't land later than its seal randomness allows, so a message
+// which isn't found within this range won't be found further back either.
+var inFlightLookback = policy.MaxPreCommitRandomnessLookback
+
+// restoreQueue queues the stored precommits again, and reconciles the stored
+// in-flight messages. Restored sectors keep the time they were first queued at,
+// and are sent with the next batch whether or not they are added again.
+func (b *PreCommitBatcher) restoreQueue() {
+	qps, err := b.store.Load(b.mctx)
+	if err != nil {
+		log.Errorw("loading stored precommit queue", "error", err)
+		qps = nil
+	}
+	ips, err := b.store.LoadInFlight(b.mctx)
+	if err != nil {
+		log.Errorw("loading in-flight precommits", "error", err)
+		ips = nil
+	}
+
+	if len(qps) == 0 && len(ips) == 0 {
+		return
+	}
+
+	// without the head the stored entries are kept, the sectors are queued once
+	// added again
+	ts, err := b.api.ChainHead(b.mctx)
+	if err != nil {
+		log.Errorw("getting chain head, not restoring the precommit queue", "error", err)
+		return
+	}
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	for _, qp := range qps {
+		b.restoreLocked(ts, qp)
+	}
+	if len(qps) > 0 {
+		b.recordQueuedLocked()
+		log.Infow("restored precommit queue", "sectors", len(qps))
+	}
+
+	// after the queue, so that sectors which were already sent are taken out of it
+	if len(ips) > 0 {
+		b.reconcileInFlightLocked(ts, ips)
+	}
+}
+
+// restoreLocked queues the stored precommit, with its cutoff computed at the
+// current height. Must be called with b.lk held.
+func (b *PreCommitBatcher) restoreLocked(ts *types.TipSet, qp QueuedPreCommit) {
+	sn := qp.SectorInfo.SectorNumber
+
+	// a cutoff which passed while the node was down makes the sector urgent, it is
+	// sent with the first send attempt
+	cutoffEpoch, err := b.cutoffStrategy.PreCommitCutoff(ts.Height(), qp.SectorInfo)
+	if err != nil {
+		log.Warnw("restored precommit is past its cutoff, sending it right away", "sector", sn, "error", err)
+	}
+
+	pci := qp.Info
+	b.cutoffs[sn] = b.cutoffTime(ts.Height(), cutoffEpoch)
+	b.todo[sn] = &preCommitEntry{
+		deposit: qp.Deposit,
+		pci:     &pci,
+		si:      qp.SectorInfo,
+
+		deadlineHint: qp.DeadlineHint,
+
+		cutoffEpoch: cutoffEpoch,
+		queued:      qp.Queued.Time(),
+
+		margin: cutoffEpoch - ts.Height(),
+
+		dealValue: qp.SectorInfo.dealValue(),
+	}
+	b.restored[sn] = struct{}{}
+}
+
+// reconcileInFlightLocked checks the stored in-flight messages against the mpool
+// and the chain. Sectors of messages which are still pending or landed aren't sent
+// again: when they are added again, they get a result pointing at the message
+// right away. Sectors of messages which were dropped are sent again. Records of
+// pending messages are deleted once the messages land. Must be called with b.lk
+// held.
+func (b *PreCommitBatcher) reconcileInFlightLocked(ts *types.TipSet, ips []InFlightPreCommit) {
+	// without the mpool the records are kept, to be checked on the next start
+	pending, err := b.api.MpoolPending(b.mctx, ts.Key())
+	if err != nil {
+		log.Errorw("getting pending messages, not checking in-flight precommits", "error", err)
+		return
+	}
+	inMpool := map[cid.Cid]struct{}{}
+	for _, sm := range pending {
+		inMpool[sm.Cid()] = struct{}{}
+	}
+
+	for _, ip := range ips {
+		msg := ip.Msg
+
+		if _, ok := inMpool[msg]; ok {
+			log.Infow("precommit message still pending in mpool, not sending its sectors again", "cid", msg, "sectors", ip.Sectors)
+			for _, sn := range ip.Sectors {
+				b.sent[sn] = sentPreCommit{msg: msg, sent: ip.Sent.Time(), height: ts.Height()}
+			}
+			b.markInFlightLocked(ip.Sectors, msg)
+			b.watchLandingLocked(msg)
+			continue
+		}
+
+		ml, err := b.api.StateSearchMsg(b.mctx, types.EmptyTSK, msg, inFlightLookback, true)
+		if err != nil {
+			log.Errorw("looking up in-flight precommit message, keeping it for the next start", "cid", msg, "error", err)
+			continue
+		}
+
+		if ml != nil {
+			log.Infow("in-flight precommit message landed", "cid", msg, "executed", ml.Message, "sectors", ip.Sectors, "height", ml.Height)
+			b.markInFlightLocked(ip.Sectors, ml.Message)
+		} else {
+			log.Warnw("in-flight precommit message was dropped, its sectors will be sent again", "cid", msg, "sectors", ip.Sectors)
+		}
+
+		b.deleteInFlight(msg)
+	}
+}
+
+// markInFlightLocked makes the sectors, which were sent in msg before a restart,
+// get a result pointing at msg when they are added again. Must be called with b.lk
+// held.
+func (b *PreCommitBatcher) markInFlightLocked(sectors []abi.SectorNumber, msg cid.Cid) {
+	for _, sn := range sectors {
+		b.inFlight[sn] = msg
+
+		// a queued copy would be sent again
+		if _, queued := b.todo[sn]; queued {
+			b.dequeueLocked(sn)
+			delete(b.cutoffs, sn)
+		}
+	}
+}
+
+// sentBefore returns the message a precommit of the sector was already sent in,
+// if that message is still pending in the mpool or landed successfully. Sectors
+// reconciled on startup are taken from b.inFlight, others are looked up in the
+// messages sent since.
+func (b *PreCommitBatcher) sentBefore(ts *types.TipSet, sn abi.SectorNumber) (cid.Cid, bool, error) {
+	b.lk.Lock()
+	msg, reconciled := b.inFlight[sn]
+	delete(b.inFlight, sn)
+	sp, sent := b.sent[sn]
+	b.lk.Unlock()
+
+	if reconciled {
+		return msg, true, nil
+	}
+	if !sent {
+		return cid.Undef, false, nil
+	}
+
+	executed, live, err := b.liveMsg(ts, sp.msg)
+	if err != nil {
+		return cid.Undef, false, err
+	}
+	if live {
+		return executed, true, nil
+	}
+
+	return cid.Undef, false, nil
+}
+
+// liveMsg returns whether the message landed successfully, or is still pending in
+// the mpool, along with the CID of the message which was executed
+func (b *PreCommitBatcher) liveMsg(ts *types.TipSet, msg cid.Cid) (cid.Cid, bool, error) {
+	ml, err := b.api.StateSearchMsg(b.mctx, types.EmptyTSK, msg, inFlightLookback, true)
+	if err != nil {
+		return cid.Undef, false, xerrors.Errorf("looking up precommit message %s: %w", msg, err)
+	}
+	if ml != nil {
+		return ml.Message, ml.Receipt.ExitCode.IsSuccess(), nil
+	}
+
+	pending, err := b.api.MpoolPending(b.mctx, ts.Key())
+	if err != nil {
+		return cid.Undef, false, xerrors.Errorf("getting pending messages: %w", err)
+	}
+	for _, sm := range pending {
+		if sm.Cid() == msg {
+			return msg, true, nil
+		}
+	}
+
+	return cid.Undef, false, nil
+}
+
+// storeInFlightLocked records the pushed message of the sectors, if a store is
+// set. The record is deleted once the message lands. Must be called with b.lk held.
+func (b *PreCommitBatcher) storeInFlightLocked(msg cid.Cid, sectors []abi.SectorNumber) {
+	if b.store == nil {
+		return
+	}
+
+	err := b.store.SaveInFlight(b.mctx, InFlightPreCommit{
+		Msg:     msg,
+		Sectors: sectors,
+		Sent:    cbg.CborTime(b.clock.Now()),
+	})
+	if err != nil {
+		log.Warnw("storing in-flight precommit message", "cid", msg, "error", err)
+		return
+	}
+
+	b.watchLandingLocked(msg)
+}
+
+// watchLandingLocked deletes the in-flight record of the message once it lands on
+// chain. Records of messages which haven't landed when the batcher stops are kept,
+// and reconciled on the next start. Must be called with b.lk held.
+func (b *PreCommitBatcher) watchLandingLocked(msg cid.Cid) {
+	b.landWatchers.Add(1)
+	go func() {
+		defer b.landWatchers.Done()
+
+		ctx, cancel := context.WithCancel(b.mctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-b.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		ml, err := b.api.StateWaitMsg(ctx, msg, build.MessageConfidence, inFlightLookback, true)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warnw("waiting for in-flight precommit message, keeping it for the next start", "cid", msg, "error", err)
+			}
+			return
+		}
+
+		log.Debugw("in-flight precommit message landed", "cid", msg, "executed", ml.Message, "height", ml.Height)
+		b.deleteInFlight(msg)
+	}()
+}
+
+// deleteInFlight forgets the in-flight message, once it was seen on chain
+func (b *PreCommitBatcher) deleteInFlight(msg cid.Cid) {
+	if b.store == nil {
+		return
+	}
+
+	if err := b.store.DeleteInFlight(b.mctx, msg); err != nil {
+		log.Warnw("deleting in-flight precommit message", "cid", msg, "error", err)
+	}
+}
+
+// storeLocked saves the queued precommit of the sector, if a store is set. Must be
+// called with b.lk held.
+func (b *PreCommitBatcher) storeLocked(sn abi.SectorNumber) {
+	p, ok := b.todo[sn]
+	if b.store == nil || !ok {
+		return
+	}
+
+	err := b.store.Save(b.mctx, QueuedPreCommit{
+		SectorInfo:   p.si,
+		Deposit:      p.deposit,
+		Info:         *p.pci,
+		DeadlineHint: p.deadlineHint,
+		Queued:       cbg.CborTime(p.queued),
+	})
+	if err != nil {
+		log.Warnw("storing queued precommit", "sector", sn, "error", err)
+	}
+}
+
+// dequeueLocked removes the sector from the queue, and from the store if one is
+// set. Must be called with b.lk held.
+func (b *PreCommitBatcher) dequeueLocked(sn abi.SectorNumber) {
+	if _, ok := b.todo[sn]; !ok {
+		return
+	}
+	delete(b.todo, sn)
+	b.recordQueuedLocked()
+	b.signalQueueSpaceLocked()
+
+	b.deleteStoredLocked(sn)
+}
+
+// deleteStoredLocked removes the sector from the store, if one is set. Must be
+// called with b.lk held.
+func (b *PreCommitBatcher) deleteStoredLocked(sn abi.SectorNumber) {
+	if b.store == nil {
+		return
+	}
+	if err := b.store.Delete(b.mctx, sn); err != nil {
+		log.Warnw("deleting stored precommit", "sector", sn, "error", err)
+	}
+}
@@ -0,0 +1,126 @@
+package sealing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	markettypes "github.com/filecoin-project/go-state-types/builtin/v8/market"
+	"github.com/filecoin-project/go-state-types/builtin/v8/miner"
+	tutils "github.com/filecoin-project/specs-actors/v2/support/testing"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestBatcherStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	dummyCid, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+
+	label, err := markettypes.NewLabelFromString("label")
+	require.NoError(t, err)
+
+	pci := miner.SectorPreCommitInfo{
+		SealProof:              abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+		SectorNumber:           234,
+		SealedCID:              dummyCid,
+		SealRandEpoch:          345,
+		DealIDs:                []abi.DealID{1234},
+		Expiration:             540000,
+		ReplaceCapacity:        true,
+		ReplaceSectorDeadline:  3,
+		ReplaceSectorPartition: 1,
+		ReplaceSectorNumber:    12,
+	}
+
+	hint := uint64(7)
+	qp := QueuedPreCommit{
+		SectorInfo: SectorInfo{
+			State:        PreCommitBatchWait,
+			SectorNumber: 234,
+			SectorType:   abi.RegisteredSealProof_StackedDrg32GiBV1_1,
+			CreationTime: 1650000000,
+			Pieces: []Piece{{
+				Piece: abi.PieceInfo{
+					Size:     2048,
+					PieceCID: dummyCid,
+				},
+				DealInfo: &api.PieceDealInfo{
+					PublishCid: &dummyCid,
+					DealID:     1234,
+					DealProposal: &markettypes.DealProposal{
+						PieceCID:             dummyCid,
+						PieceSize:            2048,
+						VerifiedDeal:         true,
+						Client:               tutils.NewActorAddr(t, "client"),
+						Provider:             tutils.NewActorAddr(t, "provider"),
+						Label:                label,
+						StartEpoch:           1000,
+						EndEpoch:             540000,
+						StoragePricePerEpoch: abi.NewTokenAmount(10),
+						ProviderCollateral:   abi.NewTokenAmount(20),
+						ClientCollateral:     abi.NewTokenAmount(15),
+					},
+					DealSchedule: api.DealSchedule{
+						StartEpoch: 1000,
+						EndEpoch:   540000,
+					},
+					KeepUnsealed: true,
+				},
+			}},
+			TicketValue:      []byte{87, 78, 7, 87},
+			TicketEpoch:      345,
+			PreCommit1Out:    []byte{1, 2, 3},
+			CommD:            &dummyCid,
+			CommR:            &dummyCid,
+			Proof:            []byte{4, 5, 6},
+			PreCommitInfo:    &pci,
+			PreCommitDeposit: big.NewInt(1000),
+			PreCommitTipSet:  types.NewTipSetKey(dummyCid),
+			LastErr:          "hi",
+		},
+		Deposit:      big.NewInt(1000),
+		Info:         pci,
+		DeadlineHint: &hint,
+		Queued:       cbg.CborTime(time.Unix(0, 1650000000123456789)),
+	}
+
+	ip := InFlightPreCommit{
+		Msg:     dummyCid,
+		Sectors: []abi.SectorNumber{234, 235},
+		Sent:    cbg.CborTime(time.Unix(0, 1650000001123456789)),
+	}
+
+	store := NewDatastoreBatcherStore(datastore.NewMapDatastore())
+
+	require.NoError(t, store.Save(ctx, qp))
+	require.NoError(t, store.SaveInFlight(ctx, ip))
+
+	qps, err := store.Load(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []QueuedPreCommit{qp}, qps)
+
+	ips, err := store.LoadInFlight(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []InFlightPreCommit{ip}, ips)
+
+	require.NoError(t, store.Delete(ctx, qp.SectorInfo.SectorNumber))
+	require.NoError(t, store.DeleteInFlight(ctx, ip.Msg))
+
+	qps, err = store.Load(ctx)
+	require.NoError(t, err)
+	require.Empty(t, qps)
+
+	ips, err = store.LoadInFlight(ctx)
+	require.NoError(t, err)
+	require.Empty(t, ips)
+}
@@ -0,0 +1,80 @@
+package sealing
+
+import (
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// number of send records which can wait to be exported before new records are dropped
+const telemetryBuffer = 64
+
+// TelemetrySink receives a record of each precommit batcher send attempt, e.g. to
+// export it to a custom collector. Sends are called in order from a separate
+// goroutine, so a slow sink doesn't hold up the batcher, but records are dropped
+// when the sink falls too far behind.
+type TelemetrySink interface {
+	RecordSend(d PreCommitDecision, res []sealiface.PreCommitBatchRes)
+}
+
+type telemetryRecord struct {
+	d   PreCommitDecision
+	res []sealiface.PreCommitBatchRes
+}
+
+// telemetryExporter passes send records to a TelemetrySink without blocking the
+// batcher run loop
+type telemetryExporter struct {
+	records chan telemetryRecord
+	done    chan struct{}
+}
+
+func newTelemetryExporter(sink TelemetrySink) *telemetryExporter {
+	e := &telemetryExporter{
+		records: make(chan telemetryRecord, telemetryBuffer),
+		done:    make(chan struct{}),
+	}
+
+	go e.run(sink)
+
+	return e
+}
+
+func (e *telemetryExporter) run(sink TelemetrySink) {
+	defer close(e.done)
+
+	for r := range e.records {
+		sink.RecordSend(r.d, r.res)
+	}
+}
+
+func (e *telemetryExporter) record(d PreCommitDecision, res []sealiface.PreCommitBatchRes) {
+	// results are also handed to waiters and Flush callers, don't share the slice
+	res = append([]sealiface.PreCommitBatchRes(nil), res...)
+
+	select {
+	case e.records <- telemetryRecord{d: d, res: res}:
+	default:
+		log.Warnw("precommit telemetry sink is behind, dropping record", "height", d.Height, "path", d.Path)
+	}
+}
+
+// close passes buffered records to the sink and waits for it to finish
+func (e *telemetryExporter) close() {
+	close(e.records)
+	<-e.done
+}
+
+// SetTelemetrySink makes the batcher pass a record of each send attempt, with its
+// results, to sink, replacing the previously set sink. A nil sink disables it.
+func (b *PreCommitBatcher) SetTelemetrySink(sink TelemetrySink) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if b.telemetry != nil {
+		b.telemetry.close()
+		b.telemetry = nil
+	}
+
+	if sink != nil {
+		b.telemetry = newTelemetryExporter(sink)
+	}
+}
@@ -0,0 +1,69 @@
+package sealing
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// BatchTiming reports how many sectors are queued and when the next automatic
+// send attempt is expected, e.g. for dashboards. Like DrainEstimate, it doesn't
+// account for deferrals which depend on the chain at the time of the send.
+func (b *PreCommitBatcher) BatchTiming(ctx context.Context) (sealiface.PreCommitBatchTiming, error) {
+	cfg, err := b.getConfig()
+	if err != nil {
+		return sealiface.PreCommitBatchTiming{}, xerrors.Errorf("getting config: %w", err)
+	}
+
+	ts, err := b.api.ChainHead(ctx)
+	if err != nil {
+		return sealiface.PreCommitBatchTiming{}, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	nv, err := b.api.StateNetworkVersion(ctx, ts.Key())
+	if err != nil {
+		return sealiface.PreCommitBatchTiming{}, xerrors.Errorf("couldn't get network version: %w", err)
+	}
+
+	bf := ts.MinTicketBlock().ParentBaseFee
+
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	// decided the same way as by the next automatic send attempt
+	reason, err := b.individualReasonLocked(cfg, bf, nv, false)
+	if err != nil {
+		return sealiface.PreCommitBatchTiming{}, err
+	}
+
+	t := sealiface.PreCommitBatchTiming{
+		Queued:     len(b.todo),
+		Individual: reason != "",
+		BaseFee:    bf,
+	}
+
+	for _, p := range b.todo {
+		if p.cutoffEpoch != 0 && (t.EarliestCutoff == 0 || p.cutoffEpoch < t.EarliestCutoff) {
+			t.EarliestCutoff = p.cutoffEpoch
+		}
+	}
+
+	// full batches are sent right away
+	if t.Queued < cfg.MaxPreCommitBatch {
+		t.NextSend = b.batchWaitLocked(cfg, b.clock.Now())
+	}
+
+	return t, nil
+}
+
+// belowBatchBaseFee returns whether the base fee is below BatchPreCommitAboveBaseFee,
+// in which case precommits are sent individually
+func belowBatchBaseFee(cfg sealiface.Config, bf abi.TokenAmount, nv network.Version) bool {
+	return !cfg.BatchPreCommitAboveBaseFee.Equals(big.Zero()) && bf.LessThan(cfg.BatchPreCommitAboveBaseFee) && nv >= network.Version14
+}
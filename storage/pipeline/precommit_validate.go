@@ -0,0 +1,222 @@
+package sealing
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/storage/pipeline/sealiface"
+)
+
+// BatchValidation is the state a send attempt is validated against
+type BatchValidation struct {
+	Config         sealiface.Config
+	TipSet         *types.TipSet
+	NetworkVersion network.Version
+}
+
+// BatchValidator checks the queued precommits before they are sent. It returns the
+// sectors which must not be sent, with the reason for each; those fail without
+// being sent. An error rejects the whole send attempt, all sectors stay queued
+// until the next one.
+//
+// Validators are called with the batcher lock held, and must not call back into
+// the batcher.
+type BatchValidator interface {
+	ValidateBatch(ctx context.Context, v BatchValidation, sectors []QueuedPreCommit) (map[abi.SectorNumber]string, error)
+}
+
+// DefaultBatchValidators returns the validators run by the batcher unless
+// replaced with SetBatchValidators. The randomness, expiration and deposit checks
+// follow the config options documented on their validators.
+func DefaultBatchValidators() []BatchValidator {
+	return []BatchValidator{
+		RandomnessValidator{},
+		ExpirationValidator{},
+		SealProofValidator{},
+		DepositValidator{},
+	}
+}
+
+// RandomnessValidator drops sectors whose seal randomness is too old to be
+// accepted on chain, unless PreCommitBatchSkipRandomnessCheck is set
+type RandomnessValidator struct{}
+
+func (RandomnessValidator) ValidateBatch(ctx context.Context, v BatchValidation, sectors []QueuedPreCommit) (map[abi.SectorNumber]string, error) {
+	if v.Config.PreCommitBatchSkipRandomnessCheck {
+		return nil, nil
+	}
+
+	drop := map[abi.SectorNumber]string{}
+	earliest := v.TipSet.Height() - policy.MaxPreCommitRandomnessLookback
+	for _, qp := range sectors {
+		if qp.Info.SealRandEpoch < earliest {
+			drop[qp.Info.SectorNumber] = fmt.Sprintf("seal randomness epoch %d is before the earliest accepted epoch %d", qp.Info.SealRandEpoch, earliest)
+		}
+	}
+
+	return drop, nil
+}
+
+// ExpirationValidator drops sectors whose lifetime would be too short if they
+// were precommitted at the current head. Sectors are checked when they are added,
+// this catches ones which waited in the queue for long enough to fall below the
+// minimum.
+type ExpirationValidator struct{}
+
+func (ExpirationValidator) ValidateBatch(ctx context.Context, v BatchValidation, sectors []QueuedPreCommit) (map[abi.SectorNumber]string, error) {
+	if v.Config.PreCommitBatchSkipExpirationCheck {
+		return nil, nil
+	}
+
+	drop := map[abi.SectorNumber]string{}
+	for _, qp := range sectors {
+		qp := qp
+		if err := checkExpirationAt(v.NetworkVersion, v.TipSet.Height(), qp.SectorInfo, &qp.Info); err != nil {
+			drop[qp.Info.SectorNumber] = err.Error()
+		}
+	}
+
+	return drop, nil
+}
+
+// SealProofValidator drops sectors whose precommit seal proof doesn't match the
+// seal proof type of the sector
+type SealProofValidator struct{}
+
+func (SealProofValidator) ValidateBatch(ctx context.Context, v BatchValidation, sectors []QueuedPreCommit) (map[abi.SectorNumber]string, error) {
+	drop := map[abi.SectorNumber]string{}
+	for _, qp := range sectors {
+		if qp.Info.SealProof != qp.SectorInfo.SectorType {
+			drop[qp.Info.SectorNumber] = fmt.Sprintf("precommit seal proof %d doesn't match sector seal proof %d", qp.Info.SealProof, qp.SectorInfo.SectorType)
+		}
+	}
+
+	return drop, nil
+}
+
+// DepositValidator drops sectors whose deposit is below the
+// PreCommitBatchMinDepositPer32GiB floor, when PreCommitBatchRejectLowDeposit is
+// set. Otherwise low deposits are only warned about when sectors are added.
+type DepositValidator struct{}
+
+func (DepositValidator) ValidateBatch(ctx context.Context, v BatchValidation, sectors []QueuedPreCommit) (map[abi.SectorNumber]string, error) {
+	if !v.Config.PreCommitBatchRejectLowDeposit {
+		return nil, nil
+	}
+
+	drop := map[abi.SectorNumber]string{}
+	for _, qp := range sectors {
+		qp := qp
+		if err := checkMinDeposit(v.Config, &qp.Info, qp.Deposit); err != nil {
+			drop[qp.Info.SectorNumber] = err.Error()
+		}
+	}
+
+	return drop, nil
+}
+
+// SetBatchValidators replaces the validators run before each send, in order. Pass
+// DefaultBatchValidators along with custom validators to keep the default checks.
+// No validators disables validation.
+func (b *PreCommitBatcher) SetBatchValidators(vs ...BatchValidator) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	b.validators = vs
+}
+
+// validateLocked runs the validators on the queued sectors, removing the dropped
+// ones from the queue and returning failed results for them. Each validator only
+// sees the sectors kept by the ones before it. Nothing is removed when a validator
+// rejects the send. Must be called with b.lk held.
+func (b *PreCommitBatcher) validateLocked(cfg sealiface.Config, ts *types.TipSet, nv network.Version) ([]sealiface.PreCommitBatchRes, error) {
+	v := BatchValidation{
+		Config:         cfg,
+		TipSet:         ts,
+		NetworkVersion: nv,
+	}
+
+	var res []sealiface.PreCommitBatchRes
+	dropped := map[abi.SectorNumber]struct{}{}
+	for _, vr := range b.validators {
+		sectors := make([]QueuedPreCommit, 0, len(b.todo))
+		for sn, p := range b.todo {
+			if _, ok := dropped[sn]; ok {
+				continue
+			}
+
+			sectors = append(sectors, QueuedPreCommit{
+				SectorInfo:   p.si,
+				Deposit:      p.deposit,
+				Info:         *p.pci,
+				DeadlineHint: p.deadlineHint,
+				Queued:       cbg.CborTime(p.queued),
+			})
+		}
+		if len(sectors) == 0 {
+			break
+		}
+		sort.Slice(sectors, func(i, j int) bool {
+			return sectors[i].Info.SectorNumber < sectors[j].Info.SectorNumber
+		})
+
+		drop, err := vr.ValidateBatch(b.mctx, v, sectors)
+		if err != nil {
+			log.Errorw("precommit validator rejected the send", "validator", fmt.Sprintf("%T", vr), "sectors", len(sectors), "error", err)
+			return nil, xerrors.Errorf("validator %T: %w", vr, err)
+		}
+
+		for _, qp := range sectors {
+			sn := qp.Info.SectorNumber
+			reason, ok := drop[sn]
+			if !ok {
+				continue
+			}
+
+			log.Errorw("dropping precommit rejected by validator", "sector", sn, "validator", fmt.Sprintf("%T", vr), "reason", reason)
+
+			res = append(res, sealiface.PreCommitBatchRes{
+				Sectors:        []abi.SectorNumber{sn},
+				NetworkVersion: nv,
+				Error:          reason,
+			})
+			dropped[sn] = struct{}{}
+		}
+	}
+
+	for sn := range dropped {
+		b.dequeueLocked(sn)
+	}
+
+	return res, nil
+}
+
+// checkPreCommitEntry checks that the entry can be added to a batch message.
+// Entries which fail it are left out of the batch, without failing the other
+// sectors.
+func checkPreCommitEntry(p *preCommitEntry) error {
+	if p.pci == nil {
+		return xerrors.New("missing precommit info")
+	}
+	if !p.pci.SealedCID.Defined() {
+		return xerrors.New("sealed CID not set")
+	}
+	if _, err := commcid.CIDToReplicaCommitmentV1(p.pci.SealedCID); err != nil {
+		return xerrors.Errorf("invalid sealed CID %s: %w", p.pci.SealedCID, err)
+	}
+	if p.deposit.Nil() || p.deposit.Sign() < 0 {
+		return xerrors.Errorf("invalid deposit %s", p.deposit)
+	}
+
+	return nil
+}
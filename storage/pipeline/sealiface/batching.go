@@ -1,9 +1,14 @@
 package sealiface
 
 import (
+	"time"
+
 	"github.com/ipfs/go-cid"
 
+	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/network"
 )
 
 type CommitBatchRes struct {
@@ -15,9 +20,218 @@ type CommitBatchRes struct {
 	Error string // if set, means that all sectors are failed, implies Msg==nil
 }
 
+// reasons for sending precommits in individual messages
+const (
+	IndividualLowBaseFee      = "low-basefee"      // base fee below BatchPreCommitAboveBaseFee
+	IndividualNoSavings       = "no-savings"       // batching estimated not to be cheaper
+	IndividualDiagnoseReverts = "diagnose-reverts" // DiagnoseBatchReverts is set
+	IndividualUrgent          = "urgent"           // close to the cutoff, with PreCommitBatchSendUrgentIndividually
+	IndividualUrgentDeal      = "urgent-deal"      // the only urgent sector, with deals, with PreCommitBatchSendUrgentDealAlone
+	IndividualForced          = "forced"           // sent by ForceIndividual
+)
+
 type PreCommitBatchRes struct {
 	Sectors []abi.SectorNumber
 
+	// network version under which the message params and fees were computed
+	NetworkVersion network.Version
+
+	// cids of the tipset the base fee and network version were read at
+	TipSet []cid.Cid
+
+	// cutoff epoch of the sector which made the batch get sent before reaching
+	// MaxPreCommitBatch sectors, 0 if the send wasn't forced by a cutoff
+	ForcedByCutoff abi.ChainEpoch
+
+	// proving deadline hint shared by all sectors in the message, nil if there
+	// was no hint, or sectors had different hints
+	DeadlineHint *uint64
+
+	// number of sectors left in the queue because the batch reached
+	// MaxPreCommitBatch sectors
+	DeferredFull int
+
+	// fee components of the sent message; the max fee is the most the message
+	// can pay for gas, the aggregate fee is the provisioned network fee
+	MaxFee       abi.TokenAmount
+	AggregateFee abi.TokenAmount
+
+	// MaxFee plus AggregateFee divided by the number of sectors, deposits excluded
+	CostPerSector abi.TokenAmount
+
+	// precommit deposit of the sectors in the message, part of which may be paid
+	// from the miner balance
+	Deposit abi.TokenAmount
+
+	// distinct seal proof types of the sectors in the message, more than one
+	// means that sectors of different sizes were batched together
+	SealProofs []abi.RegisteredSealProof
+
+	// why the sector was sent in its own message instead of a batch, one of the
+	// Individual* constants; empty for batches
+	IndividualReason string
+
+	// time until the next send attempt, as computed when the sector was queued;
+	// only set on results returned from AddPreCommit
+	ExpectedWait time.Duration
+
+	// nonce the message was sent with, set when Msg is
+	Nonce uint64
+
+	// address which sent and paid for the message, set when Msg is
+	From address.Address
+
+	// sectors left out of the batch because their precommit was invalid, with
+	// the reason for each; the other sectors were still sent
+	FailedSectors map[abi.SectorNumber]string
+
 	Msg   *cid.Cid
 	Error string // if set, means that all sectors are failed, implies Msg==nil
 }
+
+// PreCommitPending describes a queued precommit
+type PreCommitPending struct {
+	Sector  abi.SectorNumber
+	Deposit abi.TokenAmount
+
+	// epoch by which the precommit has to land on chain
+	CutoffEpoch abi.ChainEpoch
+
+	// time until the cutoff minus PreCommitBatchSlack, when the sector is sent
+	// even if the batch isn't full; negative once that time has passed
+	UntilCutoff time.Duration
+}
+
+// PreCommitBatcherRunState is a snapshot of the precommit batcher run loop, for
+// diagnosing stalls. The force channel is unbuffered, so pending flushes can't be
+// observed.
+type PreCommitBatcherRunState struct {
+	// buffered add notifications, at most 1
+	NotifyPending int
+
+	// Stop was called, and the run loop exited
+	Stopping bool
+	Stopped  bool
+
+	// whether the run loop is inside a send attempt, and since when
+	InSendAttempt    bool
+	SendAttemptStart time.Time
+
+	// flushes which joined the send attempt, with PreCommitBatchFlushDuringSend
+	// set to "merge"
+	JoinedFlushes int
+}
+
+// PreCommitBatchTiming describes when the next automatic precommit send is expected
+type PreCommitBatchTiming struct {
+	// number of queued sectors
+	Queued int
+
+	// earliest cutoff epoch of the queued sectors, 0 if none is queued
+	EarliestCutoff abi.ChainEpoch
+
+	// time from now until the next send attempt, 0 when a full batch is queued
+	NextSend time.Duration
+
+	// whether the next automatic send would send sectors individually, decided
+	// like the send itself does, e.g. because the base fee is below
+	// BatchPreCommitAboveBaseFee or batching doesn't save enough
+	Individual bool
+	BaseFee    abi.TokenAmount
+}
+
+// PreCommitSimulation describes the message a precommit would be sent in, as
+// computed without sending it
+type PreCommitSimulation struct {
+	From   address.Address
+	Method abi.MethodNum
+
+	// precommit deposit of the sector, and the part of it sent with the message;
+	// the rest is paid from the miner balance
+	Deposit abi.TokenAmount
+	Value   abi.TokenAmount
+
+	MaxFee       abi.TokenAmount
+	AggregateFee abi.TokenAmount
+
+	// why the sector would be sent in its own message, one of the Individual*
+	// constants; empty when it would be batched
+	IndividualReason string
+}
+
+// QueueAges counts queued precommits by how long they have been waiting
+type QueueAges struct {
+	Under1m  int
+	Under5m  int
+	Under30m int
+	Over30m  int
+}
+
+// FlushSummary aggregates the per-message results of a flush
+type FlushSummary struct {
+	Messages      int // messages which were sent
+	SectorsSent   int
+	SectorsFailed int
+
+	// sums over sent messages
+	Deposit abi.TokenAmount
+	Fees    abi.TokenAmount // max fees and aggregate fees
+
+	Errors []string
+}
+
+// SummarizeFlush aggregates flush results into a single summary
+func SummarizeFlush(res []PreCommitBatchRes) FlushSummary {
+	sum := FlushSummary{
+		Deposit: big.Zero(),
+		Fees:    big.Zero(),
+	}
+
+	for _, r := range res {
+		if r.Error != "" || r.Msg == nil {
+			sum.SectorsFailed += len(r.Sectors)
+			if r.Error != "" {
+				sum.Errors = append(sum.Errors, r.Error)
+			}
+			continue
+		}
+
+		sum.Messages++
+		sum.SectorsSent += len(r.Sectors)
+
+		sum.Deposit = addAmount(sum.Deposit, r.Deposit)
+		sum.Fees = addAmount(addAmount(sum.Fees, r.MaxFee), r.AggregateFee)
+	}
+
+	return sum
+}
+
+// addAmount adds b to a, treating an unset b as zero
+func addAmount(a, b abi.TokenAmount) abi.TokenAmount {
+	if b.Nil() {
+		return a
+	}
+	return big.Add(a, b)
+}
+
+// PreCommitBatchCounters are running totals kept by the precommit batcher
+type PreCommitBatchCounters struct {
+	Messages      uint64 // precommit messages pushed to the mpool
+	SectorsSent   uint64
+	SectorsFailed uint64
+
+	// sum of the max fees of pushed messages
+	MaxFees abi.TokenAmount
+
+	// notifications which didn't wake up the batcher, as one was already pending
+	DroppedNotifies uint64
+}
+
+type PreCommitBatchStats struct {
+	// since the batcher was started
+	Total PreCommitBatchCounters
+
+	// since the last ResetStats call, or the start if it was never called
+	SinceReset PreCommitBatchCounters
+	LastReset  time.Time
+}
@@ -0,0 +1,49 @@
+package sealiface
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+)
+
+func TestSummarizeFlush(t *testing.T) {
+	c, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+
+	sum := SummarizeFlush([]PreCommitBatchRes{
+		{
+			Sectors:      []abi.SectorNumber{1, 2, 3},
+			Msg:          &c,
+			Deposit:      abi.NewTokenAmount(300),
+			MaxFee:       abi.NewTokenAmount(20),
+			AggregateFee: abi.NewTokenAmount(5),
+		},
+		{
+			Sectors: []abi.SectorNumber{4},
+			Msg:     &c,
+			Deposit: abi.NewTokenAmount(100),
+			MaxFee:  abi.NewTokenAmount(10),
+		},
+		{
+			Sectors: []abi.SectorNumber{5, 6},
+			Deposit: abi.NewTokenAmount(200),
+			MaxFee:  abi.NewTokenAmount(20),
+			Error:   "sending message failed",
+		},
+		{
+			// results without a message count as failed
+			Sectors: []abi.SectorNumber{7},
+		},
+	})
+
+	require.Equal(t, 2, sum.Messages)
+	require.Equal(t, 4, sum.SectorsSent)
+	require.Equal(t, 3, sum.SectorsFailed)
+	require.True(t, big.NewInt(400).Equals(sum.Deposit), sum.Deposit)
+	require.True(t, big.NewInt(35).Equals(sum.Fees), sum.Fees)
+	require.Equal(t, []string{"sending message failed"}, sum.Errors)
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
 )
 
 // this has to be in a separate package to not make lotus API depend on filecoin-ffi
@@ -40,10 +41,61 @@ type Config struct {
 	AvailableBalanceBuffer     abi.TokenAmount
 	DisableCollateralFallback  bool
 
-	BatchPreCommits     bool
-	MaxPreCommitBatch   int
-	PreCommitBatchWait  time.Duration
-	PreCommitBatchSlack time.Duration
+	BatchPreCommits                      bool
+	MaxPreCommitBatch                    int
+	PreCommitBatchWait                   time.Duration
+	PreCommitBatchSlack                  time.Duration
+	PreCommitBatchSendUrgentIndividually bool
+	PreCommitBatchManualSendMode         bool
+	PreCommitBatchManualSendIgnoreCutoff bool
+	PreCommitBatchDepositWarnThreshold   abi.TokenAmount
+	PreCommitBatchMaxChainLag            uint64
+	PreCommitBatchSkipExpirationCheck    bool
+	PreCommitBatchKeepWaitersOnStop      bool
+	PreCommitFeeTrendMode                bool
+	PreCommitBatchGasFeedback            bool
+	PreCommitBatchMaxSectorWait          time.Duration
+	PreCommitBatchBlockFillCheck         bool
+	PreCommitBatchDecisionLogPath        string
+	PreCommitBatchSkipRandomnessCheck    bool
+	PreCommitBatchCheckMpool             bool
+	PreCommitAddressPreferFeeCoverage    bool
+	PreCommitBatchMinDepositPer32GiB     abi.TokenAmount
+	PreCommitBatchRejectLowDeposit       bool
+	PreCommitBatchConfirmTimeoutEpochs   uint64
+	DiagnoseBatchReverts                 bool
+	PreCommitBatchMaxQueue               int
+	PreCommitBatchEvictOnFullQueue       bool
+	PreCommitBatchFeeBufferByVersion     map[network.Version]uint64
+	PreCommitBatchGroupByDeadlineHint    bool
+	PreCommitConfirmStrategy             string
+	PreCommitBatchFundsCheckInterval     time.Duration
+	PreCommitBatchSendWindowPeriod       uint64
+	PreCommitBatchSendWindowStart        uint64
+	PreCommitBatchSendWindowLength       uint64
+	PreCommitBatchAggFeeMode             string
+	PreCommitBatchCheckAllocation        bool
+	PreCommitBatchUpgradeGuardEpochs     uint64
+	PreCommitBatchEmergencySendAlert     int
+	PreCommitBatchAutoTuneWait           bool
+	PreCommitBatchVerifySenderBalance    bool
+	EconomicBatchDecision                bool
+	PreCommitBatchMinSavings             abi.TokenAmount
+	AdaptiveBatchWait                    bool
+	PreCommitBatchMaxWaitersPerSector    int
+	PreCommitBatchSendUrgentDealAlone    bool
+	PreCommitCollateralSources           []string
+	PreCommitBatchStartupGrace           time.Duration
+	PreCommitBatchFlushRespectsGrace     bool
+	PreCommitBatchFlushDuringSend        string
+	PreCommitBatchMaxBatchesPerFlush     int
+	PreCommitBatchMaxGasFraction         float64
+	MinPreCommitBatch                    int
+	PreCommitBatchBaseFeeCaps            []string
+	PreCommitBatchSendRetries            int
+	PreCommitBatchSendRetryBackoff       time.Duration
+	PreCommitBatchBlockOnFullQueue       bool
+	PreCommitRoundRobinAddresses         bool
 
 	AggregateCommits bool
 	MinCommitBatch   int
@@ -59,12 +59,17 @@ type SealingAPI interface {
 	StateMinerSectorAllocated(context.Context, address.Address, abi.SectorNumber, types.TipSetKey) (bool, error)
 	StateMarketStorageDeal(context.Context, abi.DealID, types.TipSetKey) (*api.MarketDeal, error)
 	StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error)
+	StateGetNetworkParams(ctx context.Context) (*api.NetworkParams, error)
 	StateMinerProvingDeadline(context.Context, address.Address, types.TipSetKey) (*dline.Info, error)
 	StateMinerDeadlines(context.Context, address.Address, types.TipSetKey) ([]api.Deadline, error)
 	StateMinerPartitions(ctx context.Context, m address.Address, dlIdx uint64, tsk types.TipSetKey) ([]api.Partition, error)
 	MpoolPushMessage(context.Context, *types.Message, *api.MessageSendSpec) (*types.SignedMessage, error)
+	MpoolGetNonce(context.Context, address.Address) (uint64, error)
+	MpoolPending(context.Context, types.TipSetKey) ([]*types.SignedMessage, error)
+	GasEstimateMessageGas(context.Context, *types.Message, *api.MessageSendSpec, types.TipSetKey) (*types.Message, error)
 	ChainHead(ctx context.Context) (*types.TipSet, error)
 	ChainGetMessage(ctx context.Context, mc cid.Cid) (*types.Message, error)
+	ChainGetBlockMessages(ctx context.Context, blockCid cid.Cid) (*api.BlockMessages, error)
 	StateGetRandomnessFromBeacon(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error)
 	StateGetRandomnessFromTickets(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error)
 	ChainReadObj(context.Context, cid.Cid) ([]byte, error)
@@ -168,7 +173,12 @@ type pendingPiece struct {
 	accepted func(abi.SectorNumber, abi.UnpaddedPieceSize, error)
 }
 
-func New(mctx context.Context, api SealingAPI, fc config.MinerFeeConfig, events Events, maddr address.Address, ds datastore.Batching, sealer sealer.SectorManager, verif storiface.Verifier, prov storiface.Prover, pcp PreCommitPolicy, gc dtypes.GetSealingConfigFunc, journal journal.Journal, addrSel AddressSelector) *Sealing {
+func New(mctx context.Context, api SealingAPI, fc config.MinerFeeConfig, events Events, maddr address.Address, ds datastore.Batching, sealer sealer.SectorManager, verif storiface.Verifier, prov storiface.Prover, pcp PreCommitPolicy, gc dtypes.GetSealingConfigFunc, journal journal.Journal, addrSel AddressSelector) (*Sealing, error) {
+	precommiter, err := NewPreCommitBatcher(mctx, maddr, api, addrSel, fc, gc, nil, NewDatastoreBatcherStore(ds), nil)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &Sealing{
 		Api:      api,
 		DealInfo: &CurrentDealInfoManager{api},
@@ -196,7 +206,7 @@ func New(mctx context.Context, api SealingAPI, fc config.MinerFeeConfig, events
 		addrSel: addrSel,
 
 		terminator:  NewTerminationBatcher(mctx, maddr, api, addrSel, fc, gc),
-		precommiter: NewPreCommitBatcher(mctx, maddr, api, addrSel, fc, gc),
+		precommiter: precommiter,
 		commiter:    NewCommitBatcher(mctx, maddr, api, addrSel, fc, gc, prov),
 
 		getConfig: gc,
@@ -209,6 +219,11 @@ func New(mctx context.Context, api SealingAPI, fc config.MinerFeeConfig, events
 		},
 	}
 
+	// precommits and commits are usually sent from the same addresses
+	funds := NewFundsCoordinator(api)
+	s.precommiter.SetFundsCoordinator(funds)
+	s.commiter.SetFundsCoordinator(funds)
+
 	s.notifee = func(before, after SectorInfo) {
 		s.journal.RecordEvent(s.sealingEvtType, func() interface{} {
 			return SealingStateEvt{
@@ -225,7 +240,7 @@ func New(mctx context.Context, api SealingAPI, fc config.MinerFeeConfig, events
 
 	s.sectors = statemachine.New(namespace.Wrap(ds, datastore.NewKey(SectorStorePrefix)), s, SectorInfo{})
 
-	return s
+	return s, nil
 }
 
 func (m *Sealing) Run(ctx context.Context) {
@@ -273,6 +288,10 @@ func (m *Sealing) SectorPreCommitPending(ctx context.Context) ([]abi.SectorID, e
 	return m.precommiter.Pending(ctx)
 }
 
+func (m *Sealing) SectorPreCommitPreviewSendAddress(ctx context.Context) (address.Address, error) {
+	return m.precommiter.PreviewSendAddress(ctx)
+}
+
 func (m *Sealing) CommitFlush(ctx context.Context) ([]sealiface.CommitBatchRes, error) {
 	return m.commiter.Flush(ctx)
 }
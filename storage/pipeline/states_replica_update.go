@@ -154,7 +154,7 @@ func (m *Sealing) handleSubmitReplicaUpdate(ctx statemachine.Context, sector Sec
 		collateral = big.Zero()
 	}
 
-	collateral, err = collateralSendAmount(ctx.Context(), m.Api, m.maddr, cfg, collateral)
+	collateral, err = collateralSendAmount(ctx.Context(), m.Api, m.maddr, types.EmptyTSK, cfg, collateral)
 	if err != nil {
 		log.Errorf("collateral send amount failed not proceeding: %+v", err)
 		return nil
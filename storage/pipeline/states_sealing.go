@@ -389,7 +389,7 @@ func (m *Sealing) handlePreCommitting(ctx statemachine.Context, sector SectorInf
 		return nil // event was sent in preCommitParams
 	}
 
-	deposit, err := collateralSendAmount(ctx.Context(), m.Api, m.maddr, cfg, pcd)
+	deposit, err := collateralSendAmount(ctx.Context(), m.Api, m.maddr, types.EmptyTSK, cfg, pcd)
 	if err != nil {
 		return err
 	}
@@ -660,7 +660,7 @@ func (m *Sealing) handleSubmitCommit(ctx statemachine.Context, sector SectorInfo
 		collateral = big.Zero()
 	}
 
-	collateral, err = collateralSendAmount(ctx.Context(), m.Api, m.maddr, cfg, collateral)
+	collateral, err = collateralSendAmount(ctx.Context(), m.Api, m.maddr, types.EmptyTSK, cfg, collateral)
 	if err != nil {
 		return err
 	}
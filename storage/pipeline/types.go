@@ -132,6 +132,20 @@ func (t *SectorInfo) dealIDs() []abi.DealID {
 	return out
 }
 
+// dealValue returns the sum of storage payments of deals in the sector
+func (t *SectorInfo) dealValue() abi.TokenAmount {
+	out := big.Zero()
+	for _, p := range t.Pieces {
+		if p.DealInfo == nil || p.DealInfo.DealProposal == nil {
+			continue
+		}
+
+		dp := p.DealInfo.DealProposal
+		out = big.Add(out, big.Mul(dp.StoragePricePerEpoch, big.NewInt(int64(dp.EndEpoch-dp.StartEpoch))))
+	}
+	return out
+}
+
 func (t *SectorInfo) existingPieceSizes() []abi.UnpaddedPieceSize {
 	out := make([]abi.UnpaddedPieceSize, len(t.Pieces))
 	for i, p := range t.Pieces {
@@ -68,13 +68,13 @@ func (m *Sealing) GetSectorInfo(sid abi.SectorNumber) (SectorInfo, error) {
 
 func collateralSendAmount(ctx context.Context, api interface {
 	StateMinerAvailableBalance(context.Context, address.Address, types.TipSetKey) (big.Int, error)
-}, maddr address.Address, cfg sealiface.Config, collateral abi.TokenAmount) (abi.TokenAmount, error) {
+}, maddr address.Address, tsk types.TipSetKey, cfg sealiface.Config, collateral abi.TokenAmount) (abi.TokenAmount, error) {
 	if cfg.CollateralFromMinerBalance {
 		if cfg.DisableCollateralFallback {
 			return big.Zero(), nil
 		}
 
-		avail, err := api.StateMinerAvailableBalance(ctx, maddr, types.EmptyTSK)
+		avail, err := api.StateMinerAvailableBalance(ctx, maddr, tsk)
 		if err != nil {
 			return big.Zero(), xerrors.Errorf("getting available miner balance: %w", err)
 		}